@@ -63,6 +63,12 @@ func (a *AssembledResult) Evaluate(str string, fieldWidth int, signed bool) (Eva
 		return EvaluationResult{Value: int64(reg), Type: EvaluationTypeRegister, MatchedValue: str}, nil
 	}
 
+	// check if it is a floating point register
+	reg, ok = FloatRegisterNameMap[strings.ToLower(str)]
+	if ok {
+		return EvaluationResult{Value: int64(reg), Type: EvaluationTypeFloatRegister, MatchedValue: str}, nil
+	}
+
 	// check if it is a base 16 integer (must have 0x prefix)
 	if len(str) > 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X') {
 		// check if too many digits for the fieldWidth
@@ -521,7 +527,13 @@ func (a *AssembledResult) parseITypeMemInstruction(line string, diff, lineNum in
 
 	// parse operand 1
 	dest, e := a.Evaluate(operand1, 0, false)
-	if e != nil {
+	if opcode == "flw" && (e != nil || dest.Type != EvaluationTypeFloatRegister) {
+		offset := diff + len(opcode) + 1
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidFloatRegister(operand1, TextRange{
+			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand1)},
+		}))
+		return 0, false
+	} else if e != nil {
 		offset := diff + len(opcode) + 1
 		a.Diagnostics = append(a.Diagnostics, Errors.InvalidRegister(operand1, TextRange{
 			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand1)},
@@ -601,6 +613,9 @@ func (a *AssembledResult) parseITypeMemInstruction(line string, diff, lineNum in
 	case "lhu":
 		deOp = 0b0000011
 		func3 = 0b101
+	case "flw":
+		deOp = OPCODE_FLW
+		func3 = 0b010
 	}
 
 	a.AddressToLine[a.currentAddress] = lineNum
@@ -641,7 +656,13 @@ func (a *AssembledResult) parseSTypeInstruction(line string, diff int, lineNum i
 
 	// parse operand 1
 	src, e := a.Evaluate(operand1, 0, false)
-	if e != nil {
+	if opcode == "fsw" && (e != nil || src.Type != EvaluationTypeFloatRegister) {
+		offset := diff + len(opcode) + 1
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidFloatRegister(operand1, TextRange{
+			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand1)},
+		}))
+		return 0, false
+	} else if e != nil {
 		offset := diff + len(opcode) + 1
 		a.Diagnostics = append(a.Diagnostics, Errors.InvalidRegister(operand1, TextRange{
 			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand1)},
@@ -714,6 +735,9 @@ func (a *AssembledResult) parseSTypeInstruction(line string, diff int, lineNum i
 	case "sw":
 		deOp = 0b0100011
 		func3 = 0b010
+	case "fsw":
+		deOp = OPCODE_FSW
+		func3 = 0b010
 	}
 
 	a.AddressToLine[a.currentAddress] = lineNum
@@ -1009,6 +1033,9 @@ func (a *AssembledResult) parseITypeInstructionWithoutArguments(line string, dif
 	case "ebreak":
 		deop = 0b1110011
 		immValue = 1
+	case "mret":
+		deop = 0b1110011
+		immValue = 0b001100000010
 	}
 
 	a.AddressToLine[a.currentAddress] = lineNum
@@ -1016,6 +1043,442 @@ func (a *AssembledResult) parseITypeInstructionWithoutArguments(line string, dif
 	return makeITypeInstruction(deop, 0, 0, immValue, 0), true
 }
 
+func (a *AssembledResult) parseCSRInstruction(line string, diff, lineNum int, opcode string) (uint32, bool) {
+	// format is <opcode> <reg>, <csr>, <reg|uimm>, the pseudo-instructions leave out the implied operands
+
+	func3 := uint32(0)
+	format := ""
+	impliedCSR := ""
+	switch opcode {
+	case "csrrw":
+		func3 = 0b001
+		format = "<opcode> <reg>, <csr>, <reg>"
+	case "csrrs":
+		func3 = 0b010
+		format = "<opcode> <reg>, <csr>, <reg>"
+	case "csrrc":
+		func3 = 0b011
+		format = "<opcode> <reg>, <csr>, <reg>"
+	case "csrrwi":
+		func3 = 0b101
+		format = "<opcode> <reg>, <csr>, <uimm>"
+	case "csrrsi":
+		func3 = 0b110
+		format = "<opcode> <reg>, <csr>, <uimm>"
+	case "csrrci":
+		func3 = 0b111
+		format = "<opcode> <reg>, <csr>, <uimm>"
+	case "csrr":
+		func3 = 0b010
+		format = "<opcode> <reg>, <csr>"
+	case "csrw":
+		func3 = 0b001
+		format = "<opcode> <csr>, <reg>"
+	case "csrs":
+		func3 = 0b010
+		format = "<opcode> <csr>, <reg>"
+	case "csrc":
+		func3 = 0b011
+		format = "<opcode> <csr>, <reg>"
+	case "csrwi":
+		func3 = 0b101
+		format = "<opcode> <csr>, <uimm>"
+	case "csrsi":
+		func3 = 0b110
+		format = "<opcode> <csr>, <uimm>"
+	case "csrci":
+		func3 = 0b111
+		format = "<opcode> <csr>, <uimm>"
+	case "rdcycle", "rdcycleh", "rdtime", "rdtimeh", "rdinstret", "rdinstreth":
+		func3 = 0b010
+		format = "<opcode> <reg>"
+		impliedCSR = opcode[2:]
+	}
+
+	// the operand kinds are taken from the format
+	kinds := strings.Split(strings.ReplaceAll(format[len("<opcode> "):], " ", ""), ",")
+
+	operandsStart := strings.Index(line, " ")
+	if operandsStart == -1 {
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, TextRange{
+			Start: TextPosition{Line: lineNum, Char: diff}, End: TextPosition{Line: lineNum, Char: diff + len(line)},
+		}))
+		return 0, false
+	}
+
+	operands := strings.Split(line[operandsStart+1:], ",")
+	if len(operands) != len(kinds) {
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, TextRange{
+			Start: TextPosition{Line: lineNum, Char: diff}, End: TextPosition{Line: lineNum, Char: diff + len(line)},
+		}))
+		return 0, false
+	}
+
+	rd := uint32(0)
+	rs1 := uint32(0)
+	csr := uint32(0)
+	if impliedCSR != "" {
+		value, _ := strconv.ParseUint(MacroMap[impliedCSR][2:], 16, 32)
+		csr = uint32(value)
+	}
+
+	offset := diff + operandsStart + 1
+	for i, operand := range operands {
+		switch kinds[i] {
+		case "<reg>":
+			reg, err := a.Evaluate(operand, 0, false)
+			if err != nil || reg.Type != EvaluationTypeRegister {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidRegister(operand, TextRange{
+					Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+				}))
+				return 0, false
+			}
+
+			// the first register is always the destination
+			if i == 0 {
+				if slices.Contains(assemblerConfig.SpecialRegisters, strings.TrimSpace(operand)) {
+					// Attempting to modify a special register; throw warning
+					a.Diagnostics = append(a.Diagnostics, Warnings.ModifyingSpecialRegister(operand, TextRange{
+						Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+					}))
+					return 0, false
+				}
+				rd = uint32(reg.Value)
+			} else {
+				rs1 = uint32(reg.Value)
+			}
+		case "<csr>":
+			// CSRs are 12-bit unsigned numbers, usually given by name
+			value, err := a.Evaluate(operand, 12, false)
+			if (err != nil && EvaluationErrors.IsImmOverflowError(err)) || (err == nil && value.Type == EvaluationTypeUnsignedIntegerLiteral && value.Value > 0xFFF) {
+				a.Diagnostics = append(a.Diagnostics, Errors.UnsignedImmediateOverflow(operand, 12, TextRange{
+					Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+				}))
+				return 0, false
+			} else if err != nil || value.Type != EvaluationTypeUnsignedIntegerLiteral {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidCSR(operand, TextRange{
+					Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+				}))
+				return 0, false
+			}
+			csr = uint32(value.Value)
+		case "<uimm>":
+			// the immediate is encoded in the rs1 field
+			value, err := a.Evaluate(operand, 12, false)
+			if err != nil || value.Type != EvaluationTypeUnsignedIntegerLiteral {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidUnsignedIntegerLiteral(operand, TextRange{
+					Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+				}))
+				return 0, false
+			} else if value.Value > 31 {
+				a.Diagnostics = append(a.Diagnostics, Errors.UnsignedImmediateOverflow(operand, 5, TextRange{
+					Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+				}))
+				return 0, false
+			}
+			rs1 = uint32(value.Value)
+		}
+		offset += len(operand) + 1
+	}
+
+	a.AddressToLine[a.currentAddress] = lineNum
+	a.currentAddress += 4 // preparing for the next instruction
+	return makeITypeInstruction(OPCODE_ENV, rd, rs1, csr, func3), true
+}
+
+func (a *AssembledResult) parseFloatInstruction(line string, diff, lineNum int, opcode string) (uint32, bool) {
+	// format is <opcode> <reg|freg>, <reg|freg>[, <freg>[, <freg>]][, <rm>], the registers are rd, rs1, rs2, then rs3
+
+	format := ""
+	deop := uint32(OPCODE_FP)
+	func7 := uint32(0)
+	func3 := uint32(0)
+	rs2 := uint32(0)
+	hasRoundingMode := false
+	duplicateSource := false // the single source is used for rs1 and rs2
+	switch opcode {
+	case "fadd.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0000000
+		hasRoundingMode = true
+	case "fsub.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0000100
+		hasRoundingMode = true
+	case "fmul.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0001000
+		hasRoundingMode = true
+	case "fdiv.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0001100
+		hasRoundingMode = true
+	case "fsqrt.s":
+		format = "<opcode> <freg>, <freg>"
+		func7 = 0b0101100
+		hasRoundingMode = true
+	case "fsgnj.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b000
+	case "fsgnjn.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b001
+	case "fsgnjx.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b010
+	case "fmv.s":
+		format = "<opcode> <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b000
+		duplicateSource = true
+	case "fneg.s":
+		format = "<opcode> <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b001
+		duplicateSource = true
+	case "fabs.s":
+		format = "<opcode> <freg>, <freg>"
+		func7 = 0b0010000
+		func3 = 0b010
+		duplicateSource = true
+	case "fmin.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0010100
+		func3 = 0b000
+	case "fmax.s":
+		format = "<opcode> <freg>, <freg>, <freg>"
+		func7 = 0b0010100
+		func3 = 0b001
+	case "fcvt.w.s":
+		format = "<opcode> <reg>, <freg>"
+		func7 = 0b1100000
+		rs2 = 0
+		hasRoundingMode = true
+	case "fcvt.wu.s":
+		format = "<opcode> <reg>, <freg>"
+		func7 = 0b1100000
+		rs2 = 1
+		hasRoundingMode = true
+	case "fcvt.s.w":
+		format = "<opcode> <freg>, <reg>"
+		func7 = 0b1101000
+		rs2 = 0
+		hasRoundingMode = true
+	case "fcvt.s.wu":
+		format = "<opcode> <freg>, <reg>"
+		func7 = 0b1101000
+		rs2 = 1
+		hasRoundingMode = true
+	case "fmv.x.w", "fmv.x.s":
+		format = "<opcode> <reg>, <freg>"
+		func7 = 0b1110000
+		func3 = 0b000
+	case "fclass.s":
+		format = "<opcode> <reg>, <freg>"
+		func7 = 0b1110000
+		func3 = 0b001
+	case "fmv.w.x", "fmv.s.x":
+		format = "<opcode> <freg>, <reg>"
+		func7 = 0b1111000
+		func3 = 0b000
+	case "feq.s":
+		format = "<opcode> <reg>, <freg>, <freg>"
+		func7 = 0b1010000
+		func3 = 0b010
+	case "flt.s":
+		format = "<opcode> <reg>, <freg>, <freg>"
+		func7 = 0b1010000
+		func3 = 0b001
+	case "fle.s":
+		format = "<opcode> <reg>, <freg>, <freg>"
+		func7 = 0b1010000
+		func3 = 0b000
+	case "fmadd.s":
+		format = "<opcode> <freg>, <freg>, <freg>, <freg>"
+		deop = OPCODE_FMADD
+		hasRoundingMode = true
+	case "fmsub.s":
+		format = "<opcode> <freg>, <freg>, <freg>, <freg>"
+		deop = OPCODE_FMSUB
+		hasRoundingMode = true
+	case "fnmsub.s":
+		format = "<opcode> <freg>, <freg>, <freg>, <freg>"
+		deop = OPCODE_FNMSUB
+		hasRoundingMode = true
+	case "fnmadd.s":
+		format = "<opcode> <freg>, <freg>, <freg>, <freg>"
+		deop = OPCODE_FNMADD
+		hasRoundingMode = true
+	}
+
+	// the operand kinds are taken from the format
+	kinds := strings.Split(strings.ReplaceAll(format[len("<opcode> "):], " ", ""), ",")
+	if hasRoundingMode {
+		format += "[, <rm>]"
+	}
+
+	operandsStart := strings.Index(line, " ")
+	if operandsStart == -1 {
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, TextRange{
+			Start: TextPosition{Line: lineNum, Char: diff}, End: TextPosition{Line: lineNum, Char: diff + len(line)},
+		}))
+		return 0, false
+	}
+
+	// the rounding mode is optional and defaults to the dynamic rounding mode in frm
+	operands := strings.Split(line[operandsStart+1:], ",")
+	if hasRoundingMode && len(operands) == len(kinds)+1 {
+		kinds = append(kinds, "<rm>")
+	}
+	if len(operands) != len(kinds) {
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, TextRange{
+			Start: TextPosition{Line: lineNum, Char: diff}, End: TextPosition{Line: lineNum, Char: diff + len(line)},
+		}))
+		return 0, false
+	}
+
+	registers := []uint32{}
+	if hasRoundingMode {
+		func3 = 0b111 // dynamic
+	}
+
+	offset := diff + operandsStart + 1
+	for i, operand := range operands {
+		operandRange := TextRange{
+			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+		}
+
+		switch kinds[i] {
+		case "<reg>":
+			reg, err := a.Evaluate(operand, 0, false)
+			if err != nil || reg.Type != EvaluationTypeRegister {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidRegister(operand, operandRange))
+				return 0, false
+			} else if i == 0 && slices.Contains(assemblerConfig.SpecialRegisters, strings.TrimSpace(operand)) {
+				// Attempting to modify a special register; throw warning
+				a.Diagnostics = append(a.Diagnostics, Warnings.ModifyingSpecialRegister(operand, operandRange))
+				return 0, false
+			}
+			registers = append(registers, uint32(reg.Value))
+		case "<freg>":
+			reg, err := a.Evaluate(operand, 0, false)
+			if err != nil || reg.Type != EvaluationTypeFloatRegister {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidFloatRegister(operand, operandRange))
+				return 0, false
+			}
+			registers = append(registers, uint32(reg.Value))
+		case "<rm>":
+			mode, ok := RoundingModeMap[strings.ToLower(strings.TrimSpace(operand))]
+			if !ok {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidRoundingMode(operand, operandRange))
+				return 0, false
+			}
+			func3 = mode
+		}
+		offset += len(operand) + 1
+	}
+
+	if duplicateSource {
+		registers = append(registers, registers[1])
+	}
+
+	a.AddressToLine[a.currentAddress] = lineNum
+	a.currentAddress += 4 // preparing for the next instruction
+	if len(registers) == 4 {
+		return makeR4TypeInstruction(deop, registers[0], registers[1], registers[2], registers[3], 0b00, func3), true
+	} else if len(registers) == 3 {
+		rs2 = registers[2]
+	}
+	return makeRTypeInstruction(deop, registers[0], registers[1], rs2, func7, func3), true
+}
+
+// Splits the ordering suffix off of an A extension opcode, returns the aq and rl bits
+func trimAtomicOrdering(opcode string) (string, uint32) {
+	if strings.HasSuffix(opcode, ".aqrl") {
+		return strings.TrimSuffix(opcode, ".aqrl"), 0b11
+	} else if strings.HasSuffix(opcode, ".aq") {
+		return strings.TrimSuffix(opcode, ".aq"), 0b10
+	} else if strings.HasSuffix(opcode, ".rl") {
+		return strings.TrimSuffix(opcode, ".rl"), 0b01
+	}
+	return opcode, 0b00
+}
+
+func isAtomicInstruction(opcode string) bool {
+	base, _ := trimAtomicOrdering(opcode)
+	_, ok := AtomicFunct5Map[base]
+	return ok
+}
+
+func (a *AssembledResult) parseAtomicInstruction(line string, diff, lineNum int, opcode string) (uint32, bool) {
+	// format is <opcode> <reg>, <reg>, (<reg>) with the registers rd, rs2, then rs1, lr.w has no rs2
+
+	base, ordering := trimAtomicOrdering(opcode)
+	format := "<opcode> <reg>, <reg>, (<reg>)"
+	if base == "lr.w" {
+		format = "<opcode> <reg>, (<reg>)"
+	}
+	numOperands := strings.Count(format, ",") + 1
+
+	operandsStart := strings.Index(line, " ")
+	if operandsStart == -1 || len(strings.Split(line[operandsStart+1:], ",")) != numOperands {
+		a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, TextRange{
+			Start: TextPosition{Line: lineNum, Char: diff}, End: TextPosition{Line: lineNum, Char: diff + len(line)},
+		}))
+		return 0, false
+	}
+
+	registers := []uint32{}
+	offset := diff + operandsStart + 1
+	for i, operand := range strings.Split(line[operandsStart+1:], ",") {
+		operandRange := TextRange{
+			Start: TextPosition{Line: lineNum, Char: offset}, End: TextPosition{Line: lineNum, Char: offset + len(operand)},
+		}
+		offset += len(operand) + 1
+
+		if i == numOperands-1 {
+			// the address register is in parentheses, an offset is allowed as long as it is zero
+			trimmed := strings.TrimSpace(operand)
+			if !strings.Contains(trimmed, "(") || !strings.HasSuffix(trimmed, ")") {
+				a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, operandRange))
+				return 0, false
+			}
+
+			if imm := trimmed[:strings.Index(trimmed, "(")]; strings.TrimSpace(imm) != "" {
+				value, err := a.Evaluate(imm, 12, true)
+				if err != nil || value.Type == EvaluationTypeRegister || value.Value != 0 {
+					a.Diagnostics = append(a.Diagnostics, Errors.InvalidInstructionFormat(format, opcode, operandRange))
+					return 0, false
+				}
+			}
+			operand = trimmed[strings.Index(trimmed, "(")+1 : len(trimmed)-1]
+		}
+
+		reg, err := a.Evaluate(operand, 0, false)
+		if err != nil || reg.Type != EvaluationTypeRegister {
+			a.Diagnostics = append(a.Diagnostics, Errors.InvalidRegister(operand, operandRange))
+			return 0, false
+		} else if i == 0 && slices.Contains(assemblerConfig.SpecialRegisters, strings.TrimSpace(operand)) {
+			// Attempting to modify a special register; throw warning
+			a.Diagnostics = append(a.Diagnostics, Warnings.ModifyingSpecialRegister(operand, operandRange))
+			return 0, false
+		}
+		registers = append(registers, uint32(reg.Value))
+	}
+
+	rs2 := uint32(0)
+	if len(registers) == 3 {
+		rs2 = registers[1]
+	}
+
+	a.AddressToLine[a.currentAddress] = lineNum
+	a.currentAddress += 4 // preparing for the next instruction
+	return makeRTypeInstruction(OPCODE_AMO, registers[0], registers[len(registers)-1], rs2, AtomicFunct5Map[base]<<2|ordering, 0b010), true
+}
+
 func (a *AssembledResult) resolveLabelLinkRequests() {
 	for _, request := range a.labelLinkRequests {
 		labelAddr := a.Labels[request.labelName]
@@ -1184,7 +1647,8 @@ func (a *AssembledResult) parseLines() {
 				opcode == "lh" ||
 				opcode == "lw" ||
 				opcode == "lbu" ||
-				opcode == "lhu" {
+				opcode == "lhu" ||
+				opcode == "flw" {
 				// I-type instruction, but with memory notation
 				code, ok := a.parseITypeMemInstruction(line, diff, i, opcode)
 				if ok {
@@ -1192,7 +1656,8 @@ func (a *AssembledResult) parseLines() {
 				}
 			} else if opcode == "sb" ||
 				opcode == "sh" ||
-				opcode == "sw" {
+				opcode == "sw" ||
+				opcode == "fsw" {
 				// S-type instruction
 				code, ok := a.parseSTypeInstruction(line, diff, i, opcode)
 				if ok {
@@ -1222,8 +1687,73 @@ func (a *AssembledResult) parseLines() {
 				if ok {
 					a.ProgramText = append(a.ProgramText, code)
 				}
+			} else if opcode == "csrrw" ||
+				opcode == "csrrs" ||
+				opcode == "csrrc" ||
+				opcode == "csrrwi" ||
+				opcode == "csrrsi" ||
+				opcode == "csrrci" ||
+				opcode == "csrr" ||
+				opcode == "csrw" ||
+				opcode == "csrs" ||
+				opcode == "csrc" ||
+				opcode == "csrwi" ||
+				opcode == "csrsi" ||
+				opcode == "csrci" ||
+				opcode == "rdcycle" ||
+				opcode == "rdcycleh" ||
+				opcode == "rdtime" ||
+				opcode == "rdtimeh" ||
+				opcode == "rdinstret" ||
+				opcode == "rdinstreth" {
+				// Zicsr instruction (I-type with the CSR as the immediate)
+				code, ok := a.parseCSRInstruction(line, diff, i, opcode)
+				if ok {
+					a.ProgramText = append(a.ProgramText, code)
+				}
+			} else if opcode == "fadd.s" ||
+				opcode == "fsub.s" ||
+				opcode == "fmul.s" ||
+				opcode == "fdiv.s" ||
+				opcode == "fsqrt.s" ||
+				opcode == "fsgnj.s" ||
+				opcode == "fsgnjn.s" ||
+				opcode == "fsgnjx.s" ||
+				opcode == "fmv.s" ||
+				opcode == "fneg.s" ||
+				opcode == "fabs.s" ||
+				opcode == "fmin.s" ||
+				opcode == "fmax.s" ||
+				opcode == "fcvt.w.s" ||
+				opcode == "fcvt.wu.s" ||
+				opcode == "fcvt.s.w" ||
+				opcode == "fcvt.s.wu" ||
+				opcode == "fmv.x.w" ||
+				opcode == "fmv.x.s" ||
+				opcode == "fclass.s" ||
+				opcode == "fmv.w.x" ||
+				opcode == "fmv.s.x" ||
+				opcode == "feq.s" ||
+				opcode == "flt.s" ||
+				opcode == "fle.s" ||
+				opcode == "fmadd.s" ||
+				opcode == "fmsub.s" ||
+				opcode == "fnmsub.s" ||
+				opcode == "fnmadd.s" {
+				// F extension instruction (R-type, or R4-type for the fused multiply-adds)
+				code, ok := a.parseFloatInstruction(line, diff, i, opcode)
+				if ok {
+					a.ProgramText = append(a.ProgramText, code)
+				}
+			} else if isAtomicInstruction(opcode) {
+				// A extension instruction (R-type with the ordering bits in func7)
+				code, ok := a.parseAtomicInstruction(line, diff, i, opcode)
+				if ok {
+					a.ProgramText = append(a.ProgramText, code)
+				}
 			} else if opcode == "ecall" ||
-				opcode == "ebreak" {
+				opcode == "ebreak" ||
+				opcode == "mret" {
 				// I-type instruction, but with no operands
 				code, ok := a.parseITypeInstructionWithoutArguments(line, diff, i, opcode)
 				if ok {
@@ -1253,6 +1783,7 @@ func (a *AssembledResult) parseLines() {
 			// now to find how much to allocate and what to allocate
 			// format is one of
 			//.word <value1>, <value2>, <value3>, ...
+			//.float <value1>, <value2>, <value3>, ...
 			//.ascii <string>
 			//.space <size>
 			//.alloc <size>
@@ -1273,6 +1804,20 @@ func (a *AssembledResult) parseLines() {
 					}
 					a.ProgramData = append(a.ProgramData, uint32(evalRes.Value))
 				}
+			} else if dType == ".float" {
+				charOffset := diff + len(dType) + 1
+				for _, value := range values {
+					floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+					if err != nil {
+						// error
+						a.Diagnostics = append(a.Diagnostics, Errors.InvalidDataSectionValue(value, TextRange{
+							Start: TextPosition{Line: i, Char: charOffset},
+							End:   TextPosition{Line: i, Char: charOffset + len(value)},
+						}))
+					}
+					charOffset += len(value) + 1
+					a.ProgramData = append(a.ProgramData, math.Float32bits(float32(floatValue)))
+				}
 			} else if dType == ".ascii" {
 				charOffset := diff + len(dType) + 1
 
@@ -57,6 +57,161 @@ func TestProgramJumps(t *testing.T) {
 	validateResult(t, program, expected, nil, nil)
 }
 
+func TestProgramCSR(t *testing.T) {
+	source := `
+	.text
+		csrrw x1, mstatus, x2
+		csrr x5, cycle
+		csrwi mtvec, 5
+		rdinstret x10
+		mret
+	`
+
+	expected := []uint32{
+		0x300110f3,
+		0xc00022f3,
+		0x3052d073,
+		0xc0202573,
+		0x30200073,
+	}
+
+	program := assembler.Assemble(source)
+	validateResult(t, program, expected, nil, nil)
+}
+
+func TestProgramFloat(t *testing.T) {
+	source := `
+	.text
+		flw f1, 4(x2)
+		fsw ft1, 8(sp)
+		fadd.s f3, f1, f2
+		fcvt.w.s x10, f1, rtz
+		fmadd.s f4, f1, f2, f3
+		fmv.s f5, f6
+	`
+
+	expected := []uint32{
+		0x00412087,
+		0x00112427,
+		0x0020f1d3,
+		0xc0009553,
+		0x1820f243,
+		0x206302d3,
+	}
+
+	program := assembler.Assemble(source)
+	validateResult(t, program, expected, nil, nil)
+}
+
+func TestProgramAtomic(t *testing.T) {
+	source := `
+	.text
+		lr.w a0, (a1)
+		sc.w t0, a2, (a1)
+		amoadd.w.aqrl a0, a2, (a1)
+		amoswap.w a0, a2, 0(a1)
+	`
+
+	expected := []uint32{
+		0x1005a52f,
+		0x18c5a2af,
+		0x06c5a52f,
+		0x08c5a52f,
+	}
+
+	program := assembler.Assemble(source)
+	validateResult(t, program, expected, nil, nil)
+}
+
+func TestExpandCompressed(t *testing.T) {
+	expected := map[uint32]uint32{
+		0x1141: 0xff010113, // c.addi16sp sp, -16
+		0xc606: 0x00112623, // c.swsp ra, 12(sp)
+		0x4532: 0x00c12503, // c.lwsp a0, 12(sp)
+		0x0808: 0x01010513, // c.addi4spn a0, sp, 16
+		0x2021: 0x008000ef, // c.jal 8
+		0x8082: 0x00008067, // c.jr ra
+		0x85aa: 0x00a005b3, // c.mv a1, a0
+	}
+
+	for compressed, instruction := range expected {
+		expanded, ok := assembler.ExpandCompressedInstruction(compressed)
+		if !ok || expanded != instruction {
+			t.Errorf("Expected 0x%04x to expand to 0x%08x, got 0x%08x", compressed, instruction, expanded)
+		}
+	}
+
+	if _, ok := assembler.ExpandCompressedInstruction(0x0000); ok {
+		t.Errorf("Expected 0x0000 to be an illegal instruction")
+	}
+}
+
+// Every disassembled instruction has to assemble back to the same instruction
+func TestDisassemble(t *testing.T) {
+	instructions := []string{
+		"lui x5, 0x12345",
+		"auipc x6, 0xFFFFF",
+		"jalr x0, x1, 0",
+		"bgeu x5, x6, 12",
+		"lbu x7, -4(x2)",
+		"sw x10, 64(x3)",
+		"srai x5, x6, 3",
+		"sltiu x5, x6, 4095",
+		"sub x5, x6, x7",
+		"mulhsu x5, x6, x7",
+		"ecall",
+		"mret",
+		"csrrw x5, 0x305, x6",
+		"csrrci x0, 0x300, 8",
+		"flw f1, 4(x5)",
+		"fsw f2, -4(x5)",
+		"fadd.s f1, f2, f3",
+		"fdiv.s f1, f2, f3, rtz",
+		"fcvt.wu.s x5, f1, rtz",
+		"fmv.w.x f1, x5",
+		"fle.s x5, f1, f2",
+		"fnmadd.s f1, f2, f3, f4",
+		"lr.w.aq x5, (x6)",
+		"amoswap.w.aqrl x5, x7, (x6)",
+	}
+
+	for _, instruction := range instructions {
+		program := assembler.Assemble(".text\n" + instruction)
+		if len(program.Diagnostics) != 0 || len(program.ProgramText) != 1 {
+			t.Errorf("Could not assemble %q", instruction)
+			continue
+		}
+		if disassembled := assembler.Disassemble(program.ProgramText[0]); disassembled != instruction {
+			t.Errorf("Expected 0x%08x to disassemble to %q, got %q", program.ProgramText[0], instruction, disassembled)
+		}
+	}
+
+	if disassembled := assembler.Disassemble(0x008000ef); disassembled != "jal x1, 8" {
+		t.Errorf("Expected jal to disassemble with the offset of its target, got %q", disassembled)
+	}
+	if disassembled := assembler.Disassemble(0x1141); disassembled != "addi x2, x2, -16" {
+		t.Errorf("Expected c.addi16sp to disassemble to its expansion, got %q", disassembled)
+	}
+	if disassembled := assembler.Disassemble(0xFFFFFFFF); disassembled != ".word 0xFFFFFFFF" {
+		t.Errorf("Expected an unsupported instruction to disassemble to a .word, got %q", disassembled)
+	}
+}
+
+func TestDataFloat(t *testing.T) {
+	source := `
+	.data
+	MyFloats: .float 1.5, -2
+	`
+
+	expected := []uint32{
+		0x3fc00000,
+		0xc0000000,
+	}
+
+	program := assembler.Assemble(source)
+	validateResult(t, program, nil, expected, nil)
+}
+
 func TestDataWord(t *testing.T) {
 	source := `
 	.data
@@ -9,6 +9,10 @@ func makeITypeInstruction(opcode, rd, rs1, imm, func3 uint32) uint32 {
 	return (imm << 20) | (rs1 << 15) | (func3 << 12) | (rd << 7) | opcode
 }
 
+func makeR4TypeInstruction(opcode, rd, rs1, rs2, rs3, fmt, rm uint32) uint32 {
+	return (rs3 << 27) | (fmt << 25) | (rs2 << 20) | (rs1 << 15) | (rm << 12) | (rd << 7) | opcode
+}
+
 func makeSTypeInstruction(opcode, rs1, rs2, imm, func3 uint32) uint32 {
 	imm = imm & 0xFFF
 	return ((imm >> 5) << 25) | (rs2 << 20) | (rs1 << 15) | (func3 << 12) | ((imm & 0x1F) << 7) | opcode
@@ -57,6 +61,17 @@ func DecodeRTypeInstruction(instruction uint32) (opcode, rd, rs1, rs2, func7, fu
 	return
 }
 
+func DecodeR4TypeInstruction(instruction uint32) (opcode, rd, rs1, rs2, rs3, fmt, rm uint32) {
+	opcode = instruction & 0x7F
+	rd = (instruction >> 7) & 0x1F
+	rm = (instruction >> 12) & 0x7
+	rs1 = (instruction >> 15) & 0x1F
+	rs2 = (instruction >> 20) & 0x1F
+	fmt = (instruction >> 25) & 0x3
+	rs3 = (instruction >> 27) & 0x1F
+	return
+}
+
 func DecodeITypeInstruction(instruction uint32) (opcode, rd, rs1, imm, func3 uint32) {
 	opcode = instruction & 0x7F
 	rd = (instruction >> 7) & 0x1F
@@ -120,4 +135,12 @@ const (
 	OPCODE_JALR     = 0b1100111
 	OPCODE_MEMITYPE = 0b0000011
 	OPCODE_ENV      = 0b1110011
+	OPCODE_FLW      = 0b0000111
+	OPCODE_FSW      = 0b0100111
+	OPCODE_FMADD    = 0b1000011
+	OPCODE_FMSUB    = 0b1000111
+	OPCODE_FNMSUB   = 0b1001011
+	OPCODE_FNMADD   = 0b1001111
+	OPCODE_FP       = 0b1010011
+	OPCODE_AMO      = 0b0101111
 )
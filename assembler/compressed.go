@@ -0,0 +1,200 @@
+package assembler
+
+// RV32C compressed instructions. Every compressed instruction is an alias of a regular instruction, so they
+// are expanded to their 32-bit equivalent instead of being executed directly. Double precision loads and
+// stores (c.fld, c.fsd, etc.) are not supported since there is no D extension.
+
+// Returns whether the instruction starting with the given halfword is a 16-bit compressed instruction
+func IsCompressedInstruction(instruction uint32) bool {
+	return instruction&0b11 != 0b11
+}
+
+// Sign extends the low bits of value
+func signExtend(value uint32, bits int) uint32 {
+	return uint32(int32(value<<(32-bits)) >> (32 - bits))
+}
+
+// Returns the bit of the instruction at position from shifted to position to
+func moveBit(instruction uint32, from, to int) uint32 {
+	return ((instruction >> from) & 0x1) << to
+}
+
+// Expands a 16-bit compressed instruction to the equivalent 32-bit instruction, returns false if the
+// instruction is illegal or not supported
+func ExpandCompressedInstruction(instruction uint32) (uint32, bool) {
+	instruction &= 0xFFFF
+	quadrant := instruction & 0b11
+	func3 := (instruction >> 13) & 0b111
+
+	// the full register fields, and the 3-bit fields that can only specify x8-x15
+	rd := (instruction >> 7) & 0x1F
+	rs2 := (instruction >> 2) & 0x1F
+	rdPrime := ((instruction >> 2) & 0b111) + 8
+	rs1Prime := ((instruction >> 7) & 0b111) + 8
+
+	// the 6-bit immediate used by most quadrant 1 and 2 instructions
+	imm6 := moveBit(instruction, 12, 5) | (instruction>>2)&0x1F
+
+	switch quadrant {
+	case 0b00:
+		// offsets of c.lw, c.flw, c.sw, and c.fsw
+		memOffset := (instruction>>10)&0b111<<3 | moveBit(instruction, 6, 2) | moveBit(instruction, 5, 6)
+
+		switch func3 {
+		case 0b000:
+			// C.ADDI4SPN
+			imm := (instruction>>11)&0b11<<4 | (instruction>>7)&0b1111<<6 | moveBit(instruction, 6, 2) | moveBit(instruction, 5, 3)
+			if imm == 0 {
+				return 0, false // includes the all zero illegal instruction
+			}
+			return makeITypeInstruction(OPCODE_ITYPE, rdPrime, 2, imm, 0b000), true
+		case 0b010:
+			// C.LW
+			return makeITypeInstruction(OPCODE_MEMITYPE, rdPrime, rs1Prime, memOffset, 0b010), true
+		case 0b011:
+			// C.FLW
+			return makeITypeInstruction(OPCODE_FLW, rdPrime, rs1Prime, memOffset, 0b010), true
+		case 0b110:
+			// C.SW
+			return makeSTypeInstruction(OPCODE_STYPE, rs1Prime, rdPrime, memOffset, 0b010), true
+		case 0b111:
+			// C.FSW
+			return makeSTypeInstruction(OPCODE_FSW, rs1Prime, rdPrime, memOffset, 0b010), true
+		}
+	case 0b01:
+		// offset of c.jal and c.j
+		jumpOffset := moveBit(instruction, 12, 11) | moveBit(instruction, 11, 4) | (instruction>>9)&0b11<<8 |
+			moveBit(instruction, 8, 10) | moveBit(instruction, 7, 6) | moveBit(instruction, 6, 7) |
+			(instruction>>3)&0b111<<1 | moveBit(instruction, 2, 5)
+		// offset of c.beqz and c.bnez
+		branchOffset := moveBit(instruction, 12, 8) | (instruction>>10)&0b11<<3 | (instruction>>5)&0b11<<6 |
+			(instruction>>3)&0b11<<1 | moveBit(instruction, 2, 5)
+
+		switch func3 {
+		case 0b000:
+			// C.ADDI (C.NOP when rd is x0)
+			return makeITypeInstruction(OPCODE_ITYPE, rd, rd, signExtend(imm6, 6), 0b000), true
+		case 0b001:
+			// C.JAL
+			return makeJTypeInstruction(OPCODE_JAL, 1, signExtend(jumpOffset, 12)), true
+		case 0b010:
+			// C.LI
+			return makeITypeInstruction(OPCODE_ITYPE, rd, 0, signExtend(imm6, 6), 0b000), true
+		case 0b011:
+			if rd == 2 {
+				// C.ADDI16SP
+				imm := moveBit(instruction, 12, 9) | moveBit(instruction, 6, 4) | moveBit(instruction, 5, 6) |
+					(instruction>>3)&0b11<<7 | moveBit(instruction, 2, 5)
+				if imm == 0 {
+					return 0, false
+				}
+				return makeITypeInstruction(OPCODE_ITYPE, 2, 2, signExtend(imm, 10), 0b000), true
+			}
+
+			// C.LUI
+			if imm6 == 0 {
+				return 0, false
+			}
+			return makeUTypeInstruction(OPCODE_LUI, rd, signExtend(imm6, 6)), true
+		case 0b100:
+			switch (instruction >> 10) & 0b11 {
+			case 0b00:
+				// C.SRLI, shamt[5] must be zero for RV32
+				if imm6&0x20 != 0 {
+					return 0, false
+				}
+				return makeITypeInstruction(OPCODE_ITYPE, rs1Prime, rs1Prime, imm6, 0b101), true
+			case 0b01:
+				// C.SRAI
+				if imm6&0x20 != 0 {
+					return 0, false
+				}
+				return makeITypeInstruction(OPCODE_ITYPE, rs1Prime, rs1Prime, 0b0100000<<5|imm6, 0b101), true
+			case 0b10:
+				// C.ANDI
+				return makeITypeInstruction(OPCODE_ITYPE, rs1Prime, rs1Prime, signExtend(imm6, 6), 0b111), true
+			case 0b11:
+				if instruction&(1<<12) != 0 {
+					return 0, false // RV64 only
+				}
+
+				switch (instruction >> 5) & 0b11 {
+				case 0b00:
+					// C.SUB
+					return makeRTypeInstruction(OPCODE_RTYPE, rs1Prime, rs1Prime, rdPrime, 0b0100000, 0b000), true
+				case 0b01:
+					// C.XOR
+					return makeRTypeInstruction(OPCODE_RTYPE, rs1Prime, rs1Prime, rdPrime, 0b0000000, 0b100), true
+				case 0b10:
+					// C.OR
+					return makeRTypeInstruction(OPCODE_RTYPE, rs1Prime, rs1Prime, rdPrime, 0b0000000, 0b110), true
+				case 0b11:
+					// C.AND
+					return makeRTypeInstruction(OPCODE_RTYPE, rs1Prime, rs1Prime, rdPrime, 0b0000000, 0b111), true
+				}
+			}
+		case 0b101:
+			// C.J
+			return makeJTypeInstruction(OPCODE_JAL, 0, signExtend(jumpOffset, 12)), true
+		case 0b110:
+			// C.BEQZ
+			return makeBTypeInstruction(OPCODE_BTYPE, rs1Prime, 0, signExtend(branchOffset, 9), 0b000), true
+		case 0b111:
+			// C.BNEZ
+			return makeBTypeInstruction(OPCODE_BTYPE, rs1Prime, 0, signExtend(branchOffset, 9), 0b001), true
+		}
+	case 0b10:
+		// offset of c.lwsp and c.flwsp
+		loadOffset := moveBit(instruction, 12, 5) | (instruction>>4)&0b111<<2 | (instruction>>2)&0b11<<6
+		// offset of c.swsp and c.fswsp
+		storeOffset := (instruction>>9)&0b1111<<2 | (instruction>>7)&0b11<<6
+
+		switch func3 {
+		case 0b000:
+			// C.SLLI
+			if imm6&0x20 != 0 {
+				return 0, false
+			}
+			return makeITypeInstruction(OPCODE_ITYPE, rd, rd, imm6, 0b001), true
+		case 0b010:
+			// C.LWSP
+			if rd == 0 {
+				return 0, false
+			}
+			return makeITypeInstruction(OPCODE_MEMITYPE, rd, 2, loadOffset, 0b010), true
+		case 0b011:
+			// C.FLWSP
+			return makeITypeInstruction(OPCODE_FLW, rd, 2, loadOffset, 0b010), true
+		case 0b100:
+			if instruction&(1<<12) == 0 {
+				if rs2 == 0 {
+					// C.JR
+					if rd == 0 {
+						return 0, false
+					}
+					return makeITypeInstruction(OPCODE_JALR, 0, rd, 0, 0b000), true
+				}
+				// C.MV
+				return makeRTypeInstruction(OPCODE_RTYPE, rd, 0, rs2, 0b0000000, 0b000), true
+			}
+
+			if rd == 0 && rs2 == 0 {
+				// C.EBREAK
+				return makeITypeInstruction(OPCODE_ENV, 0, 0, 1, 0b000), true
+			} else if rs2 == 0 {
+				// C.JALR
+				return makeITypeInstruction(OPCODE_JALR, 1, rd, 0, 0b000), true
+			}
+			// C.ADD
+			return makeRTypeInstruction(OPCODE_RTYPE, rd, rd, rs2, 0b0000000, 0b000), true
+		case 0b110:
+			// C.SWSP
+			return makeSTypeInstruction(OPCODE_STYPE, 2, rs2, storeOffset, 0b010), true
+		case 0b111:
+			// C.FSWSP
+			return makeSTypeInstruction(OPCODE_FSW, 2, rs2, storeOffset, 0b010), true
+		}
+	}
+
+	return 0, false
+}
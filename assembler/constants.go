@@ -68,11 +68,113 @@ var RegisterNameMap = map[string]int{
 	"zero": 0,
 }
 
+// floating point registers from the F extension, they are a separate register file
+var FloatRegisterNameMap = map[string]int{
+	"f0":   0,
+	"f1":   1,
+	"f2":   2,
+	"f3":   3,
+	"f4":   4,
+	"f5":   5,
+	"f6":   6,
+	"f7":   7,
+	"f8":   8,
+	"f9":   9,
+	"f10":  10,
+	"f11":  11,
+	"f12":  12,
+	"f13":  13,
+	"f14":  14,
+	"f15":  15,
+	"f16":  16,
+	"f17":  17,
+	"f18":  18,
+	"f19":  19,
+	"f20":  20,
+	"f21":  21,
+	"f22":  22,
+	"f23":  23,
+	"f24":  24,
+	"f25":  25,
+	"f26":  26,
+	"f27":  27,
+	"f28":  28,
+	"f29":  29,
+	"f30":  30,
+	"f31":  31,
+	"ft0":  0,
+	"ft1":  1,
+	"ft2":  2,
+	"ft3":  3,
+	"ft4":  4,
+	"ft5":  5,
+	"ft6":  6,
+	"ft7":  7,
+	"fs0":  8,
+	"fs1":  9,
+	"fa0":  10,
+	"fa1":  11,
+	"fa2":  12,
+	"fa3":  13,
+	"fa4":  14,
+	"fa5":  15,
+	"fa6":  16,
+	"fa7":  17,
+	"fs2":  18,
+	"fs3":  19,
+	"fs4":  20,
+	"fs5":  21,
+	"fs6":  22,
+	"fs7":  23,
+	"fs8":  24,
+	"fs9":  25,
+	"fs10": 26,
+	"fs11": 27,
+	"ft8":  28,
+	"ft9":  29,
+	"ft10": 30,
+	"ft11": 31,
+}
+
+// rounding modes of the F extension, given as the optional last operand
+var RoundingModeMap = map[string]uint32{
+	"rne": 0b000, // round to nearest, ties to even
+	"rtz": 0b001, // round towards zero
+	"rdn": 0b010, // round down
+	"rup": 0b011, // round up
+	"rmm": 0b100, // round to nearest, ties to max magnitude
+	"dyn": 0b111, // use the rounding mode in frm
+}
+
+// funct5 of the A extension instructions, the .aq, .rl, and .aqrl suffixes set the ordering bits
+var AtomicFunct5Map = map[string]uint32{
+	"lr.w":      0b00010,
+	"sc.w":      0b00011,
+	"amoswap.w": 0b00001,
+	"amoadd.w":  0b00000,
+	"amoxor.w":  0b00100,
+	"amoand.w":  0b01100,
+	"amoor.w":   0b01000,
+	"amomin.w":  0b10000,
+	"amomax.w":  0b10100,
+	"amominu.w": 0b11000,
+	"amomaxu.w": 0b11100,
+}
+
 var MacroMap = map[string]string{
 	// Instruction Macros
 	"nop": "addi x0, x0, 0",
 
 	// CSR Registers
+	"fflags":         "0x001",
+	"frm":            "0x002",
+	"fcsr":           "0x003",
+	"cycle":          "0xC00",
+	"time":           "0xC01",
+	"instret":        "0xC02",
+	"cycleh":         "0xC80",
+	"timeh":          "0xC81",
+	"instreth":       "0xC82",
 	"mvendorid":      "0xF11",
 	"marchid":        "0xF12",
 	"mimpid":         "0xF13",
@@ -0,0 +1,268 @@
+package assembler
+
+import "fmt"
+
+// Turns machine code back into assembly for execution traces. The output uses the same syntax the assembler
+// accepts, with registers by number and CSRs by address, so an instruction assembles back to the same bits.
+// The exception is jal, whose target is printed as an offset from the instruction like branch targets since
+// the address is not known here, while the assembler only takes a label.
+
+var floatRoundingModeNames = [8]string{"rne", "rtz", "rdn", "rup", "rmm", "", "", "dyn"}
+
+// Returns the assembly of the instruction, compressed instructions are shown as the instruction they expand
+// to. Instructions that cannot be decoded are shown as a .word or .half directive.
+func Disassemble(instruction uint32) string {
+	if IsCompressedInstruction(instruction) {
+		expanded, ok := ExpandCompressedInstruction(instruction)
+		if !ok {
+			return fmt.Sprintf(".half 0x%04X", instruction&0xFFFF)
+		}
+		instruction = expanded
+	}
+
+	if text, ok := disassemble(instruction); ok {
+		return text
+	}
+	return fmt.Sprintf(".word 0x%08X", instruction)
+}
+
+func disassemble(instruction uint32) (string, bool) {
+	switch GetOpCode(instruction) {
+	case OPCODE_LUI, OPCODE_AUIPC:
+		opcode, rd, imm := DecodeUTypeInstruction(instruction)
+		name := "lui"
+		if opcode == OPCODE_AUIPC {
+			name = "auipc"
+		}
+		return fmt.Sprintf("%s x%d, 0x%X", name, rd, imm), true
+	case OPCODE_JAL:
+		_, rd, _ := DecodeJTypeInstruction(instruction)
+		return fmt.Sprintf("jal x%d, %d", rd, getImmediateValue(instruction)), true
+	case OPCODE_JALR:
+		_, rd, rs1, _, func3 := DecodeITypeInstruction(instruction)
+		if func3 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("jalr x%d, x%d, %d", rd, rs1, getImmediateValue(instruction)), true
+	case OPCODE_BTYPE:
+		_, rs1, rs2, _, func3 := DecodeBTypeInstruction(instruction)
+		name := [8]string{"beq", "bne", "", "", "blt", "bge", "bltu", "bgeu"}[func3]
+		if name == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, x%d, %d", name, rs1, rs2, getImmediateValue(instruction)), true
+	case OPCODE_MEMITYPE:
+		_, rd, rs1, _, func3 := DecodeITypeInstruction(instruction)
+		name := [8]string{"lb", "lh", "lw", "", "lbu", "lhu", "", ""}[func3]
+		if name == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, %d(x%d)", name, rd, getImmediateValue(instruction), rs1), true
+	case OPCODE_STYPE:
+		_, rs1, rs2, _, func3 := DecodeSTypeInstruction(instruction)
+		name := [8]string{"sb", "sh", "sw", "", "", "", "", ""}[func3]
+		if name == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, %d(x%d)", name, rs2, getImmediateValue(instruction), rs1), true
+	case OPCODE_ITYPE:
+		return disassembleIType(instruction)
+	case OPCODE_RTYPE:
+		return disassembleRType(instruction)
+	case OPCODE_ENV:
+		return disassembleEnv(instruction)
+	case OPCODE_FLW:
+		_, rd, rs1, _, func3 := DecodeITypeInstruction(instruction)
+		if func3 != 0b010 {
+			return "", false
+		}
+		return fmt.Sprintf("flw f%d, %d(x%d)", rd, int16(instruction>>16)>>4, rs1), true
+	case OPCODE_FSW:
+		_, rs1, rs2, imm, func3 := DecodeSTypeInstruction(instruction)
+		if func3 != 0b010 {
+			return "", false
+		}
+		return fmt.Sprintf("fsw f%d, %d(x%d)", rs2, int16(imm<<4)>>4, rs1), true
+	case OPCODE_FMADD, OPCODE_FMSUB, OPCODE_FNMSUB, OPCODE_FNMADD:
+		opcode, rd, rs1, rs2, rs3, format, rm := DecodeR4TypeInstruction(instruction)
+		if format != 0 {
+			return "", false
+		}
+		name := [4]string{"fmadd.s", "fmsub.s", "fnmsub.s", "fnmadd.s"}[(opcode>>2)&0b11]
+		return withRoundingMode(fmt.Sprintf("%s f%d, f%d, f%d, f%d", name, rd, rs1, rs2, rs3), rm)
+	case OPCODE_FP:
+		return disassembleFloat(instruction)
+	case OPCODE_AMO:
+		return disassembleAtomic(instruction)
+	}
+
+	return "", false
+}
+
+func disassembleIType(instruction uint32) (string, bool) {
+	_, rd, rs1, imm, func3 := DecodeITypeInstruction(instruction)
+	switch func3 {
+	case 0b001:
+		if imm>>5 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("slli x%d, x%d, %d", rd, rs1, imm&0x1F), true
+	case 0b101:
+		name := "srli"
+		switch imm >> 5 {
+		case 0b0000000:
+		case 0b0100000:
+			name = "srai"
+		default:
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, x%d, %d", name, rd, rs1, imm&0x1F), true
+	case 0b011:
+		// the assembler takes the immediate of sltiu unsigned
+		return fmt.Sprintf("sltiu x%d, x%d, %d", rd, rs1, imm), true
+	}
+
+	name := [8]string{"addi", "", "slti", "", "xori", "", "ori", "andi"}[func3]
+	return fmt.Sprintf("%s x%d, x%d, %d", name, rd, rs1, getImmediateValue(instruction)), true
+}
+
+func disassembleRType(instruction uint32) (string, bool) {
+	_, rd, rs1, rs2, func7, func3 := DecodeRTypeInstruction(instruction)
+	name := ""
+	switch func7 {
+	case 0b0000000:
+		name = [8]string{"add", "sll", "slt", "sltu", "xor", "srl", "or", "and"}[func3]
+	case 0b0100000:
+		name = [8]string{"sub", "", "", "", "", "sra", "", ""}[func3]
+	case 0b0000001:
+		name = [8]string{"mul", "mulh", "mulhsu", "mulhu", "div", "divu", "rem", "remu"}[func3]
+	}
+	if name == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s x%d, x%d, x%d", name, rd, rs1, rs2), true
+}
+
+func disassembleEnv(instruction uint32) (string, bool) {
+	_, rd, rs1, imm, func3 := DecodeITypeInstruction(instruction)
+	if func3 == 0b000 {
+		if rd != 0 || rs1 != 0 {
+			return "", false
+		}
+		switch imm {
+		case 0b000000000000:
+			return "ecall", true
+		case 0b000000000001:
+			return "ebreak", true
+		case 0b001100000010:
+			return "mret", true
+		}
+		return "", false
+	}
+
+	name := [8]string{"", "csrrw", "csrrs", "csrrc", "", "csrrwi", "csrrsi", "csrrci"}[func3]
+	if name == "" {
+		return "", false
+	}
+	if func3&0b100 != 0 {
+		// the immediate versions encode a 5-bit unsigned immediate in the rs1 field
+		return fmt.Sprintf("%s x%d, 0x%03X, %d", name, rd, imm, rs1), true
+	}
+	return fmt.Sprintf("%s x%d, 0x%03X, x%d", name, rd, imm, rs1), true
+}
+
+func disassembleFloat(instruction uint32) (string, bool) {
+	_, rd, rs1, rs2, func7, func3 := DecodeRTypeInstruction(instruction)
+	switch func7 {
+	case 0b0000000, 0b0000100, 0b0001000, 0b0001100:
+		name := [4]string{"fadd.s", "fsub.s", "fmul.s", "fdiv.s"}[func7>>2]
+		return withRoundingMode(fmt.Sprintf("%s f%d, f%d, f%d", name, rd, rs1, rs2), func3)
+	case 0b0101100:
+		if rs2 != 0 {
+			return "", false
+		}
+		return withRoundingMode(fmt.Sprintf("fsqrt.s f%d, f%d", rd, rs1), func3)
+	case 0b0010000, 0b0010100:
+		names := [8]string{"fsgnj.s", "fsgnjn.s", "fsgnjx.s", "", "", "", "", ""}
+		if func7 == 0b0010100 {
+			names = [8]string{"fmin.s", "fmax.s", "", "", "", "", "", ""}
+		}
+		if names[func3] == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s f%d, f%d, f%d", names[func3], rd, rs1, rs2), true
+	case 0b1100000, 0b1101000:
+		if rs2 > 1 {
+			return "", false
+		}
+		if func7 == 0b1100000 {
+			return withRoundingMode(fmt.Sprintf("%s x%d, f%d", [2]string{"fcvt.w.s", "fcvt.wu.s"}[rs2], rd, rs1), func3)
+		}
+		return withRoundingMode(fmt.Sprintf("%s f%d, x%d", [2]string{"fcvt.s.w", "fcvt.s.wu"}[rs2], rd, rs1), func3)
+	case 0b1110000:
+		if rs2 != 0 || func3 > 1 {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, f%d", [2]string{"fmv.x.w", "fclass.s"}[func3], rd, rs1), true
+	case 0b1111000:
+		if rs2 != 0 || func3 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("fmv.w.x f%d, x%d", rd, rs1), true
+	case 0b1010000:
+		name := [8]string{"fle.s", "flt.s", "feq.s", "", "", "", "", ""}[func3]
+		if name == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, f%d, f%d", name, rd, rs1, rs2), true
+	}
+
+	return "", false
+}
+
+// Appends the rounding mode unless it is the default dynamic rounding mode
+func withRoundingMode(text string, rm uint32) (string, bool) {
+	name := floatRoundingModeNames[rm]
+	if name == "" {
+		return "", false
+	} else if name == "dyn" {
+		return text, true
+	}
+	return text + ", " + name, true
+}
+
+func disassembleAtomic(instruction uint32) (string, bool) {
+	_, rd, rs1, rs2, _, func3 := DecodeRTypeInstruction(instruction)
+	if func3 != 0b010 {
+		return "", false
+	}
+
+	funct5 := instruction >> 27
+	name := ""
+	for n, f := range AtomicFunct5Map {
+		if f == funct5 {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		return "", false
+	}
+
+	switch (instruction >> 25) & 0b11 {
+	case 0b10:
+		name += ".aq"
+	case 0b01:
+		name += ".rl"
+	case 0b11:
+		name += ".aqrl"
+	}
+
+	if funct5 == AtomicFunct5Map["lr.w"] {
+		if rs2 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("%s x%d, (x%d)", name, rd, rs1), true
+	}
+	return fmt.Sprintf("%s x%d, x%d, (x%d)", name, rd, rs2, rs1), true
+}
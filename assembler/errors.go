@@ -95,6 +95,16 @@ func (assemblyError) InvalidRegister(register string, r TextRange) Diagnostic {
 	}
 }
 
+func (assemblyError) InvalidFloatRegister(register string, r TextRange) Diagnostic {
+	r, register = AdjustRange(r, register)
+	return Diagnostic{
+		Range:    r,
+		Message:  "Expected floating point register, got: \"" + register + "\"",
+		Source:   "Assembler",
+		Severity: Error,
+	}
+}
+
 func (assemblyError) ImmediateOverflow(value string, maxSize int, r TextRange) Diagnostic {
 	r, value = AdjustRange(r, value)
 	return Diagnostic{
@@ -163,6 +173,26 @@ func (assemblyError) LabelTooFar(label string, r TextRange) Diagnostic {
 	}
 }
 
+func (assemblyError) InvalidCSR(csr string, r TextRange) Diagnostic {
+	r, csr = AdjustRange(r, csr)
+	return Diagnostic{
+		Range:    r,
+		Message:  "Expected CSR name or number, got: \"" + csr + "\"",
+		Source:   "Assembler",
+		Severity: Error,
+	}
+}
+
+func (assemblyError) InvalidRoundingMode(mode string, r TextRange) Diagnostic {
+	r, mode = AdjustRange(r, mode)
+	return Diagnostic{
+		Range:    r,
+		Message:  "Expected rounding mode (rne, rtz, rdn, rup, rmm, or dyn), got: \"" + mode + "\"",
+		Source:   "Assembler",
+		Severity: Error,
+	}
+}
+
 // Warnings
 type assemblyWarning struct{}
 
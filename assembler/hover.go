@@ -131,6 +131,8 @@ func (a *AssembledResult) EvaluateHover(position TextPosition) (string, bool) {
 					return fmt.Sprintf(hoverInfoFormats.integerLiteral, evRes.Value, "0x"+strconv.FormatInt(evRes.Value, 16)), true
 				} else if evRes.Type == EvaluationTypeRegister {
 					return getHoverInfoForRegister(int(evRes.Value), evRes.MatchedValue), true
+				} else if evRes.Type == EvaluationTypeFloatRegister {
+					return getHoverInfoForFloatRegister(int(evRes.Value), evRes.MatchedValue), true
 				}
 			}
 			pos += len(v) + 1
@@ -141,7 +143,7 @@ func (a *AssembledResult) EvaluateHover(position TextPosition) (string, bool) {
 }
 
 func getHoverInfoForInstruction(opcode string) string {
-	opcode = strings.TrimSpace(strings.ToLower(opcode))
+	opcode, _ = trimAtomicOrdering(strings.TrimSpace(strings.ToLower(opcode)))
 	switch opcode {
 	case "add":
 		return hoverInfoFormats.add
@@ -221,6 +223,8 @@ func getHoverInfoForInstruction(opcode string) string {
 		return hoverInfoFormats.ecall
 	case "ebreak":
 		return hoverInfoFormats.ebreak
+	case "mret":
+		return hoverInfoFormats.mret
 	case "mul":
 		return hoverInfoFormats.mul
 	case "mulh":
@@ -239,6 +243,90 @@ func getHoverInfoForInstruction(opcode string) string {
 		return hoverInfoFormats.rem
 	case "remu":
 		return hoverInfoFormats.remu
+	case "csrrw":
+		return hoverInfoFormats.csrrw
+	case "csrrs":
+		return hoverInfoFormats.csrrs
+	case "csrrc":
+		return hoverInfoFormats.csrrc
+	case "csrrwi":
+		return hoverInfoFormats.csrrwi
+	case "csrrsi":
+		return hoverInfoFormats.csrrsi
+	case "csrrci":
+		return hoverInfoFormats.csrrci
+	case "csrr":
+		return hoverInfoFormats.csrr
+	case "csrw":
+		return hoverInfoFormats.csrw
+	case "flw":
+		return hoverInfoFormats.flw
+	case "fsw":
+		return hoverInfoFormats.fsw
+	case "fadd.s":
+		return hoverInfoFormats.fadds
+	case "fsub.s":
+		return hoverInfoFormats.fsubs
+	case "fmul.s":
+		return hoverInfoFormats.fmuls
+	case "fdiv.s":
+		return hoverInfoFormats.fdivs
+	case "fsqrt.s":
+		return hoverInfoFormats.fsqrts
+	case "fmin.s":
+		return hoverInfoFormats.fmins
+	case "fmax.s":
+		return hoverInfoFormats.fmaxs
+	case "fmadd.s", "fmsub.s", "fnmsub.s", "fnmadd.s":
+		return hoverInfoFormats.fmadds
+	case "fmv.s":
+		return hoverInfoFormats.fmvs
+	case "fneg.s":
+		return hoverInfoFormats.fnegs
+	case "fabs.s":
+		return hoverInfoFormats.fabss
+	case "fcvt.w.s":
+		return hoverInfoFormats.fcvtws
+	case "fcvt.wu.s":
+		return hoverInfoFormats.fcvtwus
+	case "fcvt.s.w":
+		return hoverInfoFormats.fcvtsw
+	case "fcvt.s.wu":
+		return hoverInfoFormats.fcvtswu
+	case "fmv.x.w", "fmv.x.s":
+		return hoverInfoFormats.fmvxw
+	case "fmv.w.x", "fmv.s.x":
+		return hoverInfoFormats.fmvwx
+	case "feq.s":
+		return hoverInfoFormats.feqs
+	case "flt.s":
+		return hoverInfoFormats.flts
+	case "fle.s":
+		return hoverInfoFormats.fles
+	case "fclass.s":
+		return hoverInfoFormats.fclasss
+	case "lr.w":
+		return hoverInfoFormats.lrw
+	case "sc.w":
+		return hoverInfoFormats.scw
+	case "amoswap.w":
+		return hoverInfoFormats.amoswapw
+	case "amoadd.w":
+		return hoverInfoFormats.amoaddw
+	case "amoxor.w":
+		return hoverInfoFormats.amoxorw
+	case "amoand.w":
+		return hoverInfoFormats.amoandw
+	case "amoor.w":
+		return hoverInfoFormats.amoorw
+	case "amomin.w":
+		return hoverInfoFormats.amominw
+	case "amomax.w":
+		return hoverInfoFormats.amomaxw
+	case "amominu.w":
+		return hoverInfoFormats.amominuw
+	case "amomaxu.w":
+		return hoverInfoFormats.amomaxuw
 	}
 	return ""
 }
@@ -262,3 +350,11 @@ func getHoverInfoForRegister(register int, name string) string {
 		return fmt.Sprintf(hoverInfoFormats.genericRegister, register)
 	}
 }
+
+func getHoverInfoForFloatRegister(register int, name string) string {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name[1] >= '0' && name[1] <= '9' {
+		return fmt.Sprintf(hoverInfoFormats.floatRegister, register)
+	}
+	return fmt.Sprintf(hoverInfoFormats.namedFloatRegister, name, register)
+}
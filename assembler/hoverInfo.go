@@ -13,6 +13,8 @@ type hoverInfoFormatsType struct {
 	tpRegister           string
 	namedGenericRegister string
 	genericRegister      string
+	namedFloatRegister   string
+	floatRegister        string
 
 	// instructions
 	add  string
@@ -61,6 +63,7 @@ type hoverInfoFormatsType struct {
 
 	ecall  string
 	ebreak string
+	mret   string
 
 	mul    string
 	mulh   string
@@ -70,6 +73,51 @@ type hoverInfoFormatsType struct {
 	divu   string
 	rem    string
 	remu   string
+
+	csrrw  string
+	csrrs  string
+	csrrc  string
+	csrrwi string
+	csrrsi string
+	csrrci string
+	csrr   string
+	csrw   string
+
+	flw     string
+	fsw     string
+	fadds   string
+	fsubs   string
+	fmuls   string
+	fdivs   string
+	fsqrts  string
+	fmins   string
+	fmaxs   string
+	fmadds  string
+	fmvs    string
+	fnegs   string
+	fabss   string
+	fcvtws  string
+	fcvtwus string
+	fcvtsw  string
+	fcvtswu string
+	fmvxw   string
+	fmvwx   string
+	feqs    string
+	flts    string
+	fles    string
+	fclasss string
+
+	lrw      string
+	scw      string
+	amoswapw string
+	amoaddw  string
+	amoxorw  string
+	amoandw  string
+	amoorw   string
+	amominw  string
+	amomaxw  string
+	amominuw string
+	amomaxuw string
 }
 
 var hoverInfoFormats = hoverInfoFormatsType{
@@ -84,6 +132,8 @@ var hoverInfoFormats = hoverInfoFormatsType{
 	tpRegister:           "Thread Pointer Register `tp` (`x4`)\n\nContains the address of the thread-local storage segment",
 	genericRegister:      "Register `x%d`. 32-Bit General Purpose Register",
 	namedGenericRegister: "Register `%s` (`x%d`). 32-Bit General Purpose Register",
+	floatRegister:        "Register `f%d`. 32-Bit Floating Point Register",
+	namedFloatRegister:   "Register `%s` (`f%d`). 32-Bit Floating Point Register",
 
 	add:  "Addition Instruction.\n\nFormat: `add <dst reg>, <src reg>, <src reg>`\n\nExample: `add x10, x11, x12` is the same as `x10 = x11 + x12`",
 	sub:  "Subtraction Instruction.\n\nFormat: `sub <dst reg>, <src reg>, <src reg>`\n\nExample: `sub x10, x11, x12` is the same as `x10 = x11 - x12`",
@@ -130,6 +180,7 @@ var hoverInfoFormats = hoverInfoFormatsType{
 	auipc: "Add Upper Immediate to PC Instruction.\n\nFormat: `auipc <dst reg>, <imm>`\n\nExample: `auipc x10, 0x12345` is the same as `x10 = pc + 0x12345000`\n\nThe immediate is a 20-bit value.",
 
 	ecall:  "Environment Call Instruction.\n\nFormat: `ecall`\n\nExample: `ecall` is the same as `syscall(17, x10, x11, x12, x13, x14, x15)`\n\nThe syscall number is always 17, and the arguments are in x10-x15.",
+	mret:   "Machine-Mode Trap Return Instruction.\n\nFormat: `mret`\n\nExample: `mret` is the same as `pc = mepc`\n\nReturns from a trap handler and restores the interrupt enable bit in `mstatus`. To return past an `ecall`, add 4 to `mepc` first.",
 	ebreak: "Environment Break Instruction.\n\nFormat: `ebreak`\n\nExample: `ebreak` will trigger a breakpoint exception. This is not recommended because the development environment allows for hardware breakpoints to be set.",
 
 	// RV32M
@@ -142,4 +193,49 @@ var hoverInfoFormats = hoverInfoFormatsType{
 	divu: "Divide Unsigned Instruction.\n\nFormat: `divu <dst reg>, <src reg 1>, <src reg 2>`\n\nExample: `divu x10, x11, x12` is the same as `x10 = x11 / x12`.\n\nBoth arguments are treated as unsigned.",
 	rem:  "Remainder Instruction.\n\nFormat: `rem <dst reg>, <src reg 1>, <src reg 2>`\n\nExample: `rem x10, x11, x12` is the same as `x10 = int32_t(x11) % int32_t(x12)`",
 	remu: "Remainder Unsigned Instruction.\n\nFormat: `remu <dst reg>, <src reg 1>, <src reg 2>`\n\nExample: `remu x10, x11, x12` is the same as `x10 = x11 % x12`.\n\nBoth arguments are treated as unsigned.",
+
+	csrrw:  "CSR Read and Write Instruction.\n\nFormat: `csrrw <dst reg>, <csr>, <src reg>`\n\nExample: `csrrw x10, mscratch, x11` is the same as `x10 = mscratch; mscratch = x11`",
+	csrrs:  "CSR Read and Set Bits Instruction.\n\nFormat: `csrrs <dst reg>, <csr>, <src reg>`\n\nExample: `csrrs x10, mstatus, x11` is the same as `x10 = mstatus; mstatus |= x11`\n\nThe CSR is not written when the source register is `x0`.",
+	csrrc:  "CSR Read and Clear Bits Instruction.\n\nFormat: `csrrc <dst reg>, <csr>, <src reg>`\n\nExample: `csrrc x10, mstatus, x11` is the same as `x10 = mstatus; mstatus &= ~x11`\n\nThe CSR is not written when the source register is `x0`.",
+	csrrwi: "CSR Read and Write Immediate Instruction.\n\nFormat: `csrrwi <dst reg>, <csr>, <uimm>`\n\nExample: `csrrwi x10, mscratch, 5` is the same as `x10 = mscratch; mscratch = 5`\n\nThe immediate must be between 0 and 31.",
+	csrrsi: "CSR Read and Set Bits Immediate Instruction.\n\nFormat: `csrrsi <dst reg>, <csr>, <uimm>`\n\nExample: `csrrsi x10, mstatus, 8` is the same as `x10 = mstatus; mstatus |= 8`\n\nThe immediate must be between 0 and 31.",
+	csrrci: "CSR Read and Clear Bits Immediate Instruction.\n\nFormat: `csrrci <dst reg>, <csr>, <uimm>`\n\nExample: `csrrci x10, mstatus, 8` is the same as `x10 = mstatus; mstatus &= ~8`\n\nThe immediate must be between 0 and 31.",
+	csrr:   "CSR Read Pseudo-Instruction.\n\nFormat: `csrr <dst reg>, <csr>`\n\nExample: `csrr x10, cycle` is the same as `x10 = cycle`\n\nThis is the same as `csrrs <dst reg>, <csr>, x0`.",
+	csrw:   "CSR Write Pseudo-Instruction.\n\nFormat: `csrw <csr>, <src reg>`\n\nExample: `csrw mscratch, x10` is the same as `mscratch = x10`\n\nThis is the same as `csrrw x0, <csr>, <src reg>`.",
+
+	flw:     "Load Float Word Instruction.\n\nFormat: `flw <dst freg>, <imm>(<src reg>)`\n\nExample: `flw f10, 8(x11)` is the same as `f10 = *(float*)(x11 + 8)`\n\nThe immediate is a signed 12-bit value, so it must be between -2048 and 2047.",
+	fsw:     "Store Float Word Instruction.\n\nFormat: `fsw <src freg>, <imm>(<base reg>)`\n\nExample: `fsw f10, 8(x11)` is the same as `*(float*)(x11 + 8) = f10`\n\nThe immediate is a signed 12-bit value, so it must be between -2048 and 2047.",
+	fadds:   "Float Addition Instruction.\n\nFormat: `fadd.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fadd.s f10, f11, f12` is the same as `f10 = f11 + f12`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fsubs:   "Float Subtraction Instruction.\n\nFormat: `fsub.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fsub.s f10, f11, f12` is the same as `f10 = f11 - f12`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fmuls:   "Float Multiplication Instruction.\n\nFormat: `fmul.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fmul.s f10, f11, f12` is the same as `f10 = f11 * f12`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fdivs:   "Float Division Instruction.\n\nFormat: `fdiv.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fdiv.s f10, f11, f12` is the same as `f10 = f11 / f12`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fsqrts:  "Float Square Root Instruction.\n\nFormat: `fsqrt.s <dst freg>, <src freg>`\n\nExample: `fsqrt.s f10, f11` is the same as `f10 = sqrtf(f11)`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fmins:   "Float Minimum Instruction.\n\nFormat: `fmin.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fmin.s f10, f11, f12` is the same as `f10 = fminf(f11, f12)`\n\nIf only one source is NaN, the other source is the result.",
+	fmaxs:   "Float Maximum Instruction.\n\nFormat: `fmax.s <dst freg>, <src freg 1>, <src freg 2>`\n\nExample: `fmax.s f10, f11, f12` is the same as `f10 = fmaxf(f11, f12)`\n\nIf only one source is NaN, the other source is the result.",
+	fmadds:  "Float Fused Multiply-Add Instruction.\n\nFormat: `fmadd.s <dst freg>, <src freg 1>, <src freg 2>, <src freg 3>`\n\nExample: `fmadd.s f10, f11, f12, f13` is the same as `f10 = f11 * f12 + f13` with a single rounding step.\n\n`fmsub.s`, `fnmsub.s`, and `fnmadd.s` calculate `f11 * f12 - f13`, `-(f11 * f12) + f13`, and `-(f11 * f12) - f13`.\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fmvs:    "Float Move Pseudo-Instruction.\n\nFormat: `fmv.s <dst freg>, <src freg>`\n\nExample: `fmv.s f10, f11` is the same as `f10 = f11`\n\nThis is the same as `fsgnj.s <dst freg>, <src freg>, <src freg>`.",
+	fnegs:   "Float Negate Pseudo-Instruction.\n\nFormat: `fneg.s <dst freg>, <src freg>`\n\nExample: `fneg.s f10, f11` is the same as `f10 = -f11`\n\nThis is the same as `fsgnjn.s <dst freg>, <src freg>, <src freg>`.",
+	fabss:   "Float Absolute Value Pseudo-Instruction.\n\nFormat: `fabs.s <dst freg>, <src freg>`\n\nExample: `fabs.s f10, f11` is the same as `f10 = fabsf(f11)`\n\nThis is the same as `fsgnjx.s <dst freg>, <src freg>, <src freg>`.",
+	fcvtws:  "Convert Float to Integer Instruction.\n\nFormat: `fcvt.w.s <dst reg>, <src freg>`\n\nExample: `fcvt.w.s x10, f11, rtz` is the same as `x10 = (int32_t)f11`\n\nOut of range values are clamped, and NaN converts to the largest integer.\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fcvtwus: "Convert Float to Unsigned Integer Instruction.\n\nFormat: `fcvt.wu.s <dst reg>, <src freg>`\n\nExample: `fcvt.wu.s x10, f11, rtz` is the same as `x10 = (uint32_t)f11`\n\nOut of range values are clamped, and NaN converts to the largest integer.\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fcvtsw:  "Convert Integer to Float Instruction.\n\nFormat: `fcvt.s.w <dst freg>, <src reg>`\n\nExample: `fcvt.s.w f10, x11` is the same as `f10 = (float)(int32_t)x11`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fcvtswu: "Convert Unsigned Integer to Float Instruction.\n\nFormat: `fcvt.s.wu <dst freg>, <src reg>`\n\nExample: `fcvt.s.wu f10, x11` is the same as `f10 = (float)(uint32_t)x11`\n\nAn optional rounding mode (`rne`, `rtz`, `rdn`, `rup`, `rmm`, or `dyn`) may be given as the last operand, the default is `dyn` which uses `frm`.",
+	fmvxw:   "Move Float Bits to Integer Register Instruction.\n\nFormat: `fmv.x.w <dst reg>, <src freg>`\n\nExample: `fmv.x.w x10, f11` copies the bits of `f11` into `x10` without converting them.",
+	fmvwx:   "Move Integer Bits to Float Register Instruction.\n\nFormat: `fmv.w.x <dst freg>, <src reg>`\n\nExample: `fmv.w.x f10, x11` copies the bits of `x11` into `f10` without converting them.",
+	feqs:    "Float Equal Instruction.\n\nFormat: `feq.s <dst reg>, <src freg 1>, <src freg 2>`\n\nExample: `feq.s x10, f11, f12` is the same as `x10 = f11 == f12`",
+	flts:    "Float Less Than Instruction.\n\nFormat: `flt.s <dst reg>, <src freg 1>, <src freg 2>`\n\nExample: `flt.s x10, f11, f12` is the same as `x10 = f11 < f12`",
+	fles:    "Float Less Than or Equal Instruction.\n\nFormat: `fle.s <dst reg>, <src freg 1>, <src freg 2>`\n\nExample: `fle.s x10, f11, f12` is the same as `x10 = f11 <= f12`",
+	fclasss: "Float Classify Instruction.\n\nFormat: `fclass.s <dst reg>, <src freg>`\n\nExample: `fclass.s x10, f11` sets exactly one bit of `x10`: 0 -inf, 1 negative normal, 2 negative subnormal, 3 -0, 4 +0, 5 positive subnormal, 6 positive normal, 7 +inf, 8 signaling NaN, 9 quiet NaN.",
+
+	lrw:      "Load Reserved Instruction.\n\nFormat: `lr.w <dst reg>, (<addr reg>)`\n\nExample: `lr.w x10, (x12)` is the same as `x10 = M[x12]` and reserves the address\n\nThe reservation is lost when another hart stores to the address. An ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	scw:      "Store Conditional Instruction.\n\nFormat: `sc.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `sc.w x10, x11, (x12)` is the same as `M[x12] = x11; x10 = 0` if the address is still reserved by `lr.w`, otherwise `x10 = 1` and memory is not written\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amoswapw: "Atomic Swap Instruction.\n\nFormat: `amoswap.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amoswap.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = x11` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amoaddw:  "Atomic Add Instruction.\n\nFormat: `amoadd.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amoadd.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = M[x12] + x11` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amoxorw:  "Atomic Xor Instruction.\n\nFormat: `amoxor.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amoxor.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = M[x12] ^ x11` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amoandw:  "Atomic And Instruction.\n\nFormat: `amoand.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amoand.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = M[x12] & x11` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amoorw:   "Atomic Or Instruction.\n\nFormat: `amoor.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amoor.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = M[x12] | x11` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amominw:  "Atomic Minimum Instruction.\n\nFormat: `amomin.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amomin.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = min(M[x12], x11)` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amomaxw:  "Atomic Maximum Instruction.\n\nFormat: `amomax.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amomax.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = max(M[x12], x11)` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amominuw: "Atomic Unsigned Minimum Instruction.\n\nFormat: `amominu.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amominu.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = minu(M[x12], x11)` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
+	amomaxuw: "Atomic Unsigned Maximum Instruction.\n\nFormat: `amomaxu.w <dst reg>, <src reg>, (<addr reg>)`\n\nExample: `amomaxu.w x10, x11, (x12)` is the same as `x10 = M[x12]; M[x12] = maxu(M[x12], x11)` done atomically\n\nAn ordering suffix (`.aq`, `.rl`, or `.aqrl`) may be added to the opcode.",
 }
@@ -32,6 +32,7 @@ const (
 	EvaluationTypeUnsignedIntegerLiteral
 	EvaluationTypeRegister
 	EvaluationTypeLabel
+	EvaluationTypeFloatRegister
 )
 
 type EvaluationResult struct {
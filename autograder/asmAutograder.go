@@ -0,0 +1,248 @@
+package autograder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/emulator"
+)
+
+func AutogradeAssembly(assignmentCodeDir, studentCodePath string, testCases []TestCase) {
+	gso := CreateGradescopeOutput()
+	assemblyTestCase := CreateTestCase("Assembly", GetConfig().CompilationPoints, "visible")
+
+	// looking for .asm file in submission dir
+	if info, e := os.Stat(studentCodePath); e == nil && info.IsDir() {
+		dirFiles, e := os.ReadDir(studentCodePath)
+		if e != nil {
+			assemblyTestCase.OutputPrintLn("Failed to list submission directory: " + e.Error())
+			assemblyTestCase.SetStatus(false)
+			gso.AddTest(assemblyTestCase, 0)
+			gso.Save()
+			return
+		}
+
+		for _, f := range dirFiles {
+			if filepath.Ext(f.Name()) == ".asm" {
+				studentCodePath = filepath.Join(studentCodePath, f.Name())
+				break
+			}
+		}
+	}
+
+	b, e := os.ReadFile(studentCodePath)
+	if e != nil {
+		assemblyTestCase.OutputPrintLn("Could not find or read an assembly (.asm) file in your submission: " + e.Error())
+		assemblyTestCase.SetStatus(false)
+		gso.AddTest(assemblyTestCase, 0)
+		gso.Save()
+		return
+	}
+
+	// assembling the file first so the diagnostics can be shown to the student
+	assembleRes := assembler.Assemble(string(b))
+	numErrors := 0
+	numWarnings := 0
+	for _, diag := range assembleRes.Diagnostics {
+		severity := "info"
+		switch diag.Severity {
+		case assembler.Error:
+			severity = "error"
+			numErrors++
+		case assembler.Warning:
+			severity = "warning"
+			numWarnings++
+		}
+		assemblyTestCase.OutputPrintLn(fmt.Sprintf("%s:%d:%d: %s: %s", filepath.Base(studentCodePath), diag.Range.Start.Line+1, diag.Range.Start.Char, severity, diag.Message))
+	}
+
+	if numErrors > 0 {
+		assemblyTestCase.OutputPrintLn("Failed to assemble code. Please see above for more info.")
+		assemblyTestCase.SetStatus(false)
+		gso.AddTest(assemblyTestCase, 0)
+		gso.Save()
+		return
+	} else if numWarnings > 0 {
+		assemblyTestCase.OutputPrintLn("Assembled with warnings. Please see above for more info.")
+		assemblyTestCase.SetStatus(false)
+		gso.AddTest(assemblyTestCase, GetConfig().CompilationPoints/2)
+	} else {
+		assemblyTestCase.OutputPrintLn("Successfully assembled code with no warnings.")
+		gso.AddTest(assemblyTestCase, GetConfig().CompilationPoints)
+	}
+
+	// each test case number is used as the seed for the assignment
+	seeds := make([]uint32, len(testCases))
+	for i, testCase := range testCases {
+		seeds[i] = uint32(testCase.Number)
+	}
+
+	options := emulator.BatchRunOptions{}
+	if GetConfig().FileSystem != "" {
+		fs, e := emulator.MountFileSystem(filepath.Join(assignmentCodeDir, GetConfig().FileSystem))
+		if e != nil {
+			errorsCase := CreateTestCase("Errors", 0, "visible")
+			errorsCase.OutputPrintLn("Error mounting assignment filesystem: " + e.Error())
+			errorsCase.SetStatus(false)
+			gso.AddTest(errorsCase, 0)
+			gso.Save()
+			return
+		}
+		options.FileSystem = fs
+	}
+	if GetConfig().GoldenImagesDir != "" {
+		options.Screenshots = goldenScreenshots(filepath.Join(assignmentCodeDir, GetConfig().GoldenImagesDir))
+	}
+
+	elfFilePath := filepath.Join(assignmentCodeDir, GetConfig().AssignmentBinary)
+	results, e := emulator.BatchRunWithOptions(elfFilePath, studentCodePath, seeds, false, options)
+	if e != nil {
+		errorsCase := CreateTestCase("Errors", 0, "visible")
+		errorsCase.OutputPrintLn("Error running assignment: " + e.Error())
+		errorsCase.SetStatus(false)
+		gso.AddTest(errorsCase, 0)
+		gso.Save()
+		return
+	}
+
+	// a run only passes if its output files and frames match the golden ones too
+	outputMismatches := make(map[uint32][]string)
+	if GetConfig().GoldenFilesDir != "" {
+		for i, result := range results {
+			goldenDir := filepath.Join(assignmentCodeDir, GetConfig().GoldenFilesDir, strconv.FormatUint(uint64(result.Seed), 10))
+			mismatches := compareOutputFiles(goldenDir, result.Files)
+			if len(mismatches) > 0 {
+				results[i].Passed = false
+				outputMismatches[result.Seed] = mismatches
+			}
+		}
+	}
+	if GetConfig().GoldenImagesDir != "" {
+		for i, result := range results {
+			goldenDir := filepath.Join(assignmentCodeDir, GetConfig().GoldenImagesDir, strconv.FormatUint(uint64(result.Seed), 10))
+			mismatches := compareFrames(goldenDir, result, GetConfig().ImageTolerance)
+			if len(mismatches) > 0 {
+				results[i].Passed = false
+				outputMismatches[result.Seed] = append(outputMismatches[result.Seed], mismatches...)
+			}
+		}
+	}
+
+	// results are not returned in the order the seeds were queued
+	resultsBySeed := make(map[uint32]emulator.EvaluationRunResult)
+	for _, result := range results {
+		resultsBySeed[result.Seed] = result
+	}
+
+	type TCTypePair struct {
+		correct      int
+		total        int
+		earnedPoints int
+		totalPoints  int
+		output       string
+	}
+
+	tcRes := make(map[string]TCTypePair) // key is the visbility of the test case
+
+	errorsCase := CreateTestCase("Errors", 0, "visible")
+	errorsCase.SetStatus(true) // will be set to false if there are any errors
+	for _, testCase := range testCases {
+		result := resultsBySeed[uint32(testCase.Number)]
+
+		correct := 0
+		earnedPoints := 0
+		if result.Passed {
+			correct = 1
+			earnedPoints = testCase.Points
+		}
+
+		passFail := "[FAIL] "
+		if result.Passed {
+			passFail = "[PASS] "
+		}
+
+		outputStr := passFail + "Test Case: " + testCase.Name + " (seed " + strconv.Itoa(testCase.Number) + ")\n"
+		outputStr += fmt.Sprintf("\tDI = %d, SI = %d, Register Usage = %d, Memory Usage = %d, Runtime Errors = %d\n", result.DI, result.SI, result.Regs, result.Mem, result.NumErrors)
+		for _, mismatch := range outputMismatches[uint32(testCase.Number)] {
+			outputStr += "\t" + mismatch + "\n"
+		}
+
+		if result.NumErrors > 0 {
+			errorsCase.OutputPrintLn(fmt.Sprintf("Test Case: %s (seed %d) had %d runtime error(s).", testCase.Name, testCase.Number, result.NumErrors))
+			errorsCase.SetStatus(false)
+		}
+
+		if _, ok := tcRes[testCase.Visibility]; !ok {
+			tcRes[testCase.Visibility] = TCTypePair{
+				correct:      correct,
+				total:        1,
+				earnedPoints: earnedPoints,
+				totalPoints:  testCase.Points,
+				output:       outputStr,
+			}
+		} else {
+			tcRes[testCase.Visibility] = TCTypePair{
+				correct:      tcRes[testCase.Visibility].correct + correct,
+				total:        tcRes[testCase.Visibility].total + 1,
+				earnedPoints: tcRes[testCase.Visibility].earnedPoints + earnedPoints,
+				totalPoints:  tcRes[testCase.Visibility].totalPoints + testCase.Points,
+				output:       tcRes[testCase.Visibility].output + outputStr,
+			}
+		}
+	}
+	gso.AddTest(errorsCase, 0)
+
+	// collating the results
+	for visibility, res := range tcRes {
+		// creating the test case
+		tcTypeStr := "Smoke Test Cases"
+		if visibility != "visible" {
+			tcTypeStr = "All Other Test Cases"
+		}
+		tc := CreateTestCase(tcTypeStr, res.totalPoints, visibility)
+		tc.OutputPrintLn("Number Passed: " + strconv.Itoa(res.correct) + "/" + strconv.Itoa(res.total))
+		tc.OutputPrintLn(res.output)
+		gso.AddTest(tc, res.earnedPoints)
+	}
+
+	gradePerformance(gso, GetConfig().PerformanceMetrics, results)
+
+	gso.Save()
+}
+
+// Compares the files a run wrote against the golden files in the directory, returning a description of
+// every difference. A missing golden directory means the seed has no expected output files.
+func compareOutputFiles(goldenDir string, files map[string][]byte) []string {
+	mismatches := []string{}
+	filepath.WalkDir(goldenDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		relPath, e := filepath.Rel(goldenDir, path)
+		if e != nil {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+
+		expected, e := os.ReadFile(path)
+		if e != nil {
+			mismatches = append(mismatches, "Could not read expected output file "+name+": "+e.Error())
+			return nil
+		}
+
+		actual, ok := files[name]
+		if !ok {
+			mismatches = append(mismatches, "Output file "+name+" was not written")
+		} else if !bytes.Equal(actual, expected) {
+			mismatches = append(mismatches, "Output file "+name+" does not match the expected output")
+		}
+		return nil
+	})
+
+	return mismatches
+}
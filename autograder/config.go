@@ -13,14 +13,43 @@ type TestCase struct {
 	Points     int    `json:"points"`
 }
 
+// A point on a performance curve. Metric values between two points are linearly interpolated.
+type PerformanceCurvePoint struct {
+	Value  float64 `json:"value"`  // value of the metric
+	Credit float64 `json:"credit"` // fraction of the points awarded at this value, from 0 to 1
+}
+
+type PerformanceMetric struct {
+	Name        string                  `json:"name"`
+	Metric      string                  `json:"metric"`    // one of 'di', 'si', 'regs', or 'mem'
+	Aggregate   string                  `json:"aggregate"` // either 'average' or 'worst' across all seeds
+	Points      int                     `json:"points"`
+	Visibility  string                  `json:"visibility"`
+	Curve       []PerformanceCurvePoint `json:"curve"`
+	Leaderboard bool                    `json:"leaderboard"` // whether to publish the metric to the leaderboard
+}
+
+// How much a frame may differ from the expected one, the zero value requires an exact match
+type ImageTolerance struct {
+	Channel int     `json:"channel"` // largest difference of a red, green, blue, or alpha value for a pixel to match
+	Pixels  float64 `json:"pixels"`  // fraction of the pixels allowed to not match, from 0 to 1
+}
+
 type Config struct {
 	AssignmentName    string     `json:"assignmentName"`
 	AssignmentCodeDir string     `json:"assignmentCodeDir"`
+	AssignmentBinary  string     `json:"assignmentBinary"` // ELF file relative to assignmentCodeDir, only used in 'asm' mode
 	StudentCodePath   string     `json:"studentCodePath"`
 	TestCases         []TestCase `json:"testCases"`
 	CompilationPoints int        `json:"compilationPoints"`
 	MemleakPoints     int        `json:"memleakPoints"`
 	Mode              string     `json:"mode"` // either 'c' or 'asm'
+
+	PerformanceMetrics []PerformanceMetric `json:"performanceMetrics"` // only used in 'asm' mode
+	FileSystem         string              `json:"fileSystem"`         // directory or FAT image relative to assignmentCodeDir mounted in every run, only used in 'asm' mode
+	GoldenFilesDir     string              `json:"goldenFilesDir"`     // expected output files relative to assignmentCodeDir as <seed>/<path>, only used in 'asm' mode
+	GoldenImagesDir    string              `json:"goldenImagesDir"`    // expected frames relative to assignmentCodeDir as <seed>/final.png or <seed>/<di>.png, only used in 'asm' mode
+	ImageTolerance     ImageTolerance      `json:"imageTolerance"`     // how close the frames have to be to the expected ones
 }
 
 var conf *Config
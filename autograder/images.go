@@ -0,0 +1,132 @@
+package autograder
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/emulator"
+)
+
+// Golden images. Assignments judged on what ends up on screen keep the expected frames of each seed in the
+// golden images directory, final.png for the frame at the end of the run and <di>.png for the frame after
+// that many instructions. Every run captures the frames any seed has, and a run only passes if its frames
+// match the ones of its seed within the tolerance in the config.
+
+// Returns the frames the runs have to capture for the golden images of every seed
+func goldenScreenshots(goldenDir string) emulator.ScreenshotOptions {
+	options := emulator.ScreenshotOptions{}
+	counts := map[int]bool{}
+	filepath.WalkDir(goldenDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".png" {
+			return nil
+		}
+
+		name := strings.TrimSuffix(d.Name(), ".png")
+		if name == "final" {
+			options.Final = true
+		} else if di, e := strconv.Atoi(name); e == nil && di >= 0 {
+			counts[di] = true
+		}
+		return nil
+	})
+
+	for di := range counts {
+		options.AtDI = append(options.AtDI, di)
+	}
+	sort.Ints(options.AtDI)
+	return options
+}
+
+// Compares the frames a run captured against the golden images in the directory, returning a description of
+// every difference. A missing golden directory means the seed has no expected frames.
+func compareFrames(goldenDir string, result emulator.EvaluationRunResult, tolerance ImageTolerance) []string {
+	mismatches := []string{}
+	entries, e := os.ReadDir(goldenDir)
+	if e != nil {
+		return mismatches
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".png" {
+			continue
+		}
+
+		var actual []byte
+		frameName := "The final frame"
+		if name == "final.png" {
+			actual = result.FinalFrame
+		} else if di, e := strconv.Atoi(strings.TrimSuffix(name, ".png")); e == nil && di >= 0 {
+			frameName = fmt.Sprintf("The frame after %d instructions", di)
+			for _, frame := range result.Frames {
+				if frame.DI == di {
+					actual = frame.PNG
+				}
+			}
+		} else {
+			continue
+		}
+
+		expected, e := readPNG(filepath.Join(goldenDir, name))
+		if e != nil {
+			mismatches = append(mismatches, "Could not read expected frame "+name+": "+e.Error())
+			continue
+		}
+
+		img, e := png.Decode(bytes.NewReader(actual))
+		if e != nil {
+			mismatches = append(mismatches, frameName+" was not captured")
+		} else if mismatch := compareImages(expected, img, tolerance); mismatch != "" {
+			mismatches = append(mismatches, frameName+" "+mismatch)
+		}
+	}
+
+	return mismatches
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// Returns how the frame differs from the expected one, or "" when it matches within the tolerance
+func compareImages(expected, actual image.Image, tolerance ImageTolerance) string {
+	eb, ab := expected.Bounds(), actual.Bounds()
+	if eb.Dx() != ab.Dx() || eb.Dy() != ab.Dy() {
+		return fmt.Sprintf("is %dx%d instead of %dx%d", ab.Dx(), ab.Dy(), eb.Dx(), eb.Dy())
+	}
+
+	channelMatches := func(e, a uint8) bool {
+		diff := int(e) - int(a)
+		return diff <= tolerance.Channel && -diff <= tolerance.Channel
+	}
+
+	different := 0
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			e := color.NRGBAModel.Convert(expected.At(eb.Min.X+x, eb.Min.Y+y)).(color.NRGBA)
+			a := color.NRGBAModel.Convert(actual.At(ab.Min.X+x, ab.Min.Y+y)).(color.NRGBA)
+			if !channelMatches(e.R, a.R) || !channelMatches(e.G, a.G) || !channelMatches(e.B, a.B) || !channelMatches(e.A, a.A) {
+				different++
+			}
+		}
+	}
+
+	total := eb.Dx() * eb.Dy()
+	if different == 0 || float64(different) <= tolerance.Pixels*float64(total) {
+		return ""
+	}
+	return fmt.Sprintf("has %d of %d pixels that differ from the expected frame (%.2f%%)", different, total, float64(different)/float64(total)*100)
+}
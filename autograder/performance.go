@@ -0,0 +1,147 @@
+package autograder
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/emulator"
+)
+
+// Scores the efficiency of a submission against the performance curves in the config. Points are
+// only awarded when every seed passed, otherwise an incorrect but short program would be rewarded.
+func gradePerformance(gso *GradescopeOutput, metrics []PerformanceMetric, results []emulator.EvaluationRunResult) {
+	if len(metrics) == 0 || len(results) == 0 {
+		return
+	}
+
+	allPassed := true
+	for _, result := range results {
+		if !result.Passed {
+			allPassed = false
+			break
+		}
+	}
+
+	for _, metric := range metrics {
+		value, ok := aggregateMetric(results, metric.Metric, metric.Aggregate)
+		visibility := metric.Visibility
+		if visibility == "" {
+			visibility = "visible"
+		}
+
+		tc := CreateTestCase(metric.Name, metric.Points, visibility)
+		if !ok {
+			tc.OutputPrintLn("Invalid performance metric configuration: metric=" + metric.Metric + " aggregate=" + metric.Aggregate)
+			tc.SetStatus(false)
+			gso.AddTest(tc, 0)
+			continue
+		}
+
+		aggregateName := "Average"
+		if metric.Aggregate == "worst" {
+			aggregateName = "Worst-case"
+		}
+		tc.OutputPrintLn(fmt.Sprintf("%s %s: %.2f", aggregateName, metricDisplayName(metric.Metric), value))
+
+		earnedPoints := 0
+		if !allPassed {
+			tc.OutputPrintLn("Performance points are only awarded when all test cases pass.")
+			tc.SetStatus(false)
+		} else {
+			credit := scoreOnCurve(value, metric.Curve)
+			earnedPoints = int(math.Round(credit * float64(metric.Points)))
+			tc.OutputPrintLn(fmt.Sprintf("Earned %d/%d points (%.0f%% credit).", earnedPoints, metric.Points, credit*100))
+			tc.SetStatus(earnedPoints == metric.Points)
+		}
+		gso.AddTest(tc, earnedPoints)
+
+		if metric.Leaderboard {
+			gso.AddLeaderBoardEntry(GradescopeLeaderBoardEntry{
+				Name:  metric.Name,
+				Value: int(math.Round(value)),
+				Order: "asc", // lower is better for all metrics
+			})
+		}
+	}
+}
+
+// Computes the average or worst-case (largest) value of a metric across all runs
+func aggregateMetric(results []emulator.EvaluationRunResult, metric, aggregate string) (float64, bool) {
+	total := 0.0
+	worst := math.Inf(-1)
+	for _, result := range results {
+		value := 0.0
+		switch metric {
+		case "di":
+			value = float64(result.DI)
+		case "si":
+			value = float64(result.SI)
+		case "regs":
+			value = float64(result.Regs)
+		case "mem":
+			value = float64(result.Mem)
+		default:
+			return 0, false
+		}
+
+		total += value
+		if value > worst {
+			worst = value
+		}
+	}
+
+	switch aggregate {
+	case "average", "":
+		return total / float64(len(results)), true
+	case "worst":
+		return worst, true
+	}
+
+	return 0, false
+}
+
+// Returns the fraction of credit for the value, linearly interpolating between the points of the curve.
+// Values outside the curve are given the credit of the nearest end point.
+func scoreOnCurve(value float64, curve []PerformanceCurvePoint) float64 {
+	if len(curve) == 0 {
+		return 0
+	}
+
+	points := make([]PerformanceCurvePoint, len(curve))
+	copy(points, curve)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Value < points[j].Value
+	})
+
+	credit := points[len(points)-1].Credit
+	if value <= points[0].Value {
+		credit = points[0].Credit
+	} else {
+		for i := 1; i < len(points); i++ {
+			if value <= points[i].Value {
+				lower := points[i-1]
+				upper := points[i]
+				t := (value - lower.Value) / (upper.Value - lower.Value)
+				credit = lower.Credit + t*(upper.Credit-lower.Credit)
+				break
+			}
+		}
+	}
+
+	return math.Max(0, math.Min(1, credit))
+}
+
+func metricDisplayName(metric string) string {
+	switch metric {
+	case "di":
+		return "Dynamic Instruction Count"
+	case "si":
+		return "Static Instruction Count"
+	case "regs":
+		return "Register Usage"
+	case "mem":
+		return "Memory Usage"
+	}
+	return metric
+}
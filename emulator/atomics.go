@@ -0,0 +1,98 @@
+package emulator
+
+import "github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+
+// RV32A support. Harts never run at the same time, so every AMO is atomic and the aq and rl ordering bits
+// have no effect. An lr.w reservation covers the word and is lost when any hart stores to it, see harts.go.
+
+func (inst *EmulatorInstance) executeAMO(instruction uint32) {
+	opcode, rd, rs1, rs2, func7, func3 := assembler.DecodeRTypeInstruction(instruction)
+	if func3 != 0b010 {
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported atomic instruction exception: op=%d func3=%d", opcode, func3)
+		return
+	}
+
+	addr := inst.regRead(rs1)
+	if addr&0x3 != 0 {
+		inst.newMemoryAccessNotAlignedException(addr, "atomic")
+		return
+	}
+
+	switch func7 >> 2 {
+	case 0b00010:
+		// LR.W
+		value := inst.memReadWord(addr, false)
+		inst.reservations[inst.hartID] = addr
+		if rd != 0 {
+			inst.regWrite(rd, value)
+		}
+	case 0b00011:
+		// SC.W
+		result := uint32(1)
+		if inst.reservations[inst.hartID] == addr {
+			inst.memWriteWord(addr, inst.regRead(rs2))
+			result = 0
+		}
+		inst.reservations[inst.hartID] = noReservation
+		if rd != 0 {
+			inst.regWrite(rd, result)
+		}
+	default:
+		source := inst.regRead(rs2)
+		old := inst.memReadWord(addr, false)
+		if inst.trap != nil {
+			return
+		}
+
+		value := uint32(0)
+		switch func7 >> 2 {
+		case 0b00001:
+			// AMOSWAP.W
+			value = source
+		case 0b00000:
+			// AMOADD.W
+			value = old + source
+		case 0b00100:
+			// AMOXOR.W
+			value = old ^ source
+		case 0b01100:
+			// AMOAND.W
+			value = old & source
+		case 0b01000:
+			// AMOOR.W
+			value = old | source
+		case 0b10000:
+			// AMOMIN.W
+			value = old
+			if int32(source) < int32(old) {
+				value = source
+			}
+		case 0b10100:
+			// AMOMAX.W
+			value = old
+			if int32(source) > int32(old) {
+				value = source
+			}
+		case 0b11000:
+			// AMOMINU.W
+			value = old
+			if source < old {
+				value = source
+			}
+		case 0b11100:
+			// AMOMAXU.W
+			value = old
+			if source > old {
+				value = source
+			}
+		default:
+			inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported atomic instruction exception: op=%d func7=%d", opcode, func7)
+			return
+		}
+
+		inst.memWriteWord(addr, value)
+		if rd != 0 {
+			inst.regWrite(rd, old)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+)
+
+// Branch prediction. The predictor is trained as executeBType, executeJAL, and executeJALR resolve and counts
+// how many of its predictions were right, by branch site. It never changes where the program goes, but when
+// the pipeline is modeled, correctly predicted branches and jumps do not flush it (see pipeline.go).
+//
+// The direction predictors only predict conditional branches, and a branch predicted taken is assumed to go
+// to its target right away. With a branch target buffer (BTB), a branch predicted taken is only predicted
+// correctly when the BTB also has its target, and jumps are predicted as well, jal and jalr are correct when
+// the BTB has their target. Without a BTB, jumps are not predicted and not counted. The BTB is direct mapped
+// and remembers the target of every taken branch and jump. Like the other statistics, only the branches of
+// user code are predicted.
+
+type BranchPredictorType string
+
+const (
+	PredictTaken    BranchPredictorType = "taken"    // static, every branch is taken
+	PredictNotTaken BranchPredictorType = "nottaken" // static, no branch is taken
+	Predict1Bit     BranchPredictorType = "1bit"     // the last direction of the branch
+	Predict2Bit     BranchPredictorType = "2bit"     // saturating counters that need two mispredictions to flip
+	PredictGshare   BranchPredictorType = "gshare"   // 2-bit counters indexed by the pc xor the global history
+)
+
+type BranchPredictorConfig struct {
+	Type        BranchPredictorType `json:"type"`
+	Entries     int                 `json:"entries"`     // of the 1bit, 2bit, and gshare tables, defaults to 1024
+	HistoryBits int                 `json:"historyBits"` // of the global history of gshare, defaults to 8
+	BTBEntries  int                 `json:"btbEntries"`  // of the branch target buffer, no BTB when 0
+}
+
+type BranchReport struct {
+	Predictions uint64             `json:"predictions"`
+	Correct     uint64             `json:"correct"`
+	Accuracy    float64            `json:"accuracy"` // percentage of correct predictions, 100 without predictions
+	Sites       []BranchSiteReport `json:"sites,omitempty"`
+}
+
+// The predictions of the branches and jumps at one line of the source
+type BranchSiteReport struct {
+	Line        int     `json:"line"` // 1-based
+	Predictions uint64  `json:"predictions"`
+	Correct     uint64  `json:"correct"`
+	Accuracy    float64 `json:"accuracy"`
+}
+
+type branchSiteStats struct {
+	predictions uint64
+	correct     uint64
+}
+
+type btbEntry struct {
+	pc     uint32
+	target uint32
+	valid  bool
+}
+
+type branchPredictor struct {
+	config      BranchPredictorConfig
+	table       []uint8 // the last direction for 1bit, the counters for 2bit and gshare
+	history     uint32  // global history of gshare, the last direction is the lowest bit
+	btb         []btbEntry
+	predictions uint64
+	correct     uint64
+	sites       map[uint32]*branchSiteStats // by pc
+
+	// whether the instruction that just executed was predicted, and if it was right, for the pipeline
+	resolved        bool
+	resolvedCorrect bool
+}
+
+// Returns an error describing the invalid setting, the emulator only accepts valid configurations
+func (config *BranchPredictorConfig) Validate() error {
+	isPowerOfTwo := func(n int) bool {
+		return n > 0 && n&(n-1) == 0
+	}
+
+	switch config.Type {
+	case PredictTaken, PredictNotTaken, Predict1Bit, Predict2Bit, PredictGshare:
+	default:
+		return fmt.Errorf("unknown branch predictor %q, expected taken, nottaken, 1bit, 2bit, or gshare", config.Type)
+	}
+	if config.Entries != 0 && !isPowerOfTwo(config.Entries) {
+		return fmt.Errorf("the entries of the branch predictor must be a power of two, got %d", config.Entries)
+	}
+	if config.HistoryBits < 0 || config.HistoryBits > 16 {
+		return fmt.Errorf("the history of the branch predictor must be 0 to 16 bits, got %d", config.HistoryBits)
+	}
+	if config.BTBEntries != 0 && !isPowerOfTwo(config.BTBEntries) {
+		return fmt.Errorf("the entries of the branch target buffer must be a power of two, got %d", config.BTBEntries)
+	}
+	return nil
+}
+
+// Creates a predictor for a configuration that passed Validate
+func newBranchPredictor(config BranchPredictorConfig) *branchPredictor {
+	if config.Entries == 0 {
+		config.Entries = 1024
+	}
+	if config.HistoryBits == 0 {
+		config.HistoryBits = 8
+	}
+
+	p := &branchPredictor{
+		config: config,
+		sites:  map[uint32]*branchSiteStats{},
+	}
+	switch config.Type {
+	case Predict1Bit:
+		p.table = make([]uint8, config.Entries) // not taken
+	case Predict2Bit, PredictGshare:
+		p.table = make([]uint8, config.Entries)
+		for i := range p.table {
+			p.table[i] = 1 // weakly not taken
+		}
+	}
+	if config.BTBEntries != 0 {
+		p.btb = make([]btbEntry, config.BTBEntries)
+	}
+	return p
+}
+
+// Returns a copy with its own tables and statistics
+func (p *branchPredictor) clone() *branchPredictor {
+	if p == nil {
+		return nil
+	}
+
+	copied := *p
+	copied.table = append([]uint8{}, p.table...)
+	copied.btb = append([]btbEntry{}, p.btb...)
+	copied.sites = make(map[uint32]*branchSiteStats, len(p.sites))
+	for pc, stats := range p.sites {
+		s := *stats
+		copied.sites[pc] = &s
+	}
+	return &copied
+}
+
+// Returns the entry of the 1bit, 2bit, or gshare table for the branch, instructions are 2-byte aligned
+func (p *branchPredictor) tableIndex(pc uint32) uint32 {
+	index := pc >> 1
+	if p.config.Type == PredictGshare {
+		index ^= p.history & (1<<p.config.HistoryBits - 1)
+	}
+	return index & uint32(len(p.table)-1)
+}
+
+func (p *branchPredictor) predictTaken(pc uint32) bool {
+	switch p.config.Type {
+	case PredictTaken:
+		return true
+	case Predict1Bit:
+		return p.table[p.tableIndex(pc)] == 1
+	case Predict2Bit, PredictGshare:
+		return p.table[p.tableIndex(pc)] >= 2
+	}
+	return false
+}
+
+func (p *branchPredictor) train(pc uint32, taken bool) {
+	index := p.tableIndex(pc)
+	switch p.config.Type {
+	case Predict1Bit:
+		p.table[index] = 0
+		if taken {
+			p.table[index] = 1
+		}
+	case Predict2Bit, PredictGshare:
+		if taken && p.table[index] < 3 {
+			p.table[index]++
+		} else if !taken && p.table[index] > 0 {
+			p.table[index]--
+		}
+	}
+
+	p.history <<= 1
+	if taken {
+		p.history |= 1
+	}
+}
+
+// Returns whether the BTB has the target of the branch, and remembers the target if it was taken
+func (p *branchPredictor) lookupTarget(pc, target uint32, taken bool) bool {
+	entry := &p.btb[(pc>>1)&uint32(len(p.btb)-1)]
+	hit := entry.valid && entry.pc == pc && entry.target == target
+	if taken {
+		*entry = btbEntry{pc: pc, target: target, valid: true}
+	}
+	return hit
+}
+
+// Called by the branch and jump at pc once it knows if it is taken and where it goes
+func (p *branchPredictor) resolve(inst *EmulatorInstance, pc, target uint32, taken, conditional bool) {
+	if pc >= inst.profileIgnoreRangeStart && pc < inst.profileIgnoreRangeEnd {
+		return
+	}
+
+	var correct bool
+	if conditional {
+		predictedTaken := p.predictTaken(pc)
+		correct = predictedTaken == taken
+		if p.btb != nil {
+			hit := p.lookupTarget(pc, target, taken)
+			if predictedTaken && !hit {
+				correct = false
+			}
+		}
+		p.train(pc, taken)
+	} else if p.btb != nil {
+		correct = p.lookupTarget(pc, target, true)
+	} else {
+		return
+	}
+
+	p.resolved = true
+	p.resolvedCorrect = correct
+
+	site, ok := p.sites[pc]
+	if !ok {
+		site = &branchSiteStats{}
+		p.sites[pc] = site
+	}
+	site.predictions++
+	p.predictions++
+	if correct {
+		site.correct++
+		p.correct++
+	}
+}
+
+func branchAccuracy(predictions, correct uint64) float64 {
+	if predictions == 0 {
+		return 100
+	}
+	return float64(correct) / float64(predictions) * 100
+}
+
+// Returns the accuracy overall and by line of the source loaded at offset, which can be nil
+func (p *branchPredictor) report(source *assembler.AssembledResult, offset uint32) *BranchReport {
+	report := &BranchReport{
+		Predictions: p.predictions,
+		Correct:     p.correct,
+		Accuracy:    branchAccuracy(p.predictions, p.correct),
+	}
+
+	if source == nil {
+		return report
+	}
+
+	sites := map[int]*BranchSiteReport{}
+	for pc, stats := range p.sites {
+		if pc < offset {
+			continue
+		}
+		line, ok := source.AddressToLine[pc-offset]
+		if !ok {
+			continue
+		}
+		site, ok := sites[line+1]
+		if !ok {
+			site = &BranchSiteReport{Line: line + 1}
+			sites[line+1] = site
+		}
+		site.Predictions += stats.predictions
+		site.Correct += stats.correct
+	}
+	for _, site := range sites {
+		site.Accuracy = branchAccuracy(site.Predictions, site.Correct)
+		report.Sites = append(report.Sites, *site)
+	}
+	sort.Slice(report.Sites, func(i, j int) bool { return report.Sites[i].Line < report.Sites[j].Line })
+	return report
+}
+
+// Adds the accuracy and the sites to the stats sent to the debugger
+func (r *BranchReport) addStats(stats map[string]interface{}) {
+	stats["branchPredictions"] = int(r.Predictions)
+	stats["branchAccuracy"] = r.Accuracy
+	stats["branchSites"] = r.Sites
+}
+
+func (r *BranchReport) String() string {
+	return fmt.Sprintf("Branch Predictions = %d, Correct = %d (%.2f%% accuracy)", r.Predictions, r.Correct, r.Accuracy)
+}
+
+// Returns the accuracy of the branch predictor, or nil when it is not enabled. The accuracy is broken down by
+// the lines of the source, which was loaded at offset and can be nil.
+func (inst *EmulatorInstance) GetBranchStats(source *assembler.AssembledResult, offset uint32) *BranchReport {
+	if inst.predictor == nil {
+		return nil
+	}
+	return inst.predictor.report(source, offset)
+}
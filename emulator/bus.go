@@ -0,0 +1,495 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Peripheral bus. Every device in the reserved memory above 0x80000000 is a Peripheral attached to the bus of
+// the emulator, which routes the loads and stores in its address range to it. The built-in devices are
+// attached by NewEmulator (see memReadReserved for the memory map), and assignments can attach their own
+// devices with AddPeripheral in the ranges that are still free. Accesses to addresses no device handles are
+// segmentation faults.
+
+// A memory mapped device. The handlers run on the emulator goroutine, so a device that is also updated from
+// other goroutines, like the keyboard, must do its own locking.
+type Peripheral interface {
+	// Returns the first and last address handled by the device, the range must be word aligned and in the
+	// reserved memory
+	Range() (start, end uint32)
+
+	// Returns the word at offset bytes from the start of the range, the emulator picks the byte or half of
+	// smaller loads out of it. Returning false faults the load.
+	Read(inst *EmulatorInstance, offset uint32) (uint32, bool)
+
+	// Stores the bytes of value selected by bitmask, which is shifted into place for the offset but value is
+	// not, so a byte store has the byte in the low bits of value. Returning false faults the store.
+	Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool
+
+	// Puts the device in its power-on state, called when it is attached and, for devices attached with
+	// AddPeripheral, when a snapshot is restored since their state is not part of it
+	Reset(inst *EmulatorInstance)
+
+	// Called before every user instruction, after it is counted in DI
+	Tick(inst *EmulatorInstance)
+}
+
+type attachedPeripheral struct {
+	peripheral Peripheral
+	start      uint32
+	end        uint32 // inclusive
+	builtin    bool
+}
+
+// Anything that runs before every user instruction, like the devices and the frame captures of batch runs
+type ticker interface {
+	Tick(inst *EmulatorInstance)
+}
+
+type peripheralBus struct {
+	devices []attachedPeripheral // sorted by address
+	tickers []ticker             // the built-in devices that do nothing on a tick are left out
+}
+
+// Attaches a device to the bus of the emulator, returning an error when its range is invalid or overlaps
+// another device. Must not be called while the emulator is running.
+func (inst *EmulatorInstance) AddPeripheral(p Peripheral) error {
+	return inst.bus.attach(inst, p, false, true)
+}
+
+func (b *peripheralBus) attach(inst *EmulatorInstance, p Peripheral, builtin, ticks bool) error {
+	start, end := p.Range()
+	if start < 0x80000000 || end < start || start&0x3 != 0 || end&0x3 != 0x3 {
+		return fmt.Errorf("invalid peripheral range 0x%08X-0x%08X, it must be word aligned and in the reserved memory", start, end)
+	}
+
+	i := sort.Search(len(b.devices), func(i int) bool { return b.devices[i].end >= start })
+	if i < len(b.devices) && b.devices[i].start <= end {
+		return fmt.Errorf("the peripheral range 0x%08X-0x%08X overlaps 0x%08X-0x%08X", start, end, b.devices[i].start, b.devices[i].end)
+	}
+
+	b.devices = append(b.devices, attachedPeripheral{})
+	copy(b.devices[i+1:], b.devices[i:])
+	b.devices[i] = attachedPeripheral{peripheral: p, start: start, end: end, builtin: builtin}
+	if ticks {
+		b.tickers = append(b.tickers, p)
+	}
+	p.Reset(inst)
+	return nil
+}
+
+// Returns the device handling the address, or nil
+func (b *peripheralBus) find(addr uint32) *attachedPeripheral {
+	i := sort.Search(len(b.devices), func(i int) bool { return b.devices[i].end >= addr })
+	if i < len(b.devices) && b.devices[i].start <= addr {
+		return &b.devices[i]
+	}
+	return nil
+}
+
+func (b *peripheralBus) tick(inst *EmulatorInstance) {
+	for _, p := range b.tickers {
+		p.Tick(inst)
+	}
+}
+
+// Resets the devices attached with AddPeripheral, the state of the built-in ones is restored with the snapshot
+func (b *peripheralBus) resetAdded(inst *EmulatorInstance) {
+	for _, device := range b.devices {
+		if !device.builtin {
+			device.peripheral.Reset(inst)
+		}
+	}
+}
+
+func (inst *EmulatorInstance) attachBuiltinPeripherals() {
+	builtins := []Peripheral{
+		keyboardPeripheral{},
+		mousePeripheral{},
+		hartPeripheral{},
+		shapeDrawPeripheral{},
+		systemPeripheral{},
+		interruptContextPeripheral{},
+		displayPeripheral{},
+		filesystemPeripheral{},
+	}
+	for _, p := range builtins {
+		inst.bus.attach(inst, p, true, false)
+	}
+	inst.bus.attach(inst, interruptPeripheral{}, true, true)      // the timer counts user instructions
+	inst.bus.attach(inst, displayControlPeripheral{}, true, true) // the display refreshes every so many user instructions
+}
+
+// Built-in devices, their state is kept in the EmulatorInstance so snapshots and the debugger can get to it.
+// They do not need a Tick unless they are attached as tickers.
+
+type builtinPeripheral struct{}
+
+func (builtinPeripheral) Reset(inst *EmulatorInstance) {}
+func (builtinPeripheral) Tick(inst *EmulatorInstance)  {}
+
+// 0x80002E00 - 0x80002E27: Keyboard
+type keyboardPeripheral struct{ builtinPeripheral }
+
+func (keyboardPeripheral) Range() (uint32, uint32) { return 0x80002E00, 0x80002E27 }
+
+func (keyboardPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	switch {
+	case offset < 0x20:
+		// Keyboard Key State Bitmap READONLY
+		return inst.keyboard.getKeyState(offset >> 2), true
+	case offset&^0x3 == 0x20:
+		// Keyboard Event FIFO READONLY
+		return inst.keyboard.popKeyEvent(), true
+	default:
+		// Keyboard Event FIFO Count READONLY
+		return inst.keyboard.getNumKeyEvents(), true
+	}
+}
+
+func (keyboardPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	return false // READONLY
+}
+
+func (keyboardPeripheral) Reset(inst *EmulatorInstance) {
+	inst.keyboard.mutex.Lock()
+	defer inst.keyboard.mutex.Unlock()
+
+	inst.keyboard.keyState = [8]uint32{}
+	inst.keyboard.events = nil
+}
+
+// 0x80002E40 - 0x80002E4B: Mouse
+type mousePeripheral struct{ builtinPeripheral }
+
+func (mousePeripheral) Range() (uint32, uint32) { return 0x80002E40, 0x80002E4B }
+
+func (mousePeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	x, y, buttons := inst.mouse.getState()
+	switch offset &^ 0x3 {
+	case 0x0:
+		// Mouse X READONLY
+		return uint32(x), true
+	case 0x4:
+		// Mouse Y READONLY
+		return uint32(y), true
+	default:
+		// Mouse Buttons READONLY
+		return buttons, true
+	}
+}
+
+func (mousePeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	return false // READONLY
+}
+
+func (mousePeripheral) Reset(inst *EmulatorInstance) {
+	inst.mouse.mouseEvent(0, 0, 0)
+}
+
+// 0x80002E80 - 0x80002E9B: Harts, see harts.go
+type hartPeripheral struct{ builtinPeripheral }
+
+func (hartPeripheral) Range() (uint32, uint32) { return 0x80002E80, 0x80002E9B }
+
+func (hartPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	switch offset &^ 0x3 {
+	case 0x00:
+		// Hart Count READONLY
+		return uint32(len(inst.harts)), true
+	case 0x04:
+		// Hart ID READONLY
+		return inst.hartID, true
+	case 0x08:
+		// Hart Start Address
+		return inst.hartStart[0], true
+	case 0x0C:
+		// Hart Start Argument
+		return inst.hartStart[1], true
+	case 0x10:
+		// Hart Start Stack Pointer
+		return inst.hartStart[2], true
+	case 0x18:
+		// Running Harts READONLY
+		return inst.getRunningHarts(), true
+	}
+	return 0, false // Hart Start WRITEONLY
+}
+
+func (hartPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	switch offset &^ 0x3 {
+	case 0x08:
+		// Hart Start Address
+		inst.hartStart[0] = value
+	case 0x0C:
+		// Hart Start Argument
+		inst.hartStart[1] = value
+	case 0x10:
+		// Hart Start Stack Pointer
+		inst.hartStart[2] = value
+	case 0x14:
+		// Hart Start WRITEONLY
+		inst.startHart(value)
+	default:
+		// Hart Count, Hart ID, and Running Harts READONLY
+		return false
+	}
+	return true
+}
+
+func (hartPeripheral) Reset(inst *EmulatorInstance) {
+	inst.hartStart = [3]uint32{}
+}
+
+// 0x80002F00 - 0x80002F0F: Virtual Display Control, double buffering and vsync, see peripherals.go
+type displayControlPeripheral struct{}
+
+func (displayControlPeripheral) Range() (uint32, uint32) { return 0x80002F00, 0x80002F0F }
+
+func (displayControlPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	switch offset &^ 0x3 {
+	case 0x0:
+		// Double Buffering Enable
+		if inst.display.back != nil {
+			return 1, true
+		}
+		return 0, true
+	case 0x8:
+		// Frame Counter READONLY
+		return inst.display.frames, true
+	case 0xC:
+		// VSync Status READONLY (refreshes since the last present)
+		return inst.display.refreshes, true
+	}
+	return 0, false // Present WRITEONLY
+}
+
+func (displayControlPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	switch offset &^ 0x3 {
+	case 0x0:
+		// Double Buffering Enable
+		inst.display.setDoubleBuffered(value != 0)
+	case 0x4:
+		// Present WRITEONLY
+		inst.display.present()
+	default:
+		// Frame Counter and VSync Status READONLY
+		return false
+	}
+	return true
+}
+
+func (displayControlPeripheral) Reset(inst *EmulatorInstance) {
+	inst.display.back = nil
+	inst.display.frames = 0
+	inst.display.refreshCounter = 0
+	inst.display.refreshes = 0
+}
+
+func (displayControlPeripheral) Tick(inst *EmulatorInstance) {
+	inst.display.tickRefresh()
+}
+
+// 0x80002FD0 - 0x80002FDF: Interrupt controller, see interrupts.go
+type interruptPeripheral struct{}
+
+func (interruptPeripheral) Range() (uint32, uint32) { return 0x80002FD0, 0x80002FDF }
+
+func (interruptPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	switch offset &^ 0x3 {
+	case 0x0:
+		// Interrupt Enable Mask
+		inst.interruptMutex.Lock()
+		defer inst.interruptMutex.Unlock()
+		return inst.interruptMask, true
+	case 0x4:
+		// Timer Interrupt Period
+		return inst.timerPeriod, true
+	case 0x8:
+		// Pending Interrupts READONLY
+		return inst.getPendingInterrupts(), true
+	}
+	return 0, false // Software Interrupt Trigger WRITEONLY
+}
+
+func (interruptPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	switch offset &^ 0x3 {
+	case 0x0:
+		// Interrupt Enable Mask
+		inst.setInterruptMask(value)
+	case 0x4:
+		// Timer Interrupt Period
+		inst.timerPeriod = value
+		inst.timerCounter = 0
+	case 0x8:
+		// Pending Interrupts READONLY
+		return false
+	case 0xC:
+		// Software Interrupt Trigger WRITEONLY
+		inst.Interrupt(&Interrupt{ID: value})
+	}
+	return true
+}
+
+func (interruptPeripheral) Reset(inst *EmulatorInstance) {
+	inst.setInterruptMask(0)
+	inst.timerPeriod = 0
+	inst.timerCounter = 0
+}
+
+func (interruptPeripheral) Tick(inst *EmulatorInstance) {
+	if inst.timerPeriod != 0 {
+		inst.tickTimer()
+	}
+}
+
+// 0x80002FE0 - 0x80002FFF: Virtual Display Shape Draw, see draw.go
+type shapeDrawPeripheral struct{ builtinPeripheral }
+
+func (shapeDrawPeripheral) Range() (uint32, uint32) { return 0x80002FE0, 0x80002FFF }
+
+func (shapeDrawPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	return 0, false // WRITEONLY
+}
+
+func (shapeDrawPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	switch offset &^ 0x3 {
+	case 0x00:
+		// Virtual Display Draw Command (executes the command on write)
+		inst.display.draw(value, inst.memory)
+	case 0x04, 0x08:
+		// Virtual Display Draw Parameters 4-5
+		inst.display.shapeDrawParams[4+(offset-0x04)>>2] = value
+	case 0x0C:
+		// Virtual Display Shape Draw Filled Rectangle Color (executes the draw on write)
+		inst.display.drawFilledRectangle(value)
+	default:
+		// Virtual Display Shape Draw Parameters 0-3
+		inst.display.shapeDrawParams[(offset-0x10)>>2] = value
+	}
+	return true
+}
+
+func (shapeDrawPeripheral) Reset(inst *EmulatorInstance) {
+	inst.display.shapeDrawParams = [6]uint32{}
+}
+
+// 0x80003000 - 0x8000301F: OS entry points, StdOut, display size, seed, solution correctness, and interrupt ID
+type systemPeripheral struct{ builtinPeripheral }
+
+func (systemPeripheral) Range() (uint32, uint32) { return 0x80003000, 0x8000301F }
+
+func (systemPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	switch offset &^ 0x3 {
+	case 0x00:
+		// OS ECALL Handler Entry Point
+		return inst.osEntry, true
+	case 0x08:
+		// Virtual Display Width
+		return uint32(inst.display.width), true
+	case 0x0C:
+		// Virtual Display Height
+		return uint32(inst.display.height), true
+	case 0x10:
+		// OS Interrupt Handler Entry Point
+		return inst.osInterruptHandlerEntry, true
+	case 0x14:
+		// Random number seed READONLY
+		return inst.randomSeed, true
+	case 0x1C:
+		// Interrupt ID
+		if inst.interrupt == nil {
+			return 0, true
+		}
+		return uint32(inst.interrupt.ID), true
+	}
+	return 0, false // StdOut Pipe and Solution Correctness WRITEONLY
+}
+
+func (systemPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	switch offset &^ 0x3 {
+	case 0x00:
+		// OS ECALL Handler Entry Point
+		inst.osEntry = value
+	case 0x04:
+		// StdOut Pipe WRITEONLY
+		if inst.stdOutCallback != nil {
+			inst.stdOutCallback(byte(value))
+		}
+	case 0x08:
+		// Virtual Display Width
+		inst.display.width = int(value)
+	case 0x0C:
+		// Virtual Display Height
+		inst.display.height = int(value)
+	case 0x10:
+		// OS Interrupt Handler Entry Point
+		inst.osInterruptHandlerEntry = value
+	case 0x18:
+		// Solution Correctness WRITEONLY (only from OS code)
+		if inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd {
+			return false
+		}
+		inst.solutionValidity = value
+	default:
+		// Random number seed and Interrupt ID READONLY
+		return false
+	}
+	return true
+}
+
+// 0x80003020 - 0x8000FFFF: Interrupt context READONLY, the data of the interrupt being serviced
+type interruptContextPeripheral struct{ builtinPeripheral }
+
+func (interruptContextPeripheral) Range() (uint32, uint32) { return 0x80003020, 0x8000FFFF }
+
+func (interruptContextPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	if inst.interrupt == nil || offset>>2 >= uint32(len(inst.interrupt.Data)) {
+		return 0, false
+	}
+	return inst.interrupt.Data[offset>>2], true
+}
+
+func (interruptContextPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	return false
+}
+
+// 0x80010000 - 0x807FFFFF: Virtual Display Pixel Data [RGBA][RGBA]...
+type displayPeripheral struct{ builtinPeripheral }
+
+func (displayPeripheral) Range() (uint32, uint32) { return 0x80010000, 0x807FFFFF }
+
+func (displayPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	// the program reads back what it drew, the emulator is the only one changing the back buffer
+	pixels, _ := inst.display.target()
+	return pixels[offset>>2], true
+}
+
+func (displayPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	updateRegion := inst.display.getUpdateOffset(offset >> 2)
+
+	inst.display.dataMutex.Lock()
+	inst.display.written(int(offset>>2) + 1)
+	pixels, regions := inst.display.target()
+	pixels[offset>>2] = (pixels[offset>>2] & ^bitmask) | (value << ((offset & 0x3) * 8))
+	regions[updateRegion] = true
+	inst.display.dataMutex.Unlock()
+	return true
+}
+
+// 0x80800000 - 0xFFFFFFFF: Virtual FAT Storage Filesystem, see filesystem.go
+type filesystemPeripheral struct{ builtinPeripheral }
+
+func (filesystemPeripheral) Range() (uint32, uint32) { return 0x80800000, 0xFFFFFFFF }
+
+func (filesystemPeripheral) Read(inst *EmulatorInstance, offset uint32) (uint32, bool) {
+	if inst.fs == nil {
+		return 0, false
+	}
+	return inst.fs.readWord(offset)
+}
+
+func (filesystemPeripheral) Write(inst *EmulatorInstance, offset, bitmask, value uint32) bool {
+	// only file data is writable
+	return inst.fs != nil && inst.fs.writeWord(offset, bitmask, value)
+}
@@ -0,0 +1,369 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Cache simulation. This models the timing behavior of an L1 instruction cache, an L1 data cache, and an
+// optional unified L2 cache behind both, and only counts hits, misses, evictions, and write backs. The data
+// always comes from the memory image, so the simulation never changes what the program computes. (The iCache
+// and dCache fields of the EmulatorInstance are unrelated, they only remember the last page looked up.)
+//
+// Every fetch and every data access through memReadRaw and memWriteRaw goes through the caches, except
+// accesses to the reserved memory above 0x80000000, which is memory mapped I/O and uncached. The OS code
+// warms the caches like any other code, but like the other statistics, only the accesses of user
+// instructions are counted. A fetch of a 32-bit instruction that straddles two lines accesses both.
+//
+// A write back cache allocates a line on a write miss and writes dirty lines to the next level when they are
+// evicted. A write through cache writes every store to the next level and does not allocate on a write miss.
+
+type CacheReplacement string
+
+const (
+	CacheLRU    CacheReplacement = "lru"    // evicts the least recently used line of the set
+	CacheFIFO   CacheReplacement = "fifo"   // evicts the line that was filled first
+	CacheRandom CacheReplacement = "random" // evicts a line picked from the random seed
+)
+
+type CacheWritePolicy string
+
+const (
+	CacheWriteBack    CacheWritePolicy = "writeback"
+	CacheWriteThrough CacheWritePolicy = "writethrough"
+)
+
+type CacheConfig struct {
+	Size          int              `json:"size"`          // in bytes
+	Associativity int              `json:"associativity"` // lines per set, 1 is direct mapped
+	LineSize      int              `json:"lineSize"`      // in bytes
+	Replacement   CacheReplacement `json:"replacement"`   // defaults to lru
+	WritePolicy   CacheWritePolicy `json:"writePolicy"`   // defaults to writeback
+}
+
+type CacheHierarchyConfig struct {
+	L1I CacheConfig  `json:"l1i"`
+	L1D CacheConfig  `json:"l1d"`
+	L2  *CacheConfig `json:"l2,omitempty"` // unified and shared by both L1 caches, no L2 when nil
+}
+
+type CacheStats struct {
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`  // valid lines replaced on a miss
+	WriteBacks uint64 `json:"writeBacks"` // dirty lines written to the next level
+}
+
+type CacheReport struct {
+	L1I CacheStats  `json:"l1i"`
+	L1D CacheStats  `json:"l1d"`
+	L2  *CacheStats `json:"l2,omitempty"`
+}
+
+type cacheLine struct {
+	tag      uint32 // the address of the line divided by the line size
+	valid    bool
+	dirty    bool
+	lastUsed uint64 // access counter of the cache when the line was last used, for lru
+	filled   uint64 // access counter of the cache when the line was filled, for fifo
+}
+
+type cache struct {
+	config    CacheConfig
+	lines     []cacheLine // the sets one after the other
+	numSets   uint32
+	lineShift uint32
+	next      *cache // nil for memory
+	counter   uint64
+	random    uint32 // xorshift state for random replacement
+	stats     CacheStats
+}
+
+type cacheHierarchy struct {
+	config CacheHierarchyConfig
+	l1i    *cache
+	l1d    *cache
+	l2     *cache
+}
+
+// Reads a cache configuration from a JSON file, for example
+//
+//	{"l1i": {"size": 4096, "associativity": 2, "lineSize": 32},
+//	 "l1d": {"size": 4096, "associativity": 4, "lineSize": 32, "replacement": "fifo", "writePolicy": "writethrough"},
+//	 "l2": {"size": 65536, "associativity": 8, "lineSize": 64}}
+func LoadCacheConfig(path string) (*CacheHierarchyConfig, error) {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+
+	config := &CacheHierarchyConfig{}
+	if e := json.Unmarshal(b, config); e != nil {
+		return nil, fmt.Errorf("invalid cache configuration: %w", e)
+	}
+	if e := config.Validate(); e != nil {
+		return nil, e
+	}
+	return config, nil
+}
+
+// Returns an error describing the first invalid cache, the emulator only accepts valid configurations
+func (config *CacheHierarchyConfig) Validate() error {
+	if e := config.L1I.validate("l1i"); e != nil {
+		return e
+	}
+	if e := config.L1D.validate("l1d"); e != nil {
+		return e
+	}
+	if config.L2 != nil {
+		return config.L2.validate("l2")
+	}
+	return nil
+}
+
+func (config CacheConfig) validate(name string) error {
+	isPowerOfTwo := func(n int) bool {
+		return n > 0 && n&(n-1) == 0
+	}
+
+	if !isPowerOfTwo(config.LineSize) || config.LineSize < 4 {
+		return fmt.Errorf("the line size of the %s cache must be a power of two of at least 4 bytes, got %d", name, config.LineSize)
+	}
+	if !isPowerOfTwo(config.Associativity) {
+		return fmt.Errorf("the associativity of the %s cache must be a power of two, got %d", name, config.Associativity)
+	}
+	if !isPowerOfTwo(config.Size) || config.Size < config.LineSize*config.Associativity {
+		return fmt.Errorf("the size of the %s cache must be a power of two that holds at least one set, got %d", name, config.Size)
+	}
+
+	switch config.Replacement {
+	case "", CacheLRU, CacheFIFO, CacheRandom:
+	default:
+		return fmt.Errorf("unknown replacement policy %q for the %s cache, expected lru, fifo, or random", config.Replacement, name)
+	}
+	switch config.WritePolicy {
+	case "", CacheWriteBack, CacheWriteThrough:
+	default:
+		return fmt.Errorf("unknown write policy %q for the %s cache, expected writeback or writethrough", config.WritePolicy, name)
+	}
+	return nil
+}
+
+func newCache(config CacheConfig, next *cache, seed uint32) *cache {
+	if config.Replacement == "" {
+		config.Replacement = CacheLRU
+	}
+	if config.WritePolicy == "" {
+		config.WritePolicy = CacheWriteBack
+	}
+
+	lineShift := uint32(0)
+	for 1<<lineShift < config.LineSize {
+		lineShift++
+	}
+
+	if seed == 0 {
+		seed = 1 // xorshift never leaves zero
+	}
+	return &cache{
+		config:    config,
+		lines:     make([]cacheLine, config.Size/config.LineSize),
+		numSets:   uint32(config.Size / (config.LineSize * config.Associativity)),
+		lineShift: lineShift,
+		next:      next,
+		random:    seed,
+	}
+}
+
+// Creates empty caches for a configuration that passed Validate
+func newCacheHierarchy(config CacheHierarchyConfig, seed uint32) *cacheHierarchy {
+	h := &cacheHierarchy{config: config}
+	if config.L2 != nil {
+		h.l2 = newCache(*config.L2, nil, seed)
+	}
+	h.l1i = newCache(config.L1I, h.l2, seed)
+	h.l1d = newCache(config.L1D, h.l2, seed)
+	return h
+}
+
+// Returns a copy with its own lines and statistics
+func (h *cacheHierarchy) clone() *cacheHierarchy {
+	if h == nil {
+		return nil
+	}
+
+	copied := &cacheHierarchy{config: h.config}
+	cloneCache := func(c *cache) *cache {
+		if c == nil {
+			return nil
+		}
+		copiedCache := *c
+		copiedCache.lines = append([]cacheLine{}, c.lines...)
+		if c.next != nil {
+			copiedCache.next = copied.l2
+		}
+		return &copiedCache
+	}
+	copied.l2 = cloneCache(h.l2)
+	copied.l1i = cloneCache(h.l1i)
+	copied.l1d = cloneCache(h.l1d)
+	return copied
+}
+
+// Simulates an access to the line holding addr, count is false for the accesses of the OS
+func (c *cache) access(addr uint32, write, count bool) {
+	c.counter++
+	tag := addr >> c.lineShift
+	ways := uint32(c.config.Associativity)
+	set := c.lines[(tag%c.numSets)*ways : (tag%c.numSets+1)*ways]
+	writeBack := c.config.WritePolicy == CacheWriteBack
+
+	for i := range set {
+		if set[i].valid && set[i].tag == tag {
+			if count {
+				c.stats.Hits++
+			}
+			set[i].lastUsed = c.counter
+			if write && writeBack {
+				set[i].dirty = true
+			} else if write && c.next != nil {
+				c.next.access(addr, true, count)
+			}
+			return
+		}
+	}
+
+	if count {
+		c.stats.Misses++
+	}
+	if write && !writeBack {
+		// no write allocate
+		if c.next != nil {
+			c.next.access(addr, true, count)
+		}
+		return
+	}
+
+	victim := &set[c.victim(set)]
+	if victim.valid {
+		if count {
+			c.stats.Evictions++
+		}
+		if victim.dirty {
+			if count {
+				c.stats.WriteBacks++
+			}
+			if c.next != nil {
+				c.next.access(victim.tag<<c.lineShift, true, count)
+			}
+		}
+	}
+
+	if c.next != nil {
+		c.next.access(addr, false, count)
+	}
+	*victim = cacheLine{tag: tag, valid: true, dirty: write, lastUsed: c.counter, filled: c.counter}
+}
+
+// Returns the index of the line in the set to replace, empty lines are used first
+func (c *cache) victim(set []cacheLine) int {
+	for i := range set {
+		if !set[i].valid {
+			return i
+		}
+	}
+
+	switch c.config.Replacement {
+	case CacheFIFO:
+		oldest := 0
+		for i := range set {
+			if set[i].filled < set[oldest].filled {
+				oldest = i
+			}
+		}
+		return oldest
+	case CacheRandom:
+		c.random ^= c.random << 13
+		c.random ^= c.random >> 17
+		c.random ^= c.random << 5
+		return int(c.random % uint32(len(set)))
+	}
+
+	leastRecent := 0
+	for i := range set {
+		if set[i].lastUsed < set[leastRecent].lastUsed {
+			leastRecent = i
+		}
+	}
+	return leastRecent
+}
+
+// Simulates fetching the instruction at the pc, called once per instruction since the decode cache skips
+// memReadRaw for most fetches
+func (h *cacheHierarchy) fetch(inst *EmulatorInstance, length uint32) {
+	if inst.pc >= 0x80000000 {
+		return
+	}
+
+	count := inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd
+	h.l1i.access(inst.pc, false, count)
+	if last := inst.pc + length - 1; last>>h.l1i.lineShift != inst.pc>>h.l1i.lineShift {
+		h.l1i.access(last, false, count)
+	}
+}
+
+// Simulates a load or store by the running instruction
+func (h *cacheHierarchy) data(inst *EmulatorInstance, addr uint32, write bool) {
+	count := inst.executing && (inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd)
+	h.l1d.access(addr, write, count)
+}
+
+func (h *cacheHierarchy) report() *CacheReport {
+	report := &CacheReport{L1I: h.l1i.stats, L1D: h.l1d.stats}
+	if h.l2 != nil {
+		stats := h.l2.stats
+		report.L2 = &stats
+	}
+	return report
+}
+
+// Adds the statistics to the stats sent to the debugger, e.g. l1dMisses
+func (r *CacheReport) addStats(stats map[string]interface{}) {
+	add := func(name string, s CacheStats) {
+		stats[name+"Hits"] = int(s.Hits)
+		stats[name+"Misses"] = int(s.Misses)
+		stats[name+"Evictions"] = int(s.Evictions)
+		stats[name+"WriteBacks"] = int(s.WriteBacks)
+	}
+	add("l1i", r.L1I)
+	add("l1d", r.L1D)
+	if r.L2 != nil {
+		add("l2", *r.L2)
+	}
+}
+
+func (s CacheStats) String() string {
+	accesses := s.Hits + s.Misses
+	missRate := 0.0
+	if accesses != 0 {
+		missRate = float64(s.Misses) / float64(accesses) * 100
+	}
+	return fmt.Sprintf("%d hits, %d misses (%.2f%% miss rate), %d evictions, %d write backs", s.Hits, s.Misses, missRate, s.Evictions, s.WriteBacks)
+}
+
+func (r *CacheReport) String() string {
+	str := fmt.Sprintf("L1I: %s\nL1D: %s", r.L1I, r.L1D)
+	if r.L2 != nil {
+		str += fmt.Sprintf("\nL2: %s", *r.L2)
+	}
+	return str
+}
+
+// Returns the hits, misses, evictions, and write backs of each cache, or nil when caches are not simulated
+func (inst *EmulatorInstance) GetCacheStats() *CacheReport {
+	if inst.caches == nil {
+		return nil
+	}
+	return inst.caches.report()
+}
@@ -0,0 +1,58 @@
+package emulator
+
+import "github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+
+// RV32C support. Instructions only need to be 2-byte aligned, and 32-bit instructions can start in the middle
+// of a word (or even a page), so they are fetched a halfword at a time. The length of the current instruction
+// is kept in instructionLength, which the emulate loop adds to the pc, and which jumps subtract from their
+// target the same way they used to subtract 4.
+
+// Fetches the instruction at the pc and sets instructionLength, compressed instructions are returned in the
+// low 16 bits
+func (inst *EmulatorInstance) fetchInstruction() uint32 {
+	inst.instructionLength = 4
+	if inst.pc&0x1 != 0 {
+		inst.newMemoryAccessNotAlignedException(inst.pc, "instruction")
+		return 0
+	}
+
+	if inst.pc&0x2 == 0 {
+		instruction := inst.memReadRaw(inst.pc, 0xFFFFFFFF, true)
+		if assembler.IsCompressedInstruction(instruction) {
+			inst.instructionLength = 2
+			return instruction & 0xFFFF
+		}
+		return instruction
+	}
+
+	low := inst.memReadRaw(inst.pc, 0xFFFF0000, true)
+	if assembler.IsCompressedInstruction(low) {
+		inst.instructionLength = 2
+		return low
+	}
+	return low | inst.memReadRaw(inst.pc+2, 0xFFFF, true)<<16
+}
+
+// Returns the word of memory at addr without raising exceptions
+func (inst *EmulatorInstance) peekWord(addr uint32) uint32 {
+	page, ok := inst.memory.Blocks[addr>>12]
+	if !ok {
+		return 0
+	}
+	return page.Block[(addr&0xFFF)>>2]
+}
+
+// Returns the instruction at addr expanded to 32 bits and its length without raising exceptions, used by
+// the debugger to find the address after a call
+func (inst *EmulatorInstance) instructionAt(addr uint32) (uint32, uint32) {
+	instruction := inst.peekWord(addr&^0x3) >> ((addr & 0x2) * 8)
+	if assembler.IsCompressedInstruction(instruction) {
+		expanded, _ := assembler.ExpandCompressedInstruction(instruction)
+		return expanded, 2
+	}
+
+	if addr&0x2 != 0 {
+		instruction |= inst.peekWord(addr+2) << 16
+	}
+	return instruction, 4
+}
@@ -1,11 +1,16 @@
 package emulator
 
-import "time"
+import (
+	"math/rand"
+	"time"
+)
 
 func (inst *EmulatorInstance) ResetRegisters(config EmulatorConfig) {
 	for i := 0; i < 32; i++ {
 		inst.registers[i] = 0
+		inst.fregisters[i] = 0
 	}
+	inst.fregInit = 0
 
 	inst.registers[1] = 0x20352035 // 0x20352035 is the magic number for the RISC-V emulator to know when to end
 	inst.registers[2] = config.StackStartAddress
@@ -13,7 +18,8 @@ func (inst *EmulatorInstance) ResetRegisters(config EmulatorConfig) {
 	inst.registers[8] = config.StackStartAddress // frame pointer
 	inst.callStack = []uint32{}
 	inst.regInit = 0x10F
-	inst.lastUsedRegisters = map[int]int{}
+	inst.lastUsedRegisters = 0
+	inst.resetHarts()
 }
 
 func NewEmulator(config EmulatorConfig) *EmulatorInstance {
@@ -31,11 +37,29 @@ func NewEmulator(config EmulatorConfig) *EmulatorInstance {
 		randomSeed = uint32(time.Now().Unix())
 	}
 
-	return &EmulatorInstance{
-		registers:               regs,
+	var fs *VirtualFileSystem // the filesystem ecalls are left to the OS without one
+	if config.FileSystem != nil {
+		fs = config.FileSystem.Clone()
+	}
+
+	numHarts := config.Harts
+	if numHarts < 1 {
+		numHarts = 1
+	}
+
+	inst := &EmulatorInstance{
+		hartContext: hartContext{
+			running:   true,
+			registers: regs,
+			pc:        0,
+			regInit:   0x10F,
+		},
+		harts:                   make([]hartContext, numHarts),
+		reservations:            make([]uint32, numHarts),
+		scheduler:               rand.New(rand.NewSource(int64(randomSeed))),
 		memory:                  config.Memory,
-		pc:                      0,
-		regInit:                 0x10F,
+		decodedPages:            map[uint32]*decodedPage{},
+		decodeCacheDisabled:     config.DisableDecodeCache,
 		iCache:                  nil,
 		runtimeLimit:            config.RuntimeLimit,
 		dCache:                  nil,
@@ -54,11 +78,26 @@ func NewEmulator(config EmulatorConfig) *EmulatorInstance {
 		breakAddr:               0xFFFFFFFF,
 		interrupt:               nil,
 		display:                 &VirtualDisplay{},
+		keyboard:                &VirtualKeyboard{},
+		mouse:                   &VirtualMouse{},
+		fs:                      fs,
+		trapMode:                config.TrapMode,
 		breakNext:               false,
 		stdOutCallback:          config.StdOutCallback,
 		runtimeErrorCallback:    config.RuntimeErrorCallback,
-		lastUsedRegisters:       map[int]int{},
 	}
+	if config.Caches != nil {
+		inst.caches = newCacheHierarchy(*config.Caches, randomSeed)
+	}
+	if config.Pipeline != nil {
+		inst.pipeline = newPipelineModel(*config.Pipeline)
+	}
+	if config.BranchPredictor != nil {
+		inst.predictor = newBranchPredictor(*config.BranchPredictor)
+	}
+	inst.attachBuiltinPeripherals()
+	inst.resetHarts()
+	return inst
 }
 
 func NewMemoryImage() *MemoryImage {
@@ -70,10 +109,20 @@ func (m *MemoryImage) getOrCreatePage(addr uint32) *MemoryPage {
 	if !ok {
 		page = &MemoryPage{Block: [1024]uint32{}, StartAddr: addr & 0xFFFFF000}
 		m.Blocks[addr>>12] = page
+	} else if page.shared.Load() {
+		page = m.unsharePage(addr >> 12)
 	}
 	return page
 }
 
+// Replaces a shared page with a private copy that can be written, the other images keep the original
+func (m *MemoryImage) unsharePage(pageNum uint32) *MemoryPage {
+	page := m.Blocks[pageNum]
+	newPage := &MemoryPage{Block: page.Block, Initialized: page.Initialized, StartAddr: page.StartAddr}
+	m.Blocks[pageNum] = newPage
+	return newPage
+}
+
 func (m *MemoryImage) WriteWord(addr uint32, value uint32) {
 	page := m.getOrCreatePage(addr)
 	page.Block[(addr&0xFFF)>>2] = value
@@ -110,13 +159,13 @@ func (m *MemoryImage) ReadHalfWord(addr uint32) (uint16, bool) {
 	return uint16((page.Block[(addr&0xFFF)>>2] >> ((addr & 0x3) * 8)) & 0xFFFF), page.Initialized[(addr&0xFFF)>>2]
 }
 
+// Copy-on-write clone, the pages are shared until either image writes to them. Clones of the same image can
+// be made and used from different goroutines since shared pages are never written.
 func (m *MemoryImage) Clone() *MemoryImage {
-	newMem := NewMemoryImage()
-	for k, v := range m.Blocks {
-		newPage := &MemoryPage{Block: [1024]uint32{}, Initialized: [1024]bool{}, StartAddr: v.StartAddr}
-		copy(newPage.Block[:], v.Block[:])
-		copy(newPage.Initialized[:], v.Initialized[:])
-		newMem.Blocks[k] = newPage
+	newMem := &MemoryImage{Blocks: make(map[uint32]*MemoryPage, len(m.Blocks))}
+	for k, page := range m.Blocks {
+		page.shared.Store(true)
+		newMem.Blocks[k] = page
 	}
 	return newMem
 }
@@ -137,6 +186,10 @@ func (inst *EmulatorInstance) GetDisplay() *VirtualDisplay {
 	return inst.display
 }
 
+func (inst *EmulatorInstance) GetFileSystem() *VirtualFileSystem {
+	return inst.fs
+}
+
 func (inst *EmulatorInstance) GetErrors() []RuntimeException {
 	return inst.errors
 }
@@ -0,0 +1,129 @@
+package emulator
+
+import "github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+
+// Zicsr support. The counters are all derived from the number of executed instructions since every instruction
+// takes a single cycle in the emulator. fflags and frm are views of fcsr, all other CSRs are plain storage.
+
+const (
+	csrCycle     = 0xC00
+	csrTime      = 0xC01
+	csrInstret   = 0xC02
+	csrCycleH    = 0xC80
+	csrTimeH     = 0xC81
+	csrInstretH  = 0xC82
+	csrMcycle    = 0xB00
+	csrMinstret  = 0xB02
+	csrMcycleH   = 0xB80
+	csrMinstretH = 0xB82
+	csrMisa      = 0x301
+	csrMvendorid = 0xF11
+	csrMarchid   = 0xF12
+	csrMimpid    = 0xF13
+	csrMhartid   = 0xF14
+	csrFflags    = 0x001
+	csrFrm       = 0x002
+	csrFcsr      = 0x003
+)
+
+const misaRV32IMAFC = 0x40001125 // MXL=1, I, M, A, F, and C
+
+func (inst *EmulatorInstance) csrRead(csr uint32) uint32 {
+	switch csr {
+	case csrCycle, csrMcycle:
+		return uint32(inst.executedInstructions + inst.cycleOffset)
+	case csrCycleH, csrMcycleH:
+		return uint32((inst.executedInstructions + inst.cycleOffset) >> 32)
+	case csrInstret, csrMinstret:
+		return uint32(inst.executedInstructions + inst.instretOffset)
+	case csrInstretH, csrMinstretH:
+		return uint32((inst.executedInstructions + inst.instretOffset) >> 32)
+	case csrTime:
+		return uint32(inst.executedInstructions)
+	case csrTimeH:
+		return uint32(inst.executedInstructions >> 32)
+	case csrMisa:
+		return misaRV32IMAFC
+	case csrFflags:
+		return inst.fflags
+	case csrFrm:
+		return inst.frm
+	case csrFcsr:
+		return inst.frm<<5 | inst.fflags
+	case csrMhartid:
+		return inst.hartID
+	case csrMvendorid, csrMarchid, csrMimpid:
+		return 0
+	}
+
+	return inst.csrs[csr]
+}
+
+func (inst *EmulatorInstance) csrWrite(csr uint32, value uint32) {
+	// the counters keep counting from the written value
+	switch csr {
+	case csrMcycle:
+		counter := inst.executedInstructions + inst.cycleOffset
+		inst.cycleOffset = (counter&^0xFFFFFFFF | uint64(value)) - inst.executedInstructions
+	case csrMcycleH:
+		counter := inst.executedInstructions + inst.cycleOffset
+		inst.cycleOffset = (counter&0xFFFFFFFF | uint64(value)<<32) - inst.executedInstructions
+	case csrMinstret:
+		counter := inst.executedInstructions + inst.instretOffset
+		inst.instretOffset = (counter&^0xFFFFFFFF | uint64(value)) - inst.executedInstructions
+	case csrMinstretH:
+		counter := inst.executedInstructions + inst.instretOffset
+		inst.instretOffset = (counter&0xFFFFFFFF | uint64(value)<<32) - inst.executedInstructions
+	case csrFflags:
+		inst.fflags = value & 0x1F
+	case csrFrm:
+		inst.frm = value & 0x7
+	case csrFcsr:
+		inst.fflags = value & 0x1F
+		inst.frm = (value >> 5) & 0x7
+	case csrMisa:
+		// WARL, the extensions cannot be changed
+	default:
+		if inst.csrs == nil {
+			inst.csrs = make(map[uint32]uint32)
+		}
+		inst.csrs[csr] = value
+	}
+}
+
+func (inst *EmulatorInstance) executeCSR(instruction uint32) {
+	_, rd, rs1, csr, func3 := assembler.DecodeITypeInstruction(instruction)
+
+	// the immediate variants encode a 5-bit unsigned value in the rs1 field
+	source := rs1
+	if func3&0b100 == 0 {
+		source = inst.regRead(rs1)
+	}
+
+	// csrrw does not read the CSR when rd is x0, and csrrs/csrrc do not write it when the source is x0/zero
+	isWrite := func3&0b011 == 0b001 || rs1 != 0
+	if isWrite && csr>>10 == 0b11 {
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Illegal write to read-only CSR 0x%03X", csr)
+		return
+	}
+
+	old := uint32(0)
+	if func3&0b011 != 0b001 || rd != 0 {
+		old = inst.csrRead(csr)
+	}
+
+	if isWrite {
+		switch func3 & 0b011 {
+		case 0b001:
+			inst.csrWrite(csr, source)
+		case 0b010:
+			inst.csrWrite(csr, old|source)
+		case 0b011:
+			inst.csrWrite(csr, old&^source)
+		}
+	}
+
+	if rd != 0 {
+		inst.regWrite(rd, old)
+	}
+}
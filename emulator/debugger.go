@@ -7,12 +7,13 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime/debug"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,6 +36,7 @@ type CapabilitiesResponse struct {
 	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
 	SupportsRestartRequest           bool `json:"supportsRestartRequest"`
 	SupportsDataBreakpoints          bool `json:"supportsDataBreakpoints"`
+	SupportsStepBack                 bool `json:"supportsStepBack"`
 }
 
 type Request struct {
@@ -52,18 +54,36 @@ type ExitEventBody struct {
 }
 
 type StoppedEventBody struct {
-	Reason        string `json:"reason"`
-	Description   string `json:"description"`
-	Text          string `json:"text"`
-	BreakpointIDs []int  `json:"hitBreakpointIds"`
-	ThreadID      int    `json:"threadId"`
+	Reason            string `json:"reason"`
+	Description       string `json:"description"`
+	Text              string `json:"text"`
+	BreakpointIDs     []int  `json:"hitBreakpointIds"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+// The arguments of the launch and restart requests, set in the launch configuration
+type LaunchArguments struct {
+	Program          string                 `json:"program"`
+	Assignment       string                 `json:"assignment"`
+	FileSystem       string                 `json:"filesystem"`
+	Snapshot         string                 `json:"snapshot"`
+	TrapMode         bool                   `json:"trapMode"`
+	ReverseDebugging bool                   `json:"reverseDebugging"`
+	Harts            int                    `json:"harts"`
+	Seed             uint32                 `json:"seed"`
+	Caches           *CacheHierarchyConfig  `json:"caches"`
+	Pipeline         *PipelineConfig        `json:"pipeline"`
+	BranchPredictor  *BranchPredictorConfig `json:"branchPredictor"`
 }
 
 var liveEmulator *EmulatorInstance
 var liveAssembledResult *assembler.AssembledResult
 var assembledFilePath string
 var continueChan chan bool
+var pausedWork chan func() // set while the emulator is paused on a breakpoint or step, see runWhilePaused
 var assemblyEntry uint32 = 0
+var resumeSnapshot bool // the live emulator was restored from a snapshot and resumes instead of starting at assemblyEntry
 var stdOutBuilder strings.Builder
 
 func RunDebugServer() {
@@ -125,13 +145,17 @@ func dispatchCommand(command string, data json.RawMessage, seq int) {
 	case "stackTrace":
 		handleGetStacktrace(data, seq)
 	case "next":
-		handleStepOver(seq)
+		handleStepOver(data, seq)
 	case "stepIn":
-		handleStepIn(seq)
+		handleStepIn(data, seq)
 	case "stepOut":
-		handleStepOut(seq)
+		handleStepOut(data, seq)
 	case "continue":
 		handleContinue(seq)
+	case "stepBack":
+		handleStepBack(seq)
+	case "reverseContinue":
+		handleReverseContinue(seq)
 	case "scopes":
 		handleGetScopes(data, seq)
 	case "variables":
@@ -146,6 +170,14 @@ func dispatchCommand(command string, data json.RawMessage, seq int) {
 		handleSetDataBreakpoints(data, seq)
 	case "evaluate":
 		handleEvaluate(data, seq)
+	case "riscv_keyboard":
+		handleKeyboardInput(data, seq)
+	case "riscv_mouse":
+		handleMouseInput(data, seq)
+	case "riscv_saveSnapshot":
+		handleSaveSnapshot(data, seq)
+	case "riscv_saveScreenshot":
+		handleSaveScreenshot(data, seq)
 	case "terminate":
 		handleTerminate(data, seq)
 	case "disconnect":
@@ -163,47 +195,37 @@ func handleInitialize(data json.RawMessage, seq int) {
 		SupportsConfigurationDoneRequest: true,
 		SupportsRestartRequest:           true,
 		SupportsDataBreakpoints:          true,
+		SupportsStepBack:                 true,
 	}
 
 	sendResponse("initialize", seq, true, capabilities)
 }
 
 func handleLaunch(data json.RawMessage, seq int) {
-	var launchInfo map[string]interface{}
-	json.Unmarshal(data, &launchInfo)
+	var launchArgs LaunchArguments
+	json.Unmarshal(data, &launchArgs)
 
 	sendOutput("Launching RISC-V Emulator with Debugging Enabled", true)
 
-	randomSeed := uint32(0)
-	if launchInfo["seed"] != nil {
-		randomSeed = uint32(launchInfo["seed"].(float64))
-	}
-
-	assignmentPath, _ := launchInfo["assignment"].(string)
-	initDebugger(launchInfo["program"].(string), assignmentPath, seq, randomSeed)
+	initDebugger(launchArgs, seq)
 
 	sendResponse("launch", seq, true, EmptyResponse{})
 }
 
 func handleRestart(data json.RawMessage, seq int) {
 	restartRequest := struct {
-		Arguments map[string]interface{} `json:"arguments"`
+		Arguments LaunchArguments `json:"arguments"`
 	}{}
+	restartRequest.Arguments.Seed = liveEmulator.randomSeed // preserving the seed unless the restart sets one
 
 	json.Unmarshal(data, &restartRequest)
 
 	sendOutput("Restarting RISC-V Emulator", true)
-	randomSeed := liveEmulator.randomSeed // preserving the seed
 	liveEmulator.terminated = true
 	continueChan <- true
 	time.Sleep(10 * time.Millisecond) // to let the other instance terminate gracefully
 
-	if restartRequest.Arguments["seed"] != nil {
-		randomSeed = uint32(restartRequest.Arguments["seed"].(float64))
-	}
-
-	assignmentPath, _ := restartRequest.Arguments["assignment"].(string)
-	initDebugger(restartRequest.Arguments["program"].(string), assignmentPath, seq, randomSeed)
+	initDebugger(restartRequest.Arguments, seq)
 
 	sendResponse("restart", seq, true, EmptyResponse{})
 }
@@ -224,7 +246,7 @@ func handleTerminate(data json.RawMessage, seq int) {
 	sendResponse("terminate", seq, true, EmptyResponse{})
 }
 
-func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSeed uint32) {
+func initDebugger(args LaunchArguments, seq int) {
 	// as part of launching, we need to:
 	// load assembly file
 	// assemble assembly file
@@ -237,10 +259,10 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 	// send output to client
 
 	// load assembly file
-	fName := assemblyPath
+	fName := args.Program
 	assembledFilePath = fName
-	assignmentFName, hasAssignment := assignmentPath, assignmentPath != ""
-	if fName[strings.LastIndex(fName, "."):] != ".asm" {
+	assignmentFName, hasAssignment := args.Assignment, args.Assignment != ""
+	if filepath.Ext(fName) != ".asm" {
 		sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
 			ID:       100,
 			Format:   "Invalid File Provided, expected *.asm",
@@ -375,6 +397,39 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 		memoryImage.WriteWord(assemblyGlobalPointer+uint32(i*4), v)
 	}
 
+	// mount the filesystem, either a host directory or a FAT image
+	var fs *VirtualFileSystem
+	if args.FileSystem != "" {
+		fs, e = MountFileSystem(args.FileSystem)
+		if e != nil {
+			sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
+				ID:     108,
+				Format: "Could not mount filesystem: " + e.Error(),
+			}})
+			return
+		}
+	}
+
+	if args.Caches != nil {
+		if e := args.Caches.Validate(); e != nil {
+			sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
+				ID:     112,
+				Format: "Invalid cache configuration: " + e.Error(),
+			}})
+			return
+		}
+	}
+
+	if args.BranchPredictor != nil {
+		if e := args.BranchPredictor.Validate(); e != nil {
+			sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
+				ID:     113,
+				Format: "Invalid branch predictor: " + e.Error(),
+			}})
+			return
+		}
+	}
+
 	// configure emulator
 	config := EmulatorConfig{
 		StackStartAddress:       0x7FFFFFF0,
@@ -384,14 +439,21 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 		Memory:                  memoryImage,
 		ProfileIgnoreRangeStart: 0xFFFFFFFF,
 		ProfileIgnoreRangeEnd:   0xFFFFFFFF,
-		RandomSeed:              randomSeed,
+		RandomSeed:              args.Seed,
+		FileSystem:              fs,
+		TrapMode:                args.TrapMode,
+		Harts:                   args.Harts,
+		Caches:                  args.Caches,
+		Pipeline:                args.Pipeline,
+		BranchPredictor:         args.BranchPredictor,
 		RuntimeErrorCallback: func(e RuntimeException) {
 			sendEvent("stopped", StoppedEventBody{
-				Reason:        "exception",
-				Description:   e.message,
-				Text:          e.message + "\n" + assembleRes.PrettyPrintStacktrace(e.callStack),
-				BreakpointIDs: []int{},
-				ThreadID:      1,
+				Reason:            "exception",
+				Description:       e.message,
+				Text:              e.message + "\n" + assembleRes.PrettyPrintStacktrace(e.callStack),
+				BreakpointIDs:     []int{},
+				ThreadID:          int(e.hart) + 1,
+				AllThreadsStopped: true,
 			})
 
 			continueChan = make(chan bool)
@@ -416,12 +478,17 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 	}
 
 	emulator := NewEmulator(config)
+	if args.ReverseDebugging {
+		// the journal slows down emulation and keeps a checkpoint every few thousand instructions
+		emulator.enableJournal()
+	}
 	emulator.breakCallback = func(inst *EmulatorInstance, breakpointID int, reason string) {
 		eventBody := StoppedEventBody{
-			Reason:        reason,
-			ThreadID:      1,
-			Description:   "Paused on breakpoint.",
-			BreakpointIDs: []int{breakpointID},
+			Reason:            reason,
+			ThreadID:          int(inst.hartID) + 1,
+			Description:       "Paused on breakpoint.",
+			BreakpointIDs:     []int{breakpointID},
+			AllThreadsStopped: true,
 		}
 
 		if breakpointID == 0 {
@@ -432,11 +499,38 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 
 		sendEvent("stopped", eventBody)
 		continueChan = make(chan bool)
-		<-continueChan
+		waitWhilePaused()
 	}
 
-	// start emulator - only if there is an assignment because we need to wait for the configuration to complete before assembly code can be run
-	if hasAssignment {
+	// restoring a checkpoint replaces the assignment setup, the snapshot has to be from the same program
+	resumeSnapshot = false
+	if args.Snapshot != "" {
+		snapshot, e := LoadSnapshotFile(args.Snapshot)
+		if e != nil {
+			sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
+				ID:     109,
+				Format: "Could not load snapshot: " + e.Error(),
+			}})
+			return
+		}
+
+		e = snapshot.matchesCode(memoryImage, assemblyEntry, assemblyEntry+uint32(len(assembleRes.ProgramText)*4))
+		if e == nil && hasAssignment {
+			e = snapshot.matchesCode(memoryImage, osCodeStart, osCodeEnd)
+		}
+		if e != nil {
+			sendResponse("launch", seq, false, ErrorBody{Error: ErrorMessage{
+				ID:     109,
+				Format: "Could not load snapshot: " + e.Error(),
+			}})
+			return
+		}
+
+		emulator.Restore(snapshot)
+		resumeSnapshot = true
+		sendOutput("Resuming from snapshot "+filepath.Base(args.Snapshot), true)
+	} else if hasAssignment {
+		// start emulator - only if there is an assignment because we need to wait for the configuration to complete before assembly code can be run
 		emulator.Emulate(startAddr)
 		config.GlobalDataAddress = assemblyGlobalPointer
 		emulator.ResetRegisters(config)
@@ -446,11 +540,49 @@ func initDebugger(assemblyPath string, assignmentPath string, seq int, randomSee
 	sendEvent("initialized", EmptyResponse{})
 }
 
+// Runs the work requests hand to the emulator until the debugger continues, the emulator's state can only be
+// read safely from its own goroutine
+func waitWhilePaused() {
+	pausedWork = make(chan func())
+	defer func() { pausedWork = nil }()
+
+	for {
+		select {
+		case <-continueChan:
+			return
+		case work := <-pausedWork:
+			work()
+		}
+	}
+}
+
+var errNotPaused = errors.New("the emulator has to be paused on a breakpoint or step")
+
+// Runs the function on the emulator's goroutine while it is paused on a breakpoint or step, returns false
+// without running it if the emulator is not paused there
+func runWhilePaused(f func()) bool {
+	if pausedWork == nil {
+		return false
+	}
+
+	done := make(chan bool)
+	pausedWork <- func() {
+		f()
+		done <- true
+	}
+	<-done
+	return true
+}
+
 func handleConfigDone(data json.RawMessage, seq int) {
 	emulateFunc := func() {
 		sendOutput("Emulation Started", true)
 		emulator := liveEmulator
-		emulator.Emulate(assemblyEntry) // pc will be set by the launch code above
+		if resumeSnapshot {
+			emulator.Resume()
+		} else {
+			emulator.Emulate(assemblyEntry) // pc will be set by the launch code above
+		}
 
 		// sending seed
 		sendEvent("riscv_context", map[string]interface{}{
@@ -463,6 +595,15 @@ func handleConfigDone(data json.RawMessage, seq int) {
 		}
 
 		sendOutput(fmt.Sprintf("Emulation Completed.\nDI = %d, SI=%d, Register Usage = %d, Memory Usage = %d", emulator.di, len(liveAssembledResult.ProgramText), emulator.regUsage, int(emulator.memUsage)+len(liveAssembledResult.ProgramData)), true)
+		if report := emulator.GetCacheStats(); report != nil {
+			sendOutput(report.String(), true)
+		}
+		if report := emulator.GetPipelineStats(nil, 0); report != nil {
+			sendOutput(report.String(), true)
+		}
+		if report := emulator.GetBranchStats(nil, 0); report != nil {
+			sendOutput(report.String(), true)
+		}
 		sendEvent("exited", ExitEventBody{
 			ExitCode: 0,
 		})
@@ -479,17 +620,45 @@ func handleGetThreads(data json.RawMessage, seq int) {
 		Name string `json:"name"`
 	}
 
+	// every hart is a thread, the thread ID is the hart ID plus one since DAP thread IDs start at 1
+	threads := []Thread{{ID: 1, Name: "Main"}}
+	if liveEmulator != nil && len(liveEmulator.harts) > 1 {
+		threads = []Thread{}
+		for i := range liveEmulator.harts {
+			name := fmt.Sprintf("Hart %d", i)
+			if !liveEmulator.getHart(uint32(i)).running {
+				name += " (parked)"
+			}
+			threads = append(threads, Thread{ID: i + 1, Name: name})
+		}
+	}
+
 	threadsRespBody := struct {
 		Threads []Thread `json:"threads"`
-	}{Threads: []Thread{{
-		ID:   1,
-		Name: "Main",
-	}}}
+	}{Threads: threads}
 	sendResponse("threads", seq, true, threadsRespBody)
 }
 
-func handleStepOver(seq int) {
-	instruction := liveEmulator.memReadWord(liveEmulator.pc, true)
+// Returns the hart of the thread a request is for, the running hart if the thread is not a running hart
+func requestedHart(data json.RawMessage) *hartContext {
+	request := struct {
+		ThreadID int `json:"threadId"`
+	}{}
+	json.Unmarshal(data, &request)
+
+	// threads are harts, see handleGetThreads
+	if request.ThreadID > 0 && request.ThreadID <= len(liveEmulator.harts) {
+		if hart := liveEmulator.getHart(uint32(request.ThreadID - 1)); hart.running {
+			return hart
+		}
+	}
+	return &liveEmulator.hartContext
+}
+
+func handleStepOver(data json.RawMessage, seq int) {
+	hart := requestedHart(data)
+	liveEmulator.breakHart = hart.hartID
+	instruction, length := liveEmulator.instructionAt(hart.pc)
 
 	// decoding instruction
 	opcode := assembler.GetOpCode(instruction)
@@ -501,13 +670,13 @@ func handleStepOver(seq int) {
 		if rd == 0 {
 			liveEmulator.breakNext = true
 		} else {
-			liveEmulator.breakAddr = liveEmulator.pc + 4
+			liveEmulator.breakAddr = hart.pc + length
 		}
 
 		break
 
 	case assembler.OPCODE_JALR:
-		liveEmulator.breakAddr = liveEmulator.pc + 4
+		liveEmulator.breakAddr = hart.pc + length
 	default:
 		liveEmulator.breakNext = true
 	}
@@ -519,7 +688,8 @@ func handleStepOver(seq int) {
 	sendResponse("next", seq, true, EmptyResponse{})
 }
 
-func handleStepIn(seq int) {
+func handleStepIn(data json.RawMessage, seq int) {
+	liveEmulator.breakHart = requestedHart(data).hartID
 	liveEmulator.breakNext = true
 	if continueChan != nil {
 		continueChan <- true
@@ -527,12 +697,16 @@ func handleStepIn(seq int) {
 	sendResponse("stepIn", seq, true, EmptyResponse{})
 }
 
-func handleStepOut(seq int) {
-	if len(liveEmulator.callStack) < 1 {
+func handleStepOut(data json.RawMessage, seq int) {
+	hart := requestedHart(data)
+	liveEmulator.breakHart = hart.hartID
+	if len(hart.callStack) < 1 {
 		sendOutput("Not currently in a function call; cannot Step Out.", true)
 	} else {
 		// get line of last callstack frame
-		liveEmulator.breakAddr = liveEmulator.callStack[len(liveEmulator.callStack)-1] + 4
+		callAddr := hart.callStack[len(hart.callStack)-1]
+		_, length := liveEmulator.instructionAt(callAddr)
+		liveEmulator.breakAddr = callAddr + length
 		if continueChan != nil {
 			continueChan <- true
 		}
@@ -548,13 +722,73 @@ func handleContinue(seq int) {
 }
 
 var stackFrameIDCounter = 0
+var frameHarts = map[int]uint32{} // the hart of every stack frame sent, for the scopes of the frame
+
+// Variables references of the register scopes. The references of hart n are offset by n*hartVariablesStride,
+// and register n is its hart's offset plus n, so the registers of every thread can be expanded and 32 harts
+// stay below the references of memory, which start at 2035.
+const (
+	hartVariablesStride     = 64
+	registersReference      = 32
+	floatRegistersReference = 33
+)
+
+// Stepping back and reverse continue go back in the execution journal, see journal.go
+func handleStepBack(seq int) {
+	var e error
+	if !runWhilePaused(func() { e = liveEmulator.stepBack() }) {
+		e = errNotPaused
+	}
+	if e != nil {
+		sendResponse("stepBack", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     111,
+			Format: "Cannot step back: " + e.Error(),
+		}})
+		return
+	}
+
+	if continueChan != nil {
+		continueChan <- true
+	}
+	sendResponse("stepBack", seq, true, EmptyResponse{})
+}
+
+func handleReverseContinue(seq int) {
+	var e error
+	if !runWhilePaused(func() { e = liveEmulator.reverseContinue() }) {
+		e = errNotPaused
+	}
+	if e != nil {
+		sendResponse("reverseContinue", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     115,
+			Format: "Cannot reverse continue: " + e.Error(),
+		}})
+		return
+	}
+
+	if continueChan != nil {
+		continueChan <- true
+	}
+	sendResponse("reverseContinue", seq, true, EmptyResponse{})
+}
 
 func handleGetStacktrace(data json.RawMessage, seq int) {
 	// called whenever the debugger stops
 	// so can send over misc. updates
 	sendScreenUpdates()
 
-	trace := liveEmulator.callStack
+	request := struct {
+		ThreadID int `json:"threadId"`
+	}{}
+	json.Unmarshal(data, &request)
+
+	// threads are harts, see handleGetThreads
+	hart := &liveEmulator.hartContext
+	if request.ThreadID > 0 && request.ThreadID <= len(liveEmulator.harts) {
+		hart = liveEmulator.getHart(uint32(request.ThreadID - 1))
+	}
+
+	trace := hart.callStack
 
 	// building the stack frames
 	stackFrames := make([]StackFrame, len(trace)+1)
@@ -567,18 +801,20 @@ func handleGetStacktrace(data json.RawMessage, seq int) {
 			Path: assembledFilePath,
 		}
 		stackFrames[len(trace)-i].addr = v
+		frameHarts[stackFrameIDCounter] = hart.hartID
 		stackFrameIDCounter++
 	}
 
 	// add the current instruction
 	stackFrames[0].ID = stackFrameIDCounter
-	stackFrames[0].Name = liveAssembledResult.GetTextLabelForAddress(liveEmulator.pc)
-	stackFrames[0].Line = liveAssembledResult.GetLineOfAddress(liveEmulator.pc, assemblyEntry)
+	stackFrames[0].Name = liveAssembledResult.GetTextLabelForAddress(hart.pc)
+	stackFrames[0].Line = liveAssembledResult.GetLineOfAddress(hart.pc, assemblyEntry)
 	stackFrames[0].Source = Source{
 		Name: liveAssembledResult.FileName,
 		Path: assembledFilePath,
 	}
-	stackFrames[len(stackFrames)-1].addr = liveEmulator.pc
+	stackFrames[len(stackFrames)-1].addr = hart.pc
+	frameHarts[stackFrameIDCounter] = hart.hartID
 	stackFrameIDCounter++
 
 	stackTrace := struct {
@@ -651,11 +887,18 @@ func handleGetScopes(data json.RawMessage, seq int) {
 
 	json.Unmarshal(data, &scopesRequest)
 
-	// for now only doing a register scope
+	// the registers are the ones of the hart the frame belongs to
+	offset := int(frameHarts[scopesRequest.FrameID]) * hartVariablesStride
+
+	// for now only doing register scopes
 	scopes := []Scope{{
 		Name:               "registers",
 		PresentationHint:   "registers",
-		VariablesReference: 32,
+		VariablesReference: offset + registersReference,
+	}, {
+		Name:               "float registers",
+		PresentationHint:   "registers",
+		VariablesReference: offset + floatRegistersReference,
 	}, {
 		Name:               "memory",
 		PresentationHint:   "memory",
@@ -728,32 +971,41 @@ func handleGetVariables(data json.RawMessage, seq int) {
 
 	json.Unmarshal(data, &variablesRequest)
 
+	// the hart is encoded in the reference, see hartVariablesStride
+	hartID := variablesRequest.VariablesReference / hartVariablesStride
+	reference := variablesRequest.VariablesReference % hartVariablesStride
+	if hartID >= len(liveEmulator.harts) {
+		hartID, reference = 0, -1
+	}
+	hart := liveEmulator.getHart(uint32(hartID))
+	offset := hartID * hartVariablesStride
+
 	var variables []Variable
-	if variablesRequest.VariablesReference == 32 {
-		// getting the list of all variables
-		regsMap := liveEmulator.lastUsedRegisters
-
-		// converting the regsMap to a slice of register numbers sorted from least to greatest
-		regs := make([]int, len(regsMap))
-		i := 0
-		//sendOutput("number used registers: "+strconv.Itoa(len(regsMap))+"\n", true)
-		for k := range regsMap {
-			regs[i] = k
-			i++
+	if reference == registersReference {
+		// getting the list of all variables, the ones the other harts initialized since only the running hart
+		// tracks the registers it used last
+		usedRegs := hart.regInit
+		if hart == &liveEmulator.hartContext {
+			usedRegs = liveEmulator.lastUsedRegisters
 		}
 
-		// sorting the regs slice
-		sort.Slice(regs, func(i, j int) bool {
-			return regs[i] < regs[j]
-		})
+		// converting the bitmap to a slice of register numbers sorted from least to greatest
+		regs := []int{}
+		for reg := 0; reg < 32; reg++ {
+			if usedRegs&(1<<reg) != 0 {
+				regs = append(regs, reg)
+			}
+		}
 
 		variables = make([]Variable, len(regs))
 
 		// building the variables that are returned to the client
 		for i, v := range regs {
 			attributes := []string{}
+			child := offset + v
 			if v == 0 {
 				attributes = append(attributes, "readOnly", "constant")
+				child = 0
 			}
 
 			friendlyName := ""
@@ -766,8 +1018,8 @@ func handleGetVariables(data json.RawMessage, seq int) {
 			variables[i] = Variable{
 				Name:               fmt.Sprintf("x%d (%s)", v, friendlyName),
 				EvaluateName:       fmt.Sprintf("x%d", v),
-				Value:              fmt.Sprintf("%d (0x%X)", int32(liveEmulator.registers[v]), liveEmulator.registers[v]),
-				VariablesReference: v,
+				Value:              fmt.Sprintf("%d (0x%X)", int32(hart.registers[v]), hart.registers[v]),
+				VariablesReference: child,
 				Type:               "int32_t",
 				PresentationHint: VariablePresentationHint{
 					Kind:       "data",
@@ -775,10 +1027,35 @@ func handleGetVariables(data json.RawMessage, seq int) {
 				},
 			}
 		}
-	} else if variablesRequest.VariablesReference < 32 {
+	} else if reference == floatRegistersReference {
+		// getting the list of initialized float registers, they are not expandable
+		variables = []Variable{}
+		for v := 0; v < 32; v++ {
+			if hart.fregInit&(1<<v) == 0 {
+				continue
+			}
+
+			friendlyName := ""
+			for fName, nName := range assembler.FloatRegisterNameMap {
+				if v == nName && (fName[1] < '0' || fName[1] > '9') {
+					friendlyName = fName
+					break
+				}
+			}
+			bits := hart.fregisters[v]
+			variables = append(variables, Variable{
+				Name:         fmt.Sprintf("f%d (%s)", v, friendlyName),
+				EvaluateName: fmt.Sprintf("f%d", v),
+				Value:        fmt.Sprintf("%g (0x%08X)", math.Float32frombits(bits), bits),
+				Type:         "float",
+				PresentationHint: VariablePresentationHint{
+					Kind: "data",
+				},
+			})
+		}
+	} else if reference >= 0 && reference < 32 {
 		// getting the list of variables for a specific register
-		regNum := variablesRequest.VariablesReference
-		regVal := liveEmulator.registers[regNum]
+		regVal := hart.registers[reference]
 
 		variables = []Variable{{
 			Name:  "Value",
@@ -818,8 +1095,10 @@ func handleDataBreakpointInfo(data json.RawMessage, seq int) {
 	}{}
 
 	response.DataId = strconv.Itoa(request.VariablesReference)
-	if request.VariablesReference < 32 {
-		response.Description = fmt.Sprintf("Watchpoint on register x%d", request.VariablesReference)
+	if reg := request.VariablesReference % hartVariablesStride; request.VariablesReference < 2035 && reg < 32 {
+		// register breakpoints break on a write by any hart, see hartVariablesStride for the references
+		response.DataId = strconv.Itoa(reg)
+		response.Description = fmt.Sprintf("Watchpoint on register x%d", reg)
 	} else if request.VariablesReference >= 2035 {
 		response.Description = fmt.Sprintf("Watchpoint on variable %s", request.Name)
 	} else {
@@ -899,6 +1178,116 @@ func handleEvaluate(data json.RawMessage, seq int) {
 	sendResponse("evaluate", seq, true, response)
 }
 
+// Custom request sent by the extension when a key is pressed or released in the virtual display.
+// Key codes follow the JavaScript KeyboardEvent.keyCode values.
+func handleKeyboardInput(data json.RawMessage, seq int) {
+	request := struct {
+		Key     uint32 `json:"key"`
+		Pressed bool   `json:"pressed"`
+	}{}
+
+	json.Unmarshal(data, &request)
+
+	if liveEmulator == nil {
+		sendResponse("riscv_keyboard", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     107,
+			Format: "The emulator is not running",
+		}})
+		return
+	}
+
+	liveEmulator.KeyEvent(request.Key, request.Pressed)
+	sendResponse("riscv_keyboard", seq, true, EmptyResponse{})
+}
+
+// Custom request sent by the extension when the mouse moves or is clicked in the virtual display.
+// Coordinates are in display pixels and buttons follow the JavaScript MouseEvent.buttons bitmask.
+func handleMouseInput(data json.RawMessage, seq int) {
+	request := struct {
+		X       int    `json:"x"`
+		Y       int    `json:"y"`
+		Buttons uint32 `json:"buttons"`
+	}{}
+
+	json.Unmarshal(data, &request)
+
+	if liveEmulator == nil {
+		sendResponse("riscv_mouse", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     107,
+			Format: "The emulator is not running",
+		}})
+		return
+	}
+
+	liveEmulator.MouseEvent(request.X, request.Y, request.Buttons)
+	sendResponse("riscv_mouse", seq, true, EmptyResponse{})
+}
+
+// Custom request sent by the extension to save a snapshot of the paused emulator, which can be attached to a
+// bug report or passed as the snapshot launch argument to restart from this point
+func handleSaveSnapshot(data json.RawMessage, seq int) {
+	request := struct {
+		Path string `json:"path"`
+	}{}
+
+	json.Unmarshal(data, &request)
+
+	if liveEmulator == nil {
+		sendResponse("riscv_saveSnapshot", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     107,
+			Format: "The emulator is not running",
+		}})
+		return
+	}
+
+	var snapshot *Snapshot
+	var e error
+	if !runWhilePaused(func() { snapshot, e = liveEmulator.Snapshot() }) {
+		e = errNotPaused
+	}
+	if e == nil {
+		e = snapshot.SaveFile(request.Path)
+	}
+	if e != nil {
+		sendResponse("riscv_saveSnapshot", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     110,
+			Format: "Could not save snapshot: " + e.Error(),
+		}})
+		return
+	}
+
+	sendOutput("Saved snapshot to "+request.Path, true)
+	sendResponse("riscv_saveSnapshot", seq, true, EmptyResponse{})
+}
+
+// Saves the frame on screen as a PNG, see screenshot.go
+func handleSaveScreenshot(data json.RawMessage, seq int) {
+	request := struct {
+		Path string `json:"path"`
+	}{}
+
+	json.Unmarshal(data, &request)
+
+	if liveEmulator == nil {
+		sendResponse("riscv_saveScreenshot", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     107,
+			Format: "The emulator is not running",
+		}})
+		return
+	}
+
+	if e := liveEmulator.display.SavePNG(request.Path); e != nil {
+		sendResponse("riscv_saveScreenshot", seq, false, ErrorBody{Error: ErrorMessage{
+			ID:     114,
+			Format: "Could not save screenshot: " + e.Error(),
+		}})
+		return
+	}
+
+	sendOutput("Saved screenshot to "+request.Path, true)
+	sendResponse("riscv_saveScreenshot", seq, true, EmptyResponse{})
+}
+
 var seqCounter = 1
 
 func sendOutput(str string, isDebugger bool) {
@@ -995,7 +1384,7 @@ func sendScreenUpdates() {
 		Height  int                    `json:"height"`
 		Updates []VirtualDisplayUpdate `json:"updates"`
 		Status  string                 `json:"status"`
-		Stats   map[string]int         `json:"stats"`
+		Stats   map[string]interface{} `json:"stats"`
 		Memory  map[string]string      `json:"memory"`
 	}
 
@@ -1013,19 +1402,29 @@ func sendScreenUpdates() {
 		Height:  liveEmulator.display.height,
 		Updates: liveEmulator.display.GetEntireScreen(),
 		Status:  statusString,
-		Stats: map[string]int{
+		Stats: map[string]interface{}{
 			"di":        int(liveEmulator.di),
 			"mem":       int(liveEmulator.memUsage) + len(liveAssembledResult.ProgramData),
 			"allocated": int(len(liveAssembledResult.ProgramData)),
 			"reg":       int(liveEmulator.regUsage),
 			"si":        len(liveAssembledResult.ProgramText),
 			"pc":        int(liveEmulator.pc),
+			"frames":    int(liveEmulator.display.frames),
 		},
 		Memory: map[string]string{
 			"main":  mainMemory,
 			"stack": stackMemory,
 		},
 	}
+	if report := liveEmulator.GetCacheStats(); report != nil {
+		report.addStats(packet.Stats)
+	}
+	if report := liveEmulator.GetPipelineStats(liveAssembledResult, assemblyEntry); report != nil {
+		report.addStats(packet.Stats)
+	}
+	if report := liveEmulator.GetBranchStats(liveAssembledResult, assemblyEntry); report != nil {
+		report.addStats(packet.Stats)
+	}
 
 	sendEvent("riscv_screen", packet)
 	//sendOutput(fmt.Sprintf("PC: %d", int(liveEmulator.pc)), true)
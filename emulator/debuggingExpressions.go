@@ -3,6 +3,7 @@ package emulator
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -1456,6 +1457,22 @@ func getLiteralEvaluationToken(literal string) (evaluationToken, error) {
 		}, nil
 	}
 
+	// try to parse as a floating point register
+	if r, ok := assembler.FloatRegisterNameMap[literal]; ok {
+		value := math.Float32frombits(liveEmulator.fregisters[r])
+		strRes := strconv.FormatFloat(float64(value), 'f', -1, 32)
+
+		return evaluationToken{
+			dataType: "float",
+			value: EvaluationResult{
+				Type:   EvaluationResultTypeFloat,
+				String: strRes,
+			},
+			trueValue: value,
+			strValue:  strRes,
+		}, nil
+	}
+
 	return evaluationToken{}, errors.New("could not parse literal " + literal)
 }
 
@@ -0,0 +1,124 @@
+package emulator
+
+import "github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+
+// Predecoded instruction cache. Fetching an instruction goes through memReadRaw, expands compressed
+// instructions, and picks the execute function from the opcode, which is the same work every time the
+// instruction runs. The result is kept per page with one entry per halfword, and a store invalidates the
+// entries that overlap the written word so self-modifying code still works. Only fetches that raised no
+// exception are cached, so reading uninitialized memory is still reported every time, and breakpoints and
+// the profile range are checked before the fetch like before.
+
+type decodedInstruction struct {
+	instruction uint32 // expanded to 32 bits, or the original bits if it could not be expanded
+	length      uint32
+	execute     func(*EmulatorInstance, uint32) // nil when the entry has not been decoded yet
+}
+
+type decodedPage [2048]decodedInstruction
+
+// the execute function of each opcode, the others are unsupported
+var executeFunctions = [128]func(*EmulatorInstance, uint32){
+	assembler.OPCODE_LUI:      (*EmulatorInstance).executeLUI,
+	assembler.OPCODE_AUIPC:    (*EmulatorInstance).executeAUIPC,
+	assembler.OPCODE_JAL:      (*EmulatorInstance).executeJAL,
+	assembler.OPCODE_JALR:     (*EmulatorInstance).executeJALR,
+	assembler.OPCODE_BTYPE:    (*EmulatorInstance).executeBType,
+	assembler.OPCODE_MEMITYPE: (*EmulatorInstance).executeMemIType,
+	assembler.OPCODE_ITYPE:    (*EmulatorInstance).executeIType,
+	assembler.OPCODE_RTYPE:    (*EmulatorInstance).executeRType,
+	assembler.OPCODE_STYPE:    (*EmulatorInstance).executeSType,
+	assembler.OPCODE_ENV:      (*EmulatorInstance).executeEnv,
+	assembler.OPCODE_FLW:      (*EmulatorInstance).executeFLW,
+	assembler.OPCODE_FSW:      (*EmulatorInstance).executeFSW,
+	assembler.OPCODE_FMADD:    (*EmulatorInstance).executeFMA,
+	assembler.OPCODE_FMSUB:    (*EmulatorInstance).executeFMA,
+	assembler.OPCODE_FNMSUB:   (*EmulatorInstance).executeFMA,
+	assembler.OPCODE_FNMADD:   (*EmulatorInstance).executeFMA,
+	assembler.OPCODE_FP:       (*EmulatorInstance).executeFP,
+	assembler.OPCODE_AMO:      (*EmulatorInstance).executeAMO,
+}
+
+func (inst *EmulatorInstance) executeUnsupportedOpcode(instruction uint32) {
+	inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported opcode exception: %d", assembler.GetOpCode(instruction))
+}
+
+func (inst *EmulatorInstance) executeIllegalCompressed(instruction uint32) {
+	inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported compressed instruction exception: 0x%04X", instruction)
+}
+
+// Expands the fetched instruction and picks its execute function
+func decodeInstruction(instruction, length uint32) decodedInstruction {
+	if length == 2 {
+		expanded, ok := assembler.ExpandCompressedInstruction(instruction)
+		if !ok {
+			return decodedInstruction{instruction, length, (*EmulatorInstance).executeIllegalCompressed}
+		}
+		instruction = expanded
+	}
+
+	execute := executeFunctions[assembler.GetOpCode(instruction)]
+	if execute == nil {
+		execute = (*EmulatorInstance).executeUnsupportedOpcode
+	}
+	return decodedInstruction{instruction, length, execute}
+}
+
+// Fetches and decodes the instruction at the pc and sets instructionLength, using the cache when possible
+func (inst *EmulatorInstance) fetchDecoded() decodedInstruction {
+	if inst.decodeCacheDisabled || inst.pc >= 0x80000000 {
+		instruction := inst.fetchInstruction()
+		return decodeInstruction(instruction, inst.instructionLength)
+	}
+
+	pageNum := inst.pc >> 12
+	if inst.decodedPage == nil || inst.decodedPageNum != pageNum {
+		page, ok := inst.decodedPages[pageNum]
+		if !ok {
+			page = &decodedPage{}
+			inst.decodedPages[pageNum] = page
+		}
+		inst.decodedPage = page
+		inst.decodedPageNum = pageNum
+	}
+
+	entry := &inst.decodedPage[(inst.pc&0xFFF)>>1]
+	if entry.execute != nil {
+		inst.instructionLength = entry.length
+		return *entry
+	}
+
+	numErrors := len(inst.errors)
+	decoded := decodeInstruction(inst.fetchInstruction(), inst.instructionLength)
+	if numErrors == len(inst.errors) && inst.trap == nil {
+		*entry = decoded
+	}
+	return decoded
+}
+
+// Returns the cached entries of the page, nil if none of its instructions were decoded
+func (inst *EmulatorInstance) getDecodedPage(pageNum uint32) *decodedPage {
+	if inst.decodedPage != nil && inst.decodedPageNum == pageNum {
+		return inst.decodedPage
+	}
+	return inst.decodedPages[pageNum]
+}
+
+// Invalidates the entries that overlap the word at addr, called on every store
+func (inst *EmulatorInstance) invalidateDecoded(addr uint32) {
+	// the two halfwords of the word and a 32-bit instruction that starts in the halfword before it, which is on
+	// the page before for the first word of a page
+	index := (addr & 0xFFC) >> 1
+	if page := inst.getDecodedPage(addr >> 12); page != nil {
+		page[index].execute = nil
+		page[index+1].execute = nil
+		if index > 0 {
+			page[index-1].execute = nil
+		}
+	}
+	if index == 0 {
+		if page := inst.getDecodedPage(addr>>12 - 1); page != nil {
+			page[len(page)-1].execute = nil
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package emulator
+
+import "math"
+
+// Display draw engine. The draw commands are executed by writing the command number to 0x80002FE0, with
+// their parameters in the shape draw parameter registers P0-P3 (0x80002FF0 - 0x80002FFF) and the draw
+// parameters P4 and P5 (0x80002FE4 and 0x80002FE8). Coordinates are signed, and everything is clipped to the
+// display, so shapes can be partly or entirely off screen. When the display is double buffered, everything is
+// drawn to the back buffer (see peripherals.go).
+//
+//	Command              P0  P1  P2      P3      P4                 P5
+//	1 Line               x0  y0  x1      y1      color
+//	2 Circle             x   y   radius          color
+//	3 Filled Circle      x   y   radius          color
+//	4 Filled Rectangle   x   y   width   height  color
+//	5 Blit               x   y   width   height                     source address
+//	6 Blit Transparent   x   y   width   height  transparent color  source address
+//	7 Text               x   y   scale           color              string address
+//	8 Scroll             dx  dy                  fill color
+//
+// Blits copy width*height pixels stored row by row at the source address in memory, the transparent blit
+// skips the pixels that are the transparent color. Text draws the null terminated ASCII string at the string
+// address with the built-in 5x7 font, every character is 6x8 pixels times the scale (0 is the same as 1) and
+// \n starts a new line. Scroll moves the screen by dx, dy pixels (positive is right and down) and fills the
+// pixels uncovered with the fill color. Unknown commands and circles with a radius over 65535 are ignored,
+// and memory that was never written reads as 0, which ends a string.
+
+const (
+	DrawCommandLine            = 1
+	DrawCommandCircle          = 2
+	DrawCommandFilledCircle    = 3
+	DrawCommandFilledRectangle = 4
+	DrawCommandBlit            = 5
+	DrawCommandBlitTransparent = 6
+	DrawCommandText            = 7
+	DrawCommandScroll          = 8
+)
+
+// the largest string drawn by one text command, so a missing terminator does not hang the emulator
+const maxDrawTextLength = 4096
+
+const maxDrawRadius = 65535
+
+// Executes a draw command with the parameters in the draw registers, memory is where blits and text read from
+func (s *VirtualDisplay) draw(command uint32, memory *MemoryImage) {
+	p := s.shapeDrawParams
+	x, y := int(int32(p[0])), int(int32(p[1]))
+	color := p[4]
+
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	s.written(s.width * s.height) // everything is clipped to the display
+
+	switch command {
+	case DrawCommandLine:
+		s.drawLine(x, y, int(int32(p[2])), int(int32(p[3])), color)
+	case DrawCommandCircle, DrawCommandFilledCircle:
+		s.drawCircle(x, y, int(int32(p[2])), color, command == DrawCommandFilledCircle)
+	case DrawCommandFilledRectangle:
+		s.fillRectangle(x, y, int(p[2]), int(p[3]), color)
+	case DrawCommandBlit, DrawCommandBlitTransparent:
+		s.blit(x, y, int(p[2]), int(p[3]), memory, p[5], command == DrawCommandBlitTransparent, color)
+	case DrawCommandText:
+		s.drawText(x, y, int(p[2]), memory, p[5], color)
+	case DrawCommandScroll:
+		s.scroll(x, y, color)
+	}
+}
+
+// The pixel at x, y, or false if it is off screen
+func (s *VirtualDisplay) pixelIndex(x, y int) (int, bool) {
+	if x < 0 || y < 0 || x >= s.width || y >= s.height || y*s.width+x >= len(s.data) {
+		return 0, false
+	}
+	return y*s.width + x, true
+}
+
+// Sets a pixel if it is on screen, the caller holds the data mutex
+func (s *VirtualDisplay) setPixel(x, y int, color uint32) {
+	if i, ok := s.pixelIndex(x, y); ok {
+		pixels, regions := s.target()
+		pixels[i] = color
+		regions[s.getUpdateOffset(uint32(i))] = true
+	}
+}
+
+// Marks the update regions of the pixels in the rectangle, which is already clipped to the display
+func (s *VirtualDisplay) markUpdated(x0, y0, x1, y1 int) {
+	_, regions := s.target()
+	numRegionsPerRow := (s.width + 15) / 16
+	for ry := y0 >> 4; ry <= (y1-1)>>4; ry++ {
+		for rx := x0 >> 4; rx <= (x1-1)>>4; rx++ {
+			regions[ry*numRegionsPerRow+rx] = true
+		}
+	}
+}
+
+// Clips the rectangle to the display, returning its corners (the second is exclusive) and whether any of it
+// is on screen
+func (s *VirtualDisplay) clip(x, y, width, height int) (int, int, int, int, bool) {
+	x0, y0 := x, y
+	x1, y1 := x+width, y+height
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	if x1 > s.width {
+		x1 = s.width
+	}
+	if y1 > s.height {
+		y1 = s.height
+	}
+	if s.width > 0 && y1 > len(s.data)/s.width {
+		y1 = len(s.data) / s.width
+	}
+	return x0, y0, x1, y1, x0 < x1 && y0 < y1
+}
+
+func (s *VirtualDisplay) fillRectangle(x, y, width, height int, color uint32) {
+	x0, y0, x1, y1, visible := s.clip(x, y, width, height)
+	if !visible {
+		return
+	}
+	pixels, _ := s.target()
+	for py := y0; py < y1; py++ {
+		row := pixels[py*s.width+x0 : py*s.width+x1]
+		for i := range row {
+			row[i] = color
+		}
+	}
+	s.markUpdated(x0, y0, x1, y1)
+}
+
+// Bresenham's line, both ends are drawn. The line is clipped to the display first (Liang-Barsky), so lines
+// that are mostly off screen do not take long.
+func (s *VirtualDisplay) drawLine(x0, y0, x1, y1 int, color uint32) {
+	dx, dy := x1-x0, y1-y0
+	t0, t1 := 0.0, 1.0
+	edges := [4][2]float64{
+		{float64(-dx), float64(x0)},               // left
+		{float64(dx), float64(s.width - 1 - x0)},  // right
+		{float64(-dy), float64(y0)},               // top
+		{float64(dy), float64(s.height - 1 - y0)}, // bottom
+	}
+	for _, edge := range edges {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return // parallel to the edge and outside
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 && t > t0 {
+			t0 = t
+		} else if p > 0 && t < t1 {
+			t1 = t
+		}
+	}
+	if t0 > t1 {
+		return
+	}
+	if t0 > 0 || t1 < 1 {
+		x0, y0, x1, y1 = x0+int(math.Round(t0*float64(dx))), y0+int(math.Round(t0*float64(dy))), x0+int(math.Round(t1*float64(dx))), y0+int(math.Round(t1*float64(dy)))
+		dx, dy = x1-x0, y1-y0
+	}
+
+	stepX, stepY := 1, 1
+	if dx < 0 {
+		dx, stepX = -dx, -1
+	}
+	if dy < 0 {
+		dy, stepY = -dy, -1
+	}
+
+	err := dx - dy
+	for {
+		s.setPixel(x0, y0, color)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += stepX
+		}
+		if e2 < dx {
+			err += dx
+			y0 += stepY
+		}
+	}
+}
+
+// Midpoint circle, the filled circle draws the rows between the points of the outline
+func (s *VirtualDisplay) drawCircle(cx, cy, radius int, color uint32, filled bool) {
+	if radius < 0 || radius > maxDrawRadius {
+		return
+	}
+
+	x, y := radius, 0
+	err := 1 - radius
+	for x >= y {
+		if filled {
+			s.fillRectangle(cx-x, cy+y, 2*x+1, 1, color)
+			s.fillRectangle(cx-x, cy-y, 2*x+1, 1, color)
+			s.fillRectangle(cx-y, cy+x, 2*y+1, 1, color)
+			s.fillRectangle(cx-y, cy-x, 2*y+1, 1, color)
+		} else {
+			s.setPixel(cx+x, cy+y, color)
+			s.setPixel(cx-x, cy+y, color)
+			s.setPixel(cx+x, cy-y, color)
+			s.setPixel(cx-x, cy-y, color)
+			s.setPixel(cx+y, cy+x, color)
+			s.setPixel(cx-y, cy+x, color)
+			s.setPixel(cx+y, cy-x, color)
+			s.setPixel(cx-y, cy-x, color)
+		}
+
+		y++
+		if err < 0 {
+			err += 2*y + 1
+		} else {
+			x--
+			err += 2*(y-x) + 1
+		}
+	}
+}
+
+func (s *VirtualDisplay) blit(x, y, width, height int, memory *MemoryImage, source uint32, transparent bool, transparentColor uint32) {
+	x0, y0, x1, y1, visible := s.clip(x, y, width, height)
+	if !visible {
+		return
+	}
+
+	pixels, _ := s.target()
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			color, _ := memory.ReadWord(source + uint32(((py-y)*width+(px-x))*4))
+			if !transparent || color != transparentColor {
+				pixels[py*s.width+px] = color
+			}
+		}
+	}
+	s.markUpdated(x0, y0, x1, y1)
+}
+
+func (s *VirtualDisplay) drawText(x, y, scale int, memory *MemoryImage, address uint32, color uint32) {
+	if scale < 1 {
+		scale = 1
+	}
+
+	cursorX := x
+	for i := uint32(0); i < maxDrawTextLength; i++ {
+		c, _ := memory.ReadByte(address + i)
+		if c == 0 {
+			return
+		}
+		if c == '\n' {
+			cursorX = x
+			y += 8 * scale
+			continue
+		}
+
+		glyph := drawFont['?'-0x20]
+		if c >= 0x20 && c < 0x7F {
+			glyph = drawFont[c-0x20]
+		}
+		for col, bits := range glyph {
+			for row := 0; row < 7; row++ {
+				if bits&(1<<row) != 0 {
+					s.fillRectangle(cursorX+col*scale, y+row*scale, scale, scale, color)
+				}
+			}
+		}
+		cursorX += 6 * scale
+	}
+}
+
+func (s *VirtualDisplay) scroll(dx, dy int, fill uint32) {
+	_, _, width, height, visible := s.clip(0, 0, s.width, s.height)
+	if !visible {
+		return
+	}
+
+	// copying the rows in the order that does not overwrite the ones still to be copied
+	pixels, _ := s.target()
+	for i := 0; i < height; i++ {
+		row := i
+		if dy > 0 {
+			row = height - 1 - i
+		}
+		from := row - dy
+		dest := pixels[row*s.width : row*s.width+width]
+		if from < 0 || from >= height {
+			for x := range dest {
+				dest[x] = fill
+			}
+			continue
+		}
+
+		src := pixels[from*s.width : from*s.width+width]
+		switch {
+		case dx >= width || dx <= -width:
+			for x := range dest {
+				dest[x] = fill
+			}
+		case dx >= 0:
+			copy(dest[dx:], src[:width-dx])
+			for x := 0; x < dx; x++ {
+				dest[x] = fill
+			}
+		default:
+			copy(dest[:width+dx], src[-dx:])
+			for x := width + dx; x < width; x++ {
+				dest[x] = fill
+			}
+		}
+	}
+	s.markUpdated(0, 0, width, height)
+}
+
+// 5x7 font for ASCII 0x20-0x7E, each glyph is 5 columns from left to right, bit 0 is the top row
+var drawFont = [95][5]uint8{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x00, 0x00, 0x5F, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7F, 0x14, 0x7F, 0x14}, // #
+	{0x24, 0x2A, 0x7F, 0x2A, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1C, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1C, 0x00}, // )
+	{0x08, 0x2A, 0x1C, 0x2A, 0x08}, // *
+	{0x08, 0x08, 0x3E, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3E, 0x51, 0x49, 0x45, 0x3E}, // 0
+	{0x00, 0x42, 0x7F, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4B, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7F, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3C, 0x4A, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1E}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3E}, // @
+	{0x7E, 0x11, 0x11, 0x11, 0x7E}, // A
+	{0x7F, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3E, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7F, 0x41, 0x41, 0x22, 0x1C}, // D
+	{0x7F, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7F, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3E, 0x41, 0x49, 0x49, 0x7A}, // G
+	{0x7F, 0x08, 0x08, 0x08, 0x7F}, // H
+	{0x00, 0x41, 0x7F, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3F, 0x01}, // J
+	{0x7F, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7F, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7F, 0x02, 0x0C, 0x02, 0x7F}, // M
+	{0x7F, 0x04, 0x08, 0x10, 0x7F}, // N
+	{0x3E, 0x41, 0x41, 0x41, 0x3E}, // O
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3E, 0x41, 0x51, 0x21, 0x5E}, // Q
+	{0x7F, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7F, 0x01, 0x01}, // T
+	{0x3F, 0x40, 0x40, 0x40, 0x3F}, // U
+	{0x1F, 0x20, 0x40, 0x20, 0x1F}, // V
+	{0x3F, 0x40, 0x38, 0x40, 0x3F}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7F, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // \
+	{0x00, 0x41, 0x41, 0x7F, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7F, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7F}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7E, 0x09, 0x01, 0x02}, // f
+	{0x0C, 0x52, 0x52, 0x52, 0x3E}, // g
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7D, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x44, 0x3D, 0x00}, // j
+	{0x7F, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7F, 0x40, 0x00}, // l
+	{0x7C, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7C, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0x7C, 0x14, 0x14, 0x14, 0x08}, // p
+	{0x08, 0x14, 0x14, 0x18, 0x7C}, // q
+	{0x7C, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3F, 0x44, 0x40, 0x20}, // t
+	{0x3C, 0x40, 0x40, 0x20, 0x7C}, // u
+	{0x1C, 0x20, 0x40, 0x20, 0x1C}, // v
+	{0x3C, 0x40, 0x30, 0x40, 0x3C}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x0C, 0x50, 0x50, 0x50, 0x3C}, // y
+	{0x44, 0x64, 0x54, 0x4C, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7F, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x08, 0x04, 0x08, 0x10, 0x08}, // ~
+}
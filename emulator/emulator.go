@@ -15,22 +15,32 @@ func (inst *EmulatorInstance) regRead(reg uint32) uint32 {
 	}
 
 	if inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd {
-		inst.lastUsedRegisters[int(reg)] = int(reg)
+		inst.lastUsedRegisters |= 1 << reg
 	}
 
 	return inst.registers[reg]
 }
 
 func (inst *EmulatorInstance) regWrite(reg uint32, value uint32) {
+	if inst.trap != nil {
+		// the instruction faulted, so it does not write its result
+		return
+	}
+
 	// setting valid bit
 	if inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd {
 		if inst.regInit&(1<<reg) == 0 {
 			inst.regUsage++
 		}
 		inst.regInit |= 1 << reg
-		inst.lastUsedRegisters[int(reg)] = int(reg)
-		if bp, ok := inst.registerBreakpoints[int(reg)]; ok {
-			inst.breakCallback(inst, bp.ID, "data breakpoint")
+		inst.lastUsedRegisters |= 1 << reg
+		if inst.journal != nil {
+			inst.journal.current().regWrites |= 1 << reg
+		}
+		if len(inst.registerBreakpoints) != 0 {
+			if bp, ok := inst.registerBreakpoints[int(reg)]; ok {
+				inst.breakCallback(inst, bp.ID, "data breakpoint")
+			}
 		}
 	}
 
@@ -45,16 +55,15 @@ func (inst *EmulatorInstance) regWrite(reg uint32, value uint32) {
 
 	// writing value
 	inst.registers[reg] = value
+	if inst.tracer != nil && inst.tracer.active {
+		inst.tracer.regWrites |= 1 << reg
+	}
 }
 
 func (inst *EmulatorInstance) resetLastUsedRegisters() {
 	// keep all the registers that are in the a0-a7 range
 	// a0 is 10, a7 is 17
-	for i := 0; i < 32; i++ {
-		if i < 10 || i > 17 {
-			delete(inst.lastUsedRegisters, i)
-		}
-	}
+	inst.lastUsedRegisters &= 0xFF << 10
 }
 
 func (inst *EmulatorInstance) Emulate(startAddr uint32) {
@@ -63,64 +72,59 @@ func (inst *EmulatorInstance) Emulate(startAddr uint32) {
 
 	// setting the program counter
 	inst.pc = startAddr - 4
-	// setting i and d cache to first valid block
-	for _, block := range inst.memory.Blocks {
-		if block != nil {
-			inst.iCache = block
-			break
-		}
-	}
+	inst.instructionLength = 4
+	inst.resumeAtBreakCheck = false
+	inst.isInOSSetup = startAddr >= inst.profileIgnoreRangeStart && startAddr < inst.profileIgnoreRangeEnd
+	inst.run()
+}
 
-	// setting d cache to first valid block
-	for _, block := range inst.memory.Blocks {
-		if block != nil {
-			inst.dCache = block
-			break
-		}
-	}
+// Runs until the program ends, the pc has to point at the last instruction executed
+func (inst *EmulatorInstance) run() {
+	inst.resetCaches()
 
 	for inst.di < inst.runtimeLimit && !inst.terminated {
-		inst.pc += 4
-		if inst.pc == 0x20352035 || inst.pc == 0x20352034 {
-			// end the emulator when magic number is reached (0x20352035 is the return address
-			// of the main program)
-			break
-		} else if inst.pc == 0x20352037 {
-			// magic number to resume from an interrupt
-			inst.pc = inst.interrupt.pc
-			for i := 0; i < 32; i++ {
-				inst.registers[i] = inst.interrupt.registers[i]
+		if inst.resumeAtBreakCheck {
+			// restored from a snapshot taken at a breakpoint, the instruction was already counted, see snapshot.go
+			inst.resumeAtBreakCheck = false
+			if inst.checkBreakpoints() {
+				continue
 			}
-			inst.callStack = make([]uint32, len(inst.interrupt.callStack))
-			copy(inst.callStack, inst.interrupt.callStack)
-			inst.interrupt = nil
-		}
-
-		// checking for interrupts
-		if inst.interrupt != nil {
-			// need to interrupt, save the current state
-			inst.interrupt.pc = inst.pc
+		} else {
+			inst.scheduleHarts()
+			inst.pc += inst.instructionLength
+			if inst.pc == 0x20352035 || inst.pc == 0x20352034 {
+				// end the emulator when magic number is reached (0x20352035 is the return address
+				// of the main program)
+				if inst.hartID == 0 || inst.exited {
+					break
+				}
 
-			// saving registers
-			for i := 0; i < 32; i++ {
-				inst.interrupt.registers[i] = inst.registers[i]
+				// the other harts are parked when they return
+				inst.running = false
+				inst.switchHart()
+				continue
+			} else if (inst.pc == 0x20352037 || inst.pc == 0x20352036) && inst.interrupt != nil {
+				// magic number to resume from an interrupt (jalr clears the lowest bit)
+				inst.resumeFromInterrupt()
 			}
 
-			// saving call stack
-			inst.interrupt.callStack = make([]uint32, len(inst.callStack))
-			copy(inst.interrupt.callStack, inst.callStack)
-
-			inst.userGlobalPointer = inst.registers[3]
-			inst.registers[3] = inst.osGlobalPointer
-			inst.isInOSCode = true
-			inst.registers[1] = 0x20352037 // 0x20352037 is the magic number for the RISC-V emulator to know when to resume from an interrupt
-		}
+			// checking for interrupts, they are not nested and are held while the OS sets up or services an ECALL
+			if inst.hasPendingInterrupt.Load() && inst.interrupt == nil && !inst.isInOSCode && !inst.isInOSSetup && inst.osInterruptHandlerEntry != 0 {
+				inst.deliverInterrupt()
+			}
 
-		if inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd {
-			inst.di++
+			if inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd {
+				inst.di++
+				inst.bus.tick(inst)
 
-			// checking if should break - this is only done when profiling
-			inst.checkShouldBreak()
+				// checking if should break - this is only done when profiling
+				if inst.journal != nil {
+					inst.journal.record(inst)
+				}
+				if inst.checkBreakpoints() {
+					continue
+				}
+			}
 		}
 
 		if inst.isInOSCode {
@@ -142,58 +146,102 @@ func (inst *EmulatorInstance) Emulate(startAddr uint32) {
 							inst.registers[i+10] = val
 							// setting the valid bit
 							inst.regInit |= 1 << uint32(i+10)
-							inst.lastUsedRegisters[i+10] = i + 10
+							inst.lastUsedRegisters |= 1 << uint32(i+10)
 						}
 					}
 				}
 			}
 		}
 
-		// fetching next instruction
-		instruction := inst.memReadWord(inst.pc, true)
+		// fetching and decoding the next instruction, see decodeCache.go
+		inst.executing = true
+		decoded := inst.fetchDecoded()
+		if inst.caches != nil {
+			inst.caches.fetch(inst, decoded.length)
+		}
+		if inst.trap != nil {
+			inst.takeTrap(false, true)
+			inst.executing = false
+			continue
+		}
 
-		// decoding instruction
-		opcode := assembler.GetOpCode(instruction)
 		// executing instruction
-		switch opcode {
-		case assembler.OPCODE_LUI:
-			inst.executeLUI(instruction)
-		case assembler.OPCODE_AUIPC:
-			inst.executeAUIPC(instruction)
-		case assembler.OPCODE_JAL:
-			inst.executeJAL(instruction)
-		case assembler.OPCODE_JALR:
-			inst.executeJALR(instruction)
-		case assembler.OPCODE_BTYPE:
-			inst.executeBType(instruction)
-		case assembler.OPCODE_MEMITYPE:
-			inst.executeMemIType(instruction)
-		case assembler.OPCODE_ITYPE:
-			inst.executeIType(instruction)
-		case assembler.OPCODE_RTYPE:
-			inst.executeRType(instruction)
-		case assembler.OPCODE_STYPE:
-			inst.executeSType(instruction)
-		case assembler.OPCODE_ENV:
-			inst.executeEnv(instruction)
-		default:
-			inst.newException("Unsupported opcode exception: %d", opcode)
+		if inst.tracer != nil {
+			inst.tracer.begin(inst, decoded)
+		}
+		pc := inst.pc
+		decoded.execute(inst, decoded.instruction)
+		if inst.tracer != nil {
+			inst.tracer.executed(inst)
+		}
+		if inst.pipeline != nil && inst.trap == nil {
+			inst.pipeline.retire(inst, pc, decoded.instruction)
 		}
 
+		if inst.trap != nil {
+			opcode := assembler.GetOpCode(decoded.instruction)
+			isLoadReserved := decoded.instruction>>27 == 0b00010
+			inst.takeTrap(opcode == assembler.OPCODE_STYPE || opcode == assembler.OPCODE_FSW || (opcode == assembler.OPCODE_AMO && !isLoadReserved), false)
+		}
+
+		inst.executing = false
 		inst.executedInstructions++
 	}
+	if inst.tracer != nil {
+		inst.tracer.end(inst)
+	}
 	if inst.di >= inst.runtimeLimit {
 		sendOutput(fmt.Sprintf("***Infinite Loop? DI: %d***", inst.di), true)
 	}
 }
 
+func (inst *EmulatorInstance) resetCaches() {
+	// setting i and d cache to first valid block
+	for _, block := range inst.memory.Blocks {
+		if block != nil {
+			inst.iCache = block
+			break
+		}
+	}
+
+	// setting d cache to first valid block
+	for _, block := range inst.memory.Blocks {
+		if block != nil {
+			inst.dCache = block
+			break
+		}
+	}
+
+	inst.decodedPages = map[uint32]*decodedPage{}
+	inst.decodedPage = nil
+}
+
+// Pauses if a breakpoint or step is hit. Returns true when the debugger asked to step back while paused, the
+// emulator then travelled back to an earlier instruction, which the caller has to continue from.
+func (inst *EmulatorInstance) checkBreakpoints() bool {
+	inst.checkingBreakpoints = true
+	inst.checkShouldBreak()
+	inst.checkingBreakpoints = false
+
+	if inst.journal != nil && inst.journal.request != nil {
+		inst.travel()
+		return true
+	}
+	return false
+}
+
 func (inst *EmulatorInstance) checkShouldBreak() {
-	if inst.breakAddr == inst.pc || inst.breakNext {
+	if (inst.breakAddr == inst.pc || inst.breakNext) && inst.hartID == inst.breakHart {
 		inst.breakNext = false
 		inst.breakAddr = 0xFFFFFFFF
 		if inst.breakCallback != nil {
 			inst.breakCallback(inst, inst.breakpoints[inst.pc].ID, "breakpoint")
 		}
+		return // a breakpoint at the same address would pause a second time
+	}
+
+	if len(inst.breakpoints) == 0 {
+		return // skipping the lookup, this runs before every instruction
 	}
 
 	bp, ok := inst.breakpoints[inst.pc]
@@ -202,13 +250,7 @@ func (inst *EmulatorInstance) checkShouldBreak() {
 	}
 
 	if bp.condition != "" {
-		res, err := EvaluateExpression(bp.condition)
-		if err != nil {
-			inst.newException("Error evaluating breakpoint condition: %s", err.Error())
-			return
-		}
-
-		if n, _ := strconv.Atoi(res.String); n != 0 || res.String == "true" {
+		if inst.breakpointConditionHolds(bp.condition) {
 			inst.breakNext = false
 			inst.breakAddr = 0xFFFFFFFF
 			if inst.breakCallback != nil {
@@ -226,6 +268,17 @@ func (inst *EmulatorInstance) checkShouldBreak() {
 	}
 }
 
+func (inst *EmulatorInstance) breakpointConditionHolds(condition string) bool {
+	res, err := EvaluateExpression(condition)
+	if err != nil {
+		inst.newException("Error evaluating breakpoint condition: %s", err.Error())
+		return false
+	}
+
+	n, _ := strconv.Atoi(res.String)
+	return n != 0 || res.String == "true"
+}
+
 func (inst *EmulatorInstance) executeLUI(instruction uint32) {
 	// decode the instruction
 	_, rd, imm := assembler.DecodeUTypeInstruction(instruction)
@@ -243,15 +296,19 @@ func (inst *EmulatorInstance) executeJAL(instruction uint32) {
 	_, rd, imm := assembler.DecodeJTypeInstruction(instruction)
 
 	// setting the return address
+	pcVal := inst.pc
 	if rd != 0 {
-		inst.regWrite(rd, inst.pc+4)
+		inst.regWrite(rd, inst.pc+inst.instructionLength)
 		if rd == 1 {
 			inst.callStack = append(inst.callStack, inst.pc)
 		}
 	}
 
 	// jumping to the new address
-	inst.pc = uint32(int32(inst.pc)+int32(imm<<11)>>11) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
+	inst.pc = uint32(int32(inst.pc)+int32(imm<<11)>>11) - inst.instructionLength // the pc is incremented by the instruction length before the next instruction is fetched
+	if inst.predictor != nil {
+		inst.predictor.resolve(inst, pcVal, inst.pc+inst.instructionLength, true, false)
+	}
 }
 
 func (inst *EmulatorInstance) executeJALR(instruction uint32) {
@@ -272,54 +329,57 @@ func (inst *EmulatorInstance) executeJALR(instruction uint32) {
 	}
 
 	// jumping to the new address
-	inst.pc = (uint32(int32(inst.regRead(rs1))+int32(imm<<20)>>20) & 0xFFFFFFFE) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
+	inst.pc = (uint32(int32(inst.regRead(rs1))+int32(imm<<20)>>20) & 0xFFFFFFFE) - inst.instructionLength // the pc is incremented by the instruction length before the next instruction is fetched
 
 	// setting the return address
 	if rd != 0 {
-		inst.regWrite(rd, pcVal+4)
+		inst.regWrite(rd, pcVal+inst.instructionLength)
+	}
+	if inst.predictor != nil {
+		inst.predictor.resolve(inst, pcVal, inst.pc+inst.instructionLength, true, false)
 	}
 }
 
 func (inst *EmulatorInstance) executeBType(instruction uint32) {
 	opcode, rs1, rs2, imm, func3 := assembler.DecodeBTypeInstruction(instruction)
 	immInt := int32(imm<<19) >> 19
+	pcVal := inst.pc
+	taken := false
 	if opcode == 0b1100011 {
 		switch func3 {
 		case 0b000:
 			// BEQ
-			if inst.regRead(rs1) == inst.regRead(rs2) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = inst.regRead(rs1) == inst.regRead(rs2)
 		case 0b001:
 			// BNE
-			if inst.regRead(rs1) != inst.regRead(rs2) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = inst.regRead(rs1) != inst.regRead(rs2)
 		case 0b100:
 			// BLT
-			if int32(inst.regRead(rs1)) < int32(inst.regRead(rs2)) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = int32(inst.regRead(rs1)) < int32(inst.regRead(rs2))
 		case 0b101:
 			// BGE
-			if int32(inst.regRead(rs1)) >= int32(inst.regRead(rs2)) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = int32(inst.regRead(rs1)) >= int32(inst.regRead(rs2))
 		case 0b110:
 			// BLTU
-			if inst.regRead(rs1) < inst.regRead(rs2) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = inst.regRead(rs1) < inst.regRead(rs2)
 		case 0b111:
 			// BGEU
-			if inst.regRead(rs1) >= inst.regRead(rs2) {
-				inst.pc = uint32(int32(inst.pc)+immInt) - 4 // the -4 is because the pc is incremented by 4 before the instruction is fetched
-			}
+			taken = inst.regRead(rs1) >= inst.regRead(rs2)
 		default:
-			inst.newException("Unsupported B-Type instruction exception: op=%d func3=%d", opcode, func3)
+			inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported B-Type instruction exception: op=%d func3=%d", opcode, func3)
 		}
 	} else {
-		inst.newException("Unsupported B-Type instruction exception: op=%d func3=%d", opcode, func3)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported B-Type instruction exception: op=%d func3=%d", opcode, func3)
+	}
+
+	// a branch to the next instruction is taken even though the pc ends up the same
+	inst.branchTaken = taken
+	if taken {
+		inst.pc = uint32(int32(inst.pc)+immInt) - inst.instructionLength // the pc is incremented by the instruction length before the next instruction is fetched
+	}
+
+	if inst.predictor != nil && inst.trap == nil {
+		inst.predictor.resolve(inst, pcVal, uint32(int32(pcVal)+immInt), taken, true)
 	}
 }
 
@@ -345,7 +405,7 @@ func (inst *EmulatorInstance) executeMemIType(instruction uint32) {
 		// LHU
 		inst.regWrite(rd, uint32(inst.memReadHalf(uint32(int32(inst.regRead(rs1))+immInt))))
 	default:
-		inst.newException("Unsupported Mem I-Type instruction exception: func3=%d", func3)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported Mem I-Type instruction exception: func3=%d", func3)
 	}
 }
 
@@ -392,11 +452,11 @@ func (inst *EmulatorInstance) executeIType(instruction uint32) {
 				// SRAI
 				inst.regWrite(rd, uint32(int32(inst.regRead(rs1))>>(imm&0b11111)))
 			} else {
-				inst.newException("Unsupported I-Type instruction exception: op=%d func3=%d imm=%d", opcode, func3, imm)
+				inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported I-Type instruction exception: op=%d func3=%d imm=%d", opcode, func3, imm)
 			}
 		}
 	} else {
-		inst.newException("Unsupported I-Type instruction exception: op=%d func3=%d", opcode, func3)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported I-Type instruction exception: op=%d func3=%d", opcode, func3)
 	}
 }
 
@@ -466,9 +526,13 @@ func (inst *EmulatorInstance) executeRType(instruction uint32) {
 				inst.regWrite(rd, uint32(int64(int64(inst.regRead(rs1))*int64(inst.regRead(rs2))>>32)))
 			case 0b100:
 				// DIV
-				// testing for divide by zero
+				// testing for divide by zero, which does not trap on real hardware
 				if inst.regRead(rs2) == 0 {
-					inst.newException("divide by zero")
+					if inst.trapMode {
+						inst.regWrite(rd, 0xFFFFFFFF)
+					} else {
+						inst.newException("divide by zero")
+					}
 					return
 				}
 
@@ -477,23 +541,47 @@ func (inst *EmulatorInstance) executeRType(instruction uint32) {
 				// DIVU
 				// testing for divide by zero
 				if inst.regRead(rs2) == 0 {
-					inst.newException("divide by zero")
+					if inst.trapMode {
+						inst.regWrite(rd, 0xFFFFFFFF)
+					} else {
+						inst.newException("divide by zero")
+					}
 					return
 				}
 
 				inst.regWrite(rd, inst.regRead(rs1)/inst.regRead(rs2))
 			case 0b110:
 				// REM
+				// testing for divide by zero, the remainder is the dividend
+				if inst.regRead(rs2) == 0 {
+					if inst.trapMode {
+						inst.regWrite(rd, inst.regRead(rs1))
+					} else {
+						inst.newException("divide by zero")
+					}
+					return
+				}
+
 				inst.regWrite(rd, uint32(int32(inst.regRead(rs1))%int32(inst.regRead(rs2))))
 			case 0b111:
 				// REMU
+				// testing for divide by zero
+				if inst.regRead(rs2) == 0 {
+					if inst.trapMode {
+						inst.regWrite(rd, inst.regRead(rs1))
+					} else {
+						inst.newException("divide by zero")
+					}
+					return
+				}
+
 				inst.regWrite(rd, inst.regRead(rs1)%inst.regRead(rs2))
 			}
 		} else {
-			inst.newException("Unsupported R-Type instruction exception: op=%d func3=%d func7=%d", opcode, func3, func7)
+			inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported R-Type instruction exception: op=%d func3=%d func7=%d", opcode, func3, func7)
 		}
 	} else {
-		inst.newException("Unsupported R-Type instruction exception: op=%d func3=%d func7=%d", opcode, func3, func7)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported R-Type instruction exception: op=%d func3=%d func7=%d", opcode, func3, func7)
 	}
 }
 
@@ -514,10 +602,10 @@ func (inst *EmulatorInstance) executeSType(instruction uint32) {
 			// SW
 			inst.memWriteWord(uint32(int32(inst.regRead(rs1))+immInt), inst.regRead(rs2))
 		default:
-			inst.newException("Unsupported S-Type instruction exception: op=%d func3=%d", opcode, func3)
+			inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported S-Type instruction exception: op=%d func3=%d", opcode, func3)
 		}
 	} else {
-		inst.newException("Unsupported S-Type instruction exception: op=%d func3=%d", opcode, func3)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported S-Type instruction exception: op=%d func3=%d", opcode, func3)
 	}
 }
 
@@ -529,7 +617,7 @@ func (inst *EmulatorInstance) executeEnv(instruction uint32) {
 		case 0b000:
 			// ECALL/EBREAK
 			if imm == 0b000000000000 {
-				if inst.osEntry == 0 {
+				if inst.osEntry == 0 && !inst.trapsEnabled() {
 					// no os entry point, so throw an exception
 					inst.newException("No ECALL handler registered. Perhaps the assignment file wasn't specified, or the editor is in the wrong folder?")
 					return
@@ -540,6 +628,7 @@ func (inst *EmulatorInstance) executeEnv(instruction uint32) {
 				if inst.registers[17] == 93 {
 					// syscall 93 is a special case that is used to exit the emulator
 					inst.exitCode = int(inst.registers[10])
+					inst.exited = true
 					inst.pc = 0x20352031
 					return
 				} else if inst.registers[17] == 214 {
@@ -549,6 +638,10 @@ func (inst *EmulatorInstance) executeEnv(instruction uint32) {
 					inst.registers[10] = inst.heapPointer
 					inst.heapPointer = uint32(int32(inst.heapPointer) + int32(inst.registers[16]))
 					return
+				} else if inst.fs != nil && inst.executeFileSystemCall(inst.registers[17]) {
+					// open, read, write, close, and lseek on the virtual filesystem, read and write
+					// take their registers in the same order as writing to stdout below
+					return
 				} else if inst.registers[17] == 64 {
 					// write
 					// file descriptor is in x12
@@ -566,10 +659,17 @@ func (inst *EmulatorInstance) executeEnv(instruction uint32) {
 						}
 					}
 					return
+				} else if inst.trapsEnabled() {
+					// the program's own trap handler services every other ECALL
+					inst.newTrapException(TrapCauseEcallMachine, 0, "ECALL")
+					return
 				}
 
 				inst.userGlobalPointer = inst.registers[3]
 				inst.isInOSCode = true
+				if inst.tracer != nil {
+					inst.tracer.active = false // the OS is not traced, which includes pushing the arguments
+				}
 
 				// preserving registers
 				for i := 1; i < 31; i++ {
@@ -596,13 +696,21 @@ func (inst *EmulatorInstance) executeEnv(instruction uint32) {
 				inst.pc = inst.osEntry
 			} else if imm == 0b000000000001 {
 				// EBREAK
-				inst.newException("EBREAK instruction exception")
+				inst.newTrapException(TrapCauseBreakpoint, inst.pc, "EBREAK instruction exception")
+			} else if imm == 0b001100000010 {
+				// MRET
+				inst.executeMRET()
+			} else {
+				inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported Env-Type instruction exception: op=%d func3=%d imm=%d", opcode, func3, imm)
 			}
+		case 0b001, 0b010, 0b011, 0b101, 0b110, 0b111:
+			// Zicsr
+			inst.executeCSR(instruction)
 		default:
-			inst.newException("Unsupported Env-Type instruction exception: op=%d func3=%d", opcode, func3)
+			inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported Env-Type instruction exception: op=%d func3=%d", opcode, func3)
 		}
 	default:
-		inst.newException("Unsupported Env-Type instruction exception: op=%d func3=%d", opcode, func3)
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported Env-Type instruction exception: op=%d func3=%d", opcode, func3)
 	}
 }
 
@@ -612,10 +720,3 @@ func (inst *EmulatorInstance) reportException(exception RuntimeException) {
 		inst.runtimeErrorCallback(exception)
 	}
 }
-
-func (inst *EmulatorInstance) Interrupt(interrupt *Interrupt) {
-	if inst.interrupt != nil {
-		return // still processing previous interrupt
-	}
-	inst.interrupt = interrupt
-}
@@ -0,0 +1,1958 @@
+package emulator_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/emulator"
+)
+
+// sums an array in a loop, storing the running total to memory to exercise the store invalidation
+const benchmarkSource = `
+.text
+	addi x5, x0, 1000
+outer:
+	addi x6, gp, 0
+	addi x7, x0, 16
+	addi x10, x0, 0
+inner:
+	lw x28, 0(x6)
+	add x10, x10, x28
+	sw x10, 64(gp)
+	addi x6, x6, 4
+	addi x7, x7, -1
+	bne x7, x0, inner
+	addi x5, x5, -1
+	bne x5, x0, outer
+	jalr x0, x1, 0
+.data
+Array: .word 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16
+Total: .word 0
+`
+
+const testTextAddress = 0x1000
+
+// An emulator running a test program, which is loaded at testTextAddress with its data right after the text
+type testEmulator struct {
+	*emulator.EmulatorInstance
+	program     *assembler.AssembledResult
+	dataAddress uint32
+}
+
+// Assembles the source and creates an emulator for it with the settings the tests share, which configure
+// can change before the emulator is created
+func newTestEmulator(tb testing.TB, source string, configure ...func(*emulator.EmulatorConfig)) *testEmulator {
+	tb.Helper()
+	program := assembler.Assemble(source)
+	if len(program.Diagnostics) != 0 {
+		tb.Fatalf("Failed to assemble the test program: %s", program.Diagnostics[0].Message)
+	}
+
+	dataAddress := testTextAddress + uint32(len(program.ProgramText)*4)
+	memory := emulator.NewMemoryImage()
+	for i, instruction := range program.ProgramText {
+		memory.WriteWord(testTextAddress+uint32(i*4), instruction)
+	}
+	for i, data := range program.ProgramData {
+		memory.WriteWord(dataAddress+uint32(i*4), data)
+	}
+
+	config := emulator.EmulatorConfig{
+		StackStartAddress:       0x7FFFFFF0,
+		GlobalDataAddress:       dataAddress,
+		Memory:                  memory,
+		ProfileIgnoreRangeStart: 0xFFFFFFFF,
+		ProfileIgnoreRangeEnd:   0xFFFFFFFF,
+		RuntimeLimit:            1000000,
+		RandomSeed:              1,
+	}
+	for _, c := range configure {
+		c(&config)
+	}
+
+	return &testEmulator{
+		EmulatorInstance: emulator.NewEmulator(config),
+		program:          program,
+		dataAddress:      dataAddress,
+	}
+}
+
+// Runs the program from the start, failing the test if it had runtime errors
+func (e *testEmulator) run(tb testing.TB) {
+	tb.Helper()
+	e.Emulate(testTextAddress)
+	if errors := e.GetErrors(); len(errors) != 0 {
+		tb.Fatalf("Expected the program to run without errors, got %d: %v", len(errors), errors)
+	}
+}
+
+// Returns the word at offset bytes into the data of the program
+func (e *testEmulator) word(offset uint32) uint32 {
+	value, _ := e.Memory().ReadWord(e.dataAddress + offset)
+	return value
+}
+
+// Returns the state of the emulator as a saved snapshot, two emulators in the same state save the same bytes
+func saveSnapshot(tb testing.TB, inst *emulator.EmulatorInstance) []byte {
+	tb.Helper()
+	snapshot, e := inst.Snapshot()
+	if e != nil {
+		tb.Fatalf("Failed to take a snapshot: %s", e)
+	}
+	buf := bytes.Buffer{}
+	if e := snapshot.Save(&buf); e != nil {
+		tb.Fatalf("Failed to save the snapshot: %s", e)
+	}
+	return buf.Bytes()
+}
+
+func TestMemoryImageClone(t *testing.T) {
+	memory := emulator.NewMemoryImage()
+	memory.WriteWord(0x1000, 1)
+	memory.WriteWord(0x2000, 2)
+
+	clone := memory.Clone()
+	clone.WriteWord(0x1000, 3)
+	memory.WriteWord(0x2000, 4)
+
+	expected := []struct {
+		memory *emulator.MemoryImage
+		addr   uint32
+		value  uint32
+	}{
+		{memory, 0x1000, 1},
+		{memory, 0x2000, 4},
+		{clone, 0x1000, 3},
+		{clone, 0x2000, 2},
+	}
+
+	for i, e := range expected {
+		if value, ok := e.memory.ReadWord(e.addr); !ok || value != e.value {
+			t.Errorf("Expected read %d of 0x%08x to be %d, got %d", i, e.addr, e.value, value)
+		}
+	}
+}
+
+// A run that is snapshotted, saved, loaded, and resumed in a new emulator must end in the same state as an
+// uninterrupted run, which is checked by comparing the saved snapshots of both
+func TestSnapshotResume(t *testing.T) {
+	newEmulator := func(runtimeLimit uint32) *testEmulator {
+		return newTestEmulator(t, benchmarkSource, func(config *emulator.EmulatorConfig) {
+			config.RuntimeLimit = runtimeLimit
+			config.Harts = 2
+		})
+	}
+
+	uninterrupted := newEmulator(1000000)
+	uninterrupted.run(t)
+
+	stopped := newEmulator(5000)
+	stopped.run(t)
+	snapshot, e := emulator.LoadSnapshot(bytes.NewReader(saveSnapshot(t, stopped.EmulatorInstance)))
+	if e != nil {
+		t.Fatalf("Failed to load the snapshot: %s", e)
+	}
+
+	resumed := newEmulator(1000000)
+	resumed.Restore(snapshot)
+	if resumed.GetDynamicInstructionCount() != 5000 {
+		t.Fatalf("Expected the restored DI to be 5000, got %d", resumed.GetDynamicInstructionCount())
+	}
+	resumed.Resume()
+
+	if resumed.GetDynamicInstructionCount() != uninterrupted.GetDynamicInstructionCount() {
+		t.Errorf("Expected the resumed DI to be %d, got %d", uninterrupted.GetDynamicInstructionCount(), resumed.GetDynamicInstructionCount())
+	}
+	if !bytes.Equal(saveSnapshot(t, resumed.EmulatorInstance), saveSnapshot(t, uninterrupted.EmulatorInstance)) {
+		t.Errorf("Expected the resumed run to end in the same state as the uninterrupted run")
+	}
+
+	if _, e := emulator.LoadSnapshot(bytes.NewReader([]byte("RVEMSNAP\x02\x00\x00\x00"))); e == nil {
+		t.Errorf("Expected loading an unsupported snapshot version to fail")
+	}
+}
+
+// The binary trace of a run has to decode to its text trace, which has a line per instruction
+func TestTrace(t *testing.T) {
+	trace := func(format emulator.TraceFormat) ([]byte, uint32) {
+		inst := newTestEmulator(t, benchmarkSource, func(config *emulator.EmulatorConfig) {
+			config.RuntimeLimit = 100
+		})
+
+		buf := bytes.Buffer{}
+		tracer := emulator.NewTracer(&buf, format, inst.program, testTextAddress)
+		inst.SetTracer(tracer)
+		inst.run(t)
+		inst.SetTracer(nil)
+		if e := tracer.Flush(); e != nil {
+			t.Fatalf("Failed to write the trace: %s", e)
+		}
+		return buf.Bytes(), inst.dataAddress
+	}
+
+	text, dataAddress := trace(emulator.TraceText)
+	binary, _ := trace(emulator.TraceBinary)
+	decoded := bytes.Buffer{}
+	if e := emulator.DecodeTrace(bytes.NewReader(binary), &decoded); e != nil {
+		t.Fatalf("Failed to decode the binary trace: %s", e)
+	}
+	if !bytes.Equal(decoded.Bytes(), text) {
+		t.Errorf("Expected the decoded binary trace to match the text trace")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("Expected a line for each of the 100 instructions, got %d", len(lines))
+	}
+
+	expected := []struct {
+		line     int
+		contains string
+	}{
+		{0, "0 0x00001000 3E800293 addi x5, x0, 1000            line 3     | x5=0x000003E8"},
+		{4, "lw x28, 0(x6)"},
+		{4, fmt.Sprintf("r[0x%08X]=0x00000001", dataAddress)},
+		{6, fmt.Sprintf("w[0x%08X]=0x00000001", dataAddress+64)},
+	}
+	for _, e := range expected {
+		if !strings.Contains(lines[e.line], e.contains) {
+			t.Errorf("Expected trace line %d to contain %q, got %q", e.line, e.contains, lines[e.line])
+		}
+	}
+}
+
+// Everything the test program touches fits in the caches, so only the first access to each line misses. The
+// program is at 0x1000-0x1037 and the data at 0x1038-0x107B, which is 4 and 5 lines of 16 bytes.
+func TestCacheSimulation(t *testing.T) {
+	config := &emulator.CacheHierarchyConfig{
+		L1I: emulator.CacheConfig{Size: 256, Associativity: 1, LineSize: 16},
+		L1D: emulator.CacheConfig{Size: 256, Associativity: 2, LineSize: 16, Replacement: emulator.CacheFIFO},
+		L2:  &emulator.CacheConfig{Size: 1024, Associativity: 4, LineSize: 16, Replacement: emulator.CacheRandom},
+	}
+	if e := config.Validate(); e != nil {
+		t.Fatalf("Expected the cache configuration to be valid, got %s", e)
+	}
+
+	inst := newTestEmulator(t, benchmarkSource, func(c *emulator.EmulatorConfig) {
+		c.Caches = config
+	})
+	inst.run(t)
+
+	// 1000 iterations of 3 instructions, 16 iterations of 6 instructions with a load and a store, and 2
+	// instructions, plus the first and last instruction
+	const instructions = 1000*(3+16*6+2) + 2
+	const accesses = 1000 * 16 * 2
+
+	// the L2 sees the misses of both L1 caches, and the line shared by the last instructions and the first
+	// data was already brought in by the data cache when the instructions get to it
+	report := inst.GetCacheStats()
+	expected := []struct {
+		name     string
+		stats    emulator.CacheStats
+		expected emulator.CacheStats
+	}{
+		{"L1I", report.L1I, emulator.CacheStats{Hits: instructions - 4, Misses: 4}},
+		{"L1D", report.L1D, emulator.CacheStats{Hits: accesses - 5, Misses: 5}},
+		{"L2", *report.L2, emulator.CacheStats{Hits: 1, Misses: 8}},
+	}
+	for _, e := range expected {
+		if e.stats != e.expected {
+			t.Errorf("Expected the %s stats to be %+v, got %+v", e.name, e.expected, e.stats)
+		}
+	}
+
+	config.L1D.Associativity = 3
+	if e := config.Validate(); e == nil {
+		t.Errorf("Expected an associativity of 3 to be invalid")
+	}
+}
+
+// The loads in the test program are used right after, and the branches back to inner and outer are taken
+// every time but the last
+func TestPipeline(t *testing.T) {
+	run := func(forwarding bool) *emulator.PipelineReport {
+		inst := newTestEmulator(t, benchmarkSource, func(config *emulator.EmulatorConfig) {
+			config.Pipeline = &emulator.PipelineConfig{Forwarding: forwarding}
+		})
+		inst.run(t)
+		return inst.GetPipelineStats(inst.program, testTextAddress)
+	}
+
+	const instructions = 1000*(3+16*6+2) + 2
+	const flushCycles = (1000*15 + 999 + 1) * 2 // the taken branches and the final jalr
+
+	// with forwarding, only the add after the load stalls
+	report := run(true)
+	expected := emulator.PipelineReport{
+		Cycles:        instructions + 4 + 16000 + flushCycles,
+		Instructions:  instructions,
+		DataStalls:    16000,
+		LoadUseStalls: 16000,
+		FlushCycles:   flushCycles,
+	}
+	expected.CPI = float64(expected.Cycles) / float64(instructions)
+	lines := report.Lines
+	report.Lines = nil
+	if fmt.Sprint(*report) != fmt.Sprint(expected) {
+		t.Errorf("Expected the pipeline stats with forwarding to be %+v, got %+v", expected, *report)
+	}
+
+	expectedLines := []emulator.PipelineLineReport{
+		{Line: 10, DataStalls: 16000, LoadUseStalls: 16000},
+		{Line: 14, FlushCycles: 1000 * 15 * 2},
+		{Line: 16, FlushCycles: 999 * 2},
+		{Line: 17, FlushCycles: 2},
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(expectedLines) {
+		t.Errorf("Expected the stalls by line to be %+v, got %+v", expectedLines, lines)
+	}
+
+	// without forwarding, the add, the store, and both branches wait two cycles for the instruction before
+	report = run(false)
+	if report.DataStalls != 1000*16*3*2+1000*2 || report.LoadUseStalls != 0 || report.FlushCycles != flushCycles {
+		t.Errorf("Expected 98000 data stalls and %d flush cycles without forwarding, got %+v", flushCycles, *report)
+	}
+
+	// ECALLs count as jumps, both the sbrk the emulator services and the one that goes to the OS, whose entry
+	// point is the address before its first instruction
+	const ecallSource = `
+.text
+OS:
+	jalr x0, x1, 0
+Main:
+	lui x5, 0x80003
+	lui x6, 1
+	addi x6, x6, -4
+	sw x6, 0(x5)
+	addi x17, x0, 214
+	ecall
+	addi x17, x0, 1
+	ecall
+	jalr x0, x1, 0
+`
+	main := testTextAddress + assembler.Assemble(ecallSource).Labels["Main"]
+	inst := newTestEmulator(t, ecallSource, func(config *emulator.EmulatorConfig) {
+		config.Pipeline = &emulator.PipelineConfig{Forwarding: true}
+		config.ProfileIgnoreRangeStart = testTextAddress
+		config.ProfileIgnoreRangeEnd = main
+	})
+	inst.Emulate(main)
+	report = inst.GetPipelineStats(inst.program, testTextAddress)
+	expectedLines = []emulator.PipelineLineReport{
+		{Line: 11, FlushCycles: 1},
+		{Line: 13, FlushCycles: 1},
+		{Line: 14, FlushCycles: 2},
+	}
+	if report.Instructions != 9 || report.FlushCycles != 4 || fmt.Sprint(report.Lines) != fmt.Sprint(expectedLines) {
+		t.Errorf("Expected 9 instructions and 4 flush cycles, one for each ECALL and two for the jalr, got %+v", *report)
+	}
+}
+
+// The branch back to inner is taken 15 of 16 times and the one back to outer 999 of 1000 times
+func TestBranchPredictor(t *testing.T) {
+	run := func(config emulator.BranchPredictorConfig) (*emulator.BranchReport, *emulator.PipelineReport) {
+		if e := config.Validate(); e != nil {
+			t.Fatalf("Expected the branch predictor to be valid, got %s", e)
+		}
+
+		inst := newTestEmulator(t, benchmarkSource, func(c *emulator.EmulatorConfig) {
+			c.Pipeline = &emulator.PipelineConfig{Forwarding: true}
+			c.BranchPredictor = &config
+		})
+		inst.run(t)
+		return inst.GetBranchStats(inst.program, testTextAddress), inst.GetPipelineStats(nil, 0)
+	}
+
+	// 1-bit predictors mispredict the first and last branch back to inner every time, 2-bit ones only the
+	// last, once they learned it is taken
+	expected := []struct {
+		config emulator.BranchPredictorConfig
+		sites  []emulator.BranchSiteReport
+	}{
+		{emulator.BranchPredictorConfig{Type: emulator.PredictNotTaken}, []emulator.BranchSiteReport{
+			{Line: 14, Predictions: 16000, Correct: 1000},
+			{Line: 16, Predictions: 1000, Correct: 1},
+		}},
+		{emulator.BranchPredictorConfig{Type: emulator.Predict1Bit}, []emulator.BranchSiteReport{
+			{Line: 14, Predictions: 16000, Correct: 14000},
+			{Line: 16, Predictions: 1000, Correct: 998},
+		}},
+		{emulator.BranchPredictorConfig{Type: emulator.Predict2Bit, Entries: 64}, []emulator.BranchSiteReport{
+			{Line: 14, Predictions: 16000, Correct: 14999},
+			{Line: 16, Predictions: 1000, Correct: 998},
+		}},
+		{emulator.BranchPredictorConfig{Type: emulator.Predict2Bit, BTBEntries: 16}, []emulator.BranchSiteReport{
+			{Line: 14, Predictions: 16000, Correct: 14999},
+			{Line: 16, Predictions: 1000, Correct: 998},
+			{Line: 17, Predictions: 1, Correct: 0},
+		}},
+	}
+	for _, e := range expected {
+		report, pipeline := run(e.config)
+		var predictions, correct uint64
+		for i := range e.sites {
+			e.sites[i].Accuracy = float64(e.sites[i].Correct) / float64(e.sites[i].Predictions) * 100
+			predictions += e.sites[i].Predictions
+			correct += e.sites[i].Correct
+		}
+		if report.Predictions != predictions || report.Correct != correct || fmt.Sprint(report.Sites) != fmt.Sprint(e.sites) {
+			t.Errorf("Expected the %+v predictor to be right %d of %d times at %+v, got %+v", e.config, correct, predictions, e.sites, *report)
+		}
+
+		// only mispredictions flush the pipeline, and the jalr without a BTB
+		flushCycles := (predictions - correct) * 2
+		if e.config.BTBEntries == 0 {
+			flushCycles += 2
+		}
+		if pipeline.FlushCycles != flushCycles {
+			t.Errorf("Expected %d flush cycles with the %+v predictor, got %d", flushCycles, e.config, pipeline.FlushCycles)
+		}
+	}
+
+	if e := (&emulator.BranchPredictorConfig{Type: emulator.PredictGshare, Entries: 100}).Validate(); e == nil {
+		t.Errorf("Expected 100 entries to be invalid")
+	}
+
+	// a branch to the next instruction is taken though the pc is the same either way, so predicting it not taken
+	// is wrong and it flushes the pipeline like any taken branch, with or without a predictor
+	const nextSource = `
+.text
+	beq x0, x0, Next
+Next:
+	jalr x0, x1, 0
+`
+	for _, predictor := range []*emulator.BranchPredictorConfig{nil, {Type: emulator.PredictNotTaken}} {
+		inst := newTestEmulator(t, nextSource, func(c *emulator.EmulatorConfig) {
+			c.Pipeline = &emulator.PipelineConfig{Forwarding: true}
+			c.BranchPredictor = predictor
+		})
+		inst.run(t)
+
+		if pipeline := inst.GetPipelineStats(nil, 0); pipeline.FlushCycles != 4 {
+			t.Errorf("Expected the taken branch and the jalr to flush 4 cycles with the %+v predictor, got %d", predictor, pipeline.FlushCycles)
+		}
+		if predictor != nil {
+			sites := []emulator.BranchSiteReport{{Line: 3, Predictions: 1, Correct: 0}}
+			if report := inst.GetBranchStats(inst.program, testTextAddress); fmt.Sprint(report.Sites) != fmt.Sprint(sites) {
+				t.Errorf("Expected the branch to the next instruction to be mispredicted as not taken, got %+v", *report)
+			}
+		}
+	}
+}
+
+type testPeripheral struct {
+	ticks   uint32
+	written []uint32
+}
+
+func (p *testPeripheral) Range() (uint32, uint32) { return 0x80000000, 0x8000000F }
+
+func (p *testPeripheral) Read(inst *emulator.EmulatorInstance, offset uint32) (uint32, bool) {
+	return p.ticks, offset == 4
+}
+
+func (p *testPeripheral) Write(inst *emulator.EmulatorInstance, offset, bitmask, value uint32) bool {
+	p.written = append(p.written, value)
+	return offset == 0 || offset == 8
+}
+
+func (p *testPeripheral) Reset(inst *emulator.EmulatorInstance) { *p = testPeripheral{} }
+func (p *testPeripheral) Tick(inst *emulator.EmulatorInstance)  { p.ticks++ }
+
+// A device attached to the bus sees the stores to its range and ticks once per instruction
+func TestPeripheral(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80000
+	addi x6, x0, 42
+	sw x6, 0(x5)
+	lw x7, 4(x5)
+	sw x7, 8(x5)
+	jalr x0, x1, 0
+`)
+
+	device := &testPeripheral{ticks: 100}
+	if e := inst.AddPeripheral(device); e != nil {
+		t.Fatalf("Expected the peripheral to be attached, got %s", e)
+	}
+	if device.ticks != 0 {
+		t.Errorf("Expected the peripheral to be reset when attached")
+	}
+	if e := inst.AddPeripheral(&overlappingPeripheral{}); e == nil {
+		t.Errorf("Expected a peripheral overlapping the keyboard to be rejected")
+	}
+
+	inst.run(t)
+
+	// the load is the fourth instruction
+	if fmt.Sprint(device.written) != "[42 4]" || device.ticks != 6 {
+		t.Errorf("Expected the peripheral to see the stores 42 and 4 and 6 ticks, got %v and %d ticks", device.written, device.ticks)
+	}
+}
+
+type overlappingPeripheral struct{ testPeripheral }
+
+func (p *overlappingPeripheral) Range() (uint32, uint32) { return 0x80002D00, 0x80002E03 }
+
+// Everything is drawn partly off the 64x64 display, so only the pixels and regions on screen change
+func TestDrawCommands(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 64
+	sw x6, 8(x5)
+	sw x6, 12(x5)
+	addi x5, x5, -32
+	addi x6, x0, -4
+	sw x6, 16(x5)
+	addi x6, x0, 62
+	sw x6, 20(x5)
+	addi x6, x0, 8
+	sw x6, 24(x5)
+	sw x6, 28(x5)
+	addi x6, x0, 255
+	sw x6, 12(x5)
+	addi x6, x0, -10
+	sw x6, 16(x5)
+	addi x6, x0, 5
+	sw x6, 20(x5)
+	sw x6, 28(x5)
+	addi x6, x0, 70
+	sw x6, 24(x5)
+	addi x6, x0, 2
+	sw x6, 4(x5)
+	addi x6, x0, 1
+	sw x6, 0(x5)
+	addi x6, x0, 20
+	sw x6, 16(x5)
+	sw x6, 20(x5)
+	sw x0, 24(x5)
+	addi x6, x0, 3
+	sw x6, 4(x5)
+	sw gp, 8(x5)
+	addi x6, x0, 7
+	sw x6, 0(x5)
+	addi x6, x0, 63
+	sw x6, 16(x5)
+	sw x6, 20(x5)
+	addi x6, x0, 2
+	sw x6, 24(x5)
+	sw x6, 28(x5)
+	addi x6, gp, 4
+	sw x6, 8(x5)
+	addi x6, x0, 5
+	sw x6, 0(x5)
+	jalr x0, x1, 0
+.data
+Text: .ascii "A"
+Sprite: .word 0x11223344, 5, 6, 7
+`)
+	inst.run(t)
+
+	// the rectangle is in the bottom left region, the line goes across the top regions, the A is in the
+	// second region of the second row, and the only pixel of the blit on screen is in the bottom right one
+	pixels := map[[2]int]uint32{}
+	regions := []string{}
+	for _, update := range inst.GetDisplay().GetUpdates() {
+		regions = append(regions, fmt.Sprintf("%d,%d", update.RegionX, update.RegionY))
+		for i, pixel := range update.Data {
+			pixels[[2]int{update.RegionX + i%16, update.RegionY + i/16}] = pixel
+		}
+	}
+	if strings.Join(regions, " ") != "0,0 16,0 32,0 48,0 16,16 0,48 48,48" {
+		t.Errorf("Expected the regions drawn to be updated, got %v", regions)
+	}
+
+	expected := []struct {
+		x, y  int
+		pixel uint32
+	}{
+		{3, 63, 255}, {4, 63, 0}, {0, 61, 0}, // rectangle
+		{0, 5, 2}, {63, 5, 2}, {0, 4, 0}, // line
+		{20, 21, 3}, {20, 20, 0}, {21, 20, 3}, {24, 21, 3}, {25, 21, 0}, // the left and right column of the A
+		{63, 63, 0x11223344}, // blit
+	}
+	for _, e := range expected {
+		if pixels[[2]int{e.x, e.y}] != e.pixel {
+			t.Errorf("Expected pixel %d,%d to be 0x%X, got 0x%X", e.x, e.y, e.pixel, pixels[[2]int{e.x, e.y}])
+		}
+	}
+}
+
+// A program drawing to the back buffer only changes the screen when it presents the frame
+func TestDoubleBuffering(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 64
+	sw x6, 8(x5)
+	sw x6, 12(x5)
+	lui x7, 0x80010
+	addi x6, x0, 7
+	sw x6, 8(x7)
+	addi x5, x5, -256
+	addi x6, x0, 1
+	sw x6, 0(x5)
+	lw x8, 8(x7)
+	sw x8, 12(gp)
+	addi x6, x0, 5
+	sw x6, 0(x7)
+	lw x8, 0(x7)
+	sw x8, 0(gp)
+Wait:
+	lw x6, 12(x5)
+	beq x6, x0, Wait
+	sw x0, 4(x5)
+	lw x8, 8(x5)
+	sw x8, 4(gp)
+	lw x8, 12(x5)
+	sw x8, 8(gp)
+	addi x6, x0, 9
+	sw x6, 64(x7)
+	jalr x0, x1, 0
+.data
+Results: .word 0, 0, 0, 0
+`)
+	inst.run(t)
+
+	// the program reads back the pixel it drew, waits for a refresh, presents, and reads the frame counter
+	// and the vsync status, which the present cleared. The back buffer starts with the pixel drawn before
+	// double buffering was enabled.
+	for i, expected := range []uint32{5, 1, 0, 7} {
+		if result := inst.word(uint32(i * 4)); result != expected {
+			t.Errorf("Expected result %d to be %d, got %d", i, expected, result)
+		}
+	}
+
+	// the pixel drawn after the present is not on screen yet
+	updates := inst.GetDisplay().GetUpdates()
+	if len(updates) != 1 || updates[0].RegionX != 0 || updates[0].RegionY != 0 {
+		t.Fatalf("Expected only the presented region to be updated, got %d updates", len(updates))
+	}
+	if updates[0].Data[0] != 5 || updates[0].Data[1] != 0 || updates[0].Data[2] != 7 {
+		t.Errorf("Expected the presented pixels to be 5, 0, and 7, got %v", updates[0].Data[:3])
+	}
+}
+
+// The PNG of the display has the pixels on screen, with the alpha the program gave them
+func TestScreenshot(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 32
+	sw x6, 8(x5)
+	addi x6, x0, 16
+	sw x6, 12(x5)
+	lui x7, 0x80010
+	addi x6, x0, 255
+	lui x8, 0xFF000
+	or x6, x6, x8
+	sw x6, 4(x7)
+	lui x6, 0x80FF0
+	sw x6, 128(x7)
+	addi x6, x0, 1
+	sw x6, -256(x5)
+	sw x6, 8(x7)
+	jalr x0, x1, 0
+`)
+	inst.run(t)
+
+	b := bytes.Buffer{}
+	if e := inst.GetDisplay().WritePNG(&b); e != nil {
+		t.Fatalf("Failed to write the PNG: %v", e)
+	}
+	img, e := png.Decode(&b)
+	if e != nil {
+		t.Fatalf("Failed to decode the PNG: %v", e)
+	}
+	if img.Bounds().Dx() != 32 || img.Bounds().Dy() != 16 {
+		t.Fatalf("Expected the PNG to be 32x16, got %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	// red is the lowest byte of a pixel, and the pixel drawn to the back buffer was never presented
+	expected := []struct {
+		x, y  int
+		pixel color.NRGBA
+	}{
+		{1, 0, color.NRGBA{R: 255, A: 255}},
+		{0, 1, color.NRGBA{B: 255, A: 128}},
+		{2, 0, color.NRGBA{}},
+	}
+	for _, e := range expected {
+		if pixel := color.NRGBAModel.Convert(img.At(e.x, e.y)); pixel != e.pixel {
+			t.Errorf("Expected pixel %d,%d to be %v, got %v", e.x, e.y, e.pixel, pixel)
+		}
+	}
+}
+
+// A 20x18 display is four tiles, three of which cross the right or bottom edge. Every pixel is its index plus
+// one, and the encoded tiles have to decode to those pixels with zeros past the display, compressed or not.
+func TestDisplayTiles(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 20
+	sw x6, 8(x5)
+	addi x6, x0, 18
+	sw x6, 12(x5)
+	lui x7, 0x80010
+	addi x8, x0, 1
+	addi x9, x0, 361
+Fill:
+	sw x8, 0(x7)
+	addi x7, x7, 4
+	addi x8, x8, 1
+	bne x8, x9, Fill
+	jalr x0, x1, 0
+`)
+	inst.run(t)
+	updates := inst.GetDisplay().GetEntireScreen()
+
+	for _, compress := range []bool{false, true} {
+		message, e := emulator.EncodeDisplayTiles(20, 18, updates, compress)
+		if e != nil {
+			t.Fatalf("Failed to encode the tiles: %v", e)
+		}
+
+		if compressed := message[0]&1 != 0; compressed != compress {
+			t.Fatalf("Expected the compressed flag to be %t, got %t", compress, compressed)
+		}
+		body := message[1:]
+		if compress {
+			r, e := zlib.NewReader(bytes.NewReader(body))
+			if e != nil {
+				t.Fatalf("Failed to decompress the tiles: %v", e)
+			}
+			if body, e = io.ReadAll(r); e != nil {
+				t.Fatalf("Failed to decompress the tiles: %v", e)
+			}
+		}
+
+		header := [3]uint32{}
+		for i := range header {
+			header[i] = binary.LittleEndian.Uint32(body[i*4:])
+		}
+		if header != [3]uint32{20, 18, 4} {
+			t.Fatalf("Expected a 20x18 display with 4 tiles, got %dx%d with %d", header[0], header[1], header[2])
+		}
+		if length := 12 + 4*(8+16*16*4); len(body) != length {
+			t.Fatalf("Expected %d bytes after the flags, got %d", length, len(body))
+		}
+
+		for i, corner := range [][2]uint32{{0, 0}, {16, 0}, {0, 16}, {16, 16}} {
+			tile := body[12+i*(8+16*16*4):]
+			x, y := binary.LittleEndian.Uint32(tile), binary.LittleEndian.Uint32(tile[4:])
+			if x != corner[0] || y != corner[1] {
+				t.Errorf("Expected tile %d to be at %d, %d, got %d, %d", i, corner[0], corner[1], x, y)
+				continue
+			}
+
+			for j := uint32(0); j < 16*16; j++ {
+				px, py := x+j%16, y+j/16
+				expected := uint32(0)
+				if px < 20 && py < 18 {
+					expected = py*20 + px + 1
+				}
+				if pixel := binary.LittleEndian.Uint32(tile[8+j*4:]); pixel != expected {
+					t.Errorf("Expected pixel %d, %d to be %d, got %d (compressed %t)", px, py, expected, pixel, compress)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Files can only be created and saved inside the mount root, opening "../escape.txt" for writing fails with
+// EACCES and a mounted file whose path leaves the root is not saved
+func TestFileSystemPaths(t *testing.T) {
+	source := `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 1
+	sw x6, 0(x5)
+	addi x10, gp, 0
+	addi x11, x0, 0x201
+	addi x17, x0, 1024
+	ecall
+	sw x10, 16(gp)
+	lui x5, 0x80800
+	lw x6, 4(x5)
+	add x6, x5, x6
+	sw x0, 0(x6)
+	jalr x0, x1, 0
+.data
+Path: .ascii "../escape.txt"
+Result: .word 0
+`
+	if newTestEmulator(t, source).GetFileSystem() != nil {
+		t.Errorf("Expected no filesystem unless one is configured")
+	}
+
+	fs := emulator.NewVirtualFileSystem()
+	fs.AddFile("../escaped.txt", []byte("data"))
+	inst := newTestEmulator(t, source, func(config *emulator.EmulatorConfig) {
+		config.FileSystem = fs
+	})
+	inst.run(t)
+
+	if result := int32(inst.word(16)); result != -13 {
+		t.Errorf("Expected opening ../escape.txt to fail with EACCES (-13), got %d", result)
+	}
+
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output")
+	if e := inst.GetFileSystem().SaveModifiedFiles(outputPath); e == nil {
+		t.Errorf("Expected saving ../escaped.txt to be rejected")
+	}
+	if _, e := os.Stat(filepath.Join(dir, "escaped.txt")); e == nil {
+		t.Errorf("Expected nothing to be written outside of the output directory")
+	}
+}
+
+// Sums 1500 down to 1 into Total, keeps every running total in Totals, and draws each to the first pixel of
+// the 64x64 display and to the pixel after the last one, which runs past a few journal checkpoints
+const reverseSource = `
+.text
+	lui x7, 0x80003
+	addi x8, x0, 64
+	sw x8, 8(x7)
+	sw x8, 12(x7)
+	addi x5, x0, 1500
+	addi x10, x0, 0
+	lui x6, 0x80010
+	addi x9, x6, 0
+	addi x11, gp, 4
+loop:
+	add x10, x10, x5
+	sw x10, 0(gp)
+	sw x10, 0(x11)
+	sw x10, 0(x6)
+	sw x10, 4(x9)
+	addi x9, x9, 4
+	addi x11, x11, 4
+	addi x5, x5, -1
+	bne x5, x0, loop
+	jalr x0, x1, 0
+.data
+Total: .word 0
+Totals: .space 6000
+`
+
+const reverseLoopAddress = testTextAddress + 36
+
+// Stepping back and reverse continuing have to land on the state forward execution had at the same instruction
+func TestReverseDebugging(t *testing.T) {
+	inst := newTestEmulator(t, reverseSource)
+	inst.EnableJournal()
+	inst.AddBreakpoint(reverseLoopAddress, emulator.Breakpoint{ID: 1})
+
+	hits := 0
+	var stops []uint32 // the instructions stepping back and then reverse continuing stopped at
+	states := map[uint32][]byte{}
+	inst.SetBreakCallback(func(inst *emulator.EmulatorInstance, breakpointID int, reason string) {
+		switch len(stops) {
+		case 0:
+			hits++
+			if hits == 1200 {
+				stops = append(stops, 0) // the step back has not stopped yet
+				if e := inst.StepBack(); e != nil {
+					t.Fatalf("Failed to step back: %v", e)
+				}
+			}
+		case 1:
+			stops[0] = inst.GetDynamicInstructionCount()
+			states[stops[0]] = saveSnapshot(t, inst)
+			stops = append(stops, 0)
+			if e := inst.ReverseContinue(); e != nil {
+				t.Fatalf("Failed to reverse continue: %v", e)
+			}
+		case 2:
+			stops[1] = inst.GetDynamicInstructionCount()
+			states[stops[1]] = saveSnapshot(t, inst)
+			stops = append(stops, 0)
+		}
+	})
+	inst.run(t)
+
+	// the loop starts on the tenth instruction, and is nine instructions long
+	expectedStops := []uint32{9 + 1199*9, 9 + 1198*9 + 1}
+	if len(stops) != 3 || stops[0] != expectedStops[0] || stops[1] != expectedStops[1] {
+		t.Fatalf("Expected to stop after stepping back and reverse continuing at %v, got %v", expectedStops, stops[:len(stops)-1])
+	}
+	if total := inst.word(0); total != 1500*1501/2 {
+		t.Errorf("Expected the total to be %d after going back, got %d", 1500*1501/2, total)
+	}
+
+	// running forward an instruction at a time without the journal
+	reference := newTestEmulator(t, reverseSource)
+	reference.AddBreakpoint(testTextAddress, emulator.Breakpoint{ID: 1})
+	reference.SetBreakCallback(func(inst *emulator.EmulatorInstance, breakpointID int, reason string) {
+		if state, ok := states[inst.GetDynamicInstructionCount()]; ok && !bytes.Equal(state, saveSnapshot(t, inst)) {
+			t.Errorf("Expected the state after going back to instruction %d to match running forward to it", inst.GetDynamicInstructionCount())
+		}
+		inst.BreakNext()
+	})
+	reference.run(t)
+
+	if !bytes.Equal(saveSnapshot(t, inst.EmulatorInstance), saveSnapshot(t, reference.EmulatorInstance)) {
+		t.Errorf("Expected the program to end in the same state after going back")
+	}
+}
+
+// The results are stored in order after the operands. The fused multiply-add is 1 + 2^-11 + 2^-24, halfway
+// between two floats, plus 2^-80, which float64 drops, so rounding the float64 result again would round down to
+// the even float instead of up. There is no NaN boxing since FLEN is 32, but NaN results are canonical while
+// moves keep the payload.
+func TestFloat(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	flw f1, 0(gp)
+	flw f2, 4(gp)
+	fmadd.s f3, f1, f1, f2
+	fsw f3, 20(gp)
+	csrr x5, fflags
+	sw x5, 24(gp)
+	csrw fflags, x0
+	flw f4, 8(gp)
+	fcvt.w.s x6, f4, rtz
+	sw x6, 28(gp)
+	fneg.s f5, f4
+	fcvt.w.s x6, f5, rtz
+	sw x6, 32(gp)
+	fcvt.wu.s x6, f5, rtz
+	sw x6, 36(gp)
+	csrr x5, fflags
+	sw x5, 40(gp)
+	csrw fflags, x0
+	flw f6, 12(gp)
+	fcvt.w.s x6, f6, rne
+	sw x6, 44(gp)
+	fcvt.w.s x6, f6, rmm
+	sw x6, 48(gp)
+	fcvt.w.s x6, f6, rdn
+	sw x6, 52(gp)
+	fcvt.w.s x6, f6, rup
+	sw x6, 56(gp)
+	flw f7, 16(gp)
+	fadd.s f8, f7, f6
+	fsw f8, 60(gp)
+	fmv.x.w x6, f7
+	sw x6, 64(gp)
+	csrr x5, fflags
+	sw x5, 68(gp)
+	jalr x0, x1, 0
+.data
+Operands: .word 0x3F800800, 0x17800000, 0x4F32D05E, 0x40200000, 0x7F800001
+Results: .space 52
+`)
+	inst.run(t)
+
+	expected := []struct {
+		name  string
+		value uint32
+	}{
+		{"fmadd.s of (1 + 2^-12)^2 + 2^-80", 0x3F801001},
+		{"fflags after the inexact fmadd.s", 0x01},
+		{"fcvt.w.s of 3e9", 0x7FFFFFFF},
+		{"fcvt.w.s of -3e9", 0x80000000},
+		{"fcvt.wu.s of -3e9", 0},
+		{"fflags after the saturating conversions", 0x10},
+		{"fcvt.w.s of 2.5 rounding to nearest even", 2},
+		{"fcvt.w.s of 2.5 rounding to nearest max magnitude", 3},
+		{"fcvt.w.s of 2.5 rounding down", 2},
+		{"fcvt.w.s of 2.5 rounding up", 3},
+		{"fadd.s of a signaling NaN", 0x7FC00000},
+		{"fmv.x.w of a signaling NaN", 0x7F800001},
+		{"fflags after the inexact conversions and the signaling NaN", 0x11},
+	}
+	for i, e := range expected {
+		if result := inst.word(uint32(20 + i*4)); result != e.value {
+			t.Errorf("Expected %s to be 0x%08X, got 0x%08X", e.name, e.value, result)
+		}
+	}
+
+	// x5, x6, and f1 to f8
+	if usage := inst.RegisterUsage(); usage != 10 {
+		t.Errorf("Expected 10 registers to be counted as used, got %d", usage)
+	}
+
+	// the misaligned flws fault and do not write f1, which is only counted once the last flw writes it
+	inst = newTestEmulator(t, `
+.text
+	jal x0, Main
+Handler:
+	csrr x30, mepc
+	addi x30, x30, 4
+	csrw mepc, x30
+	mret
+Main:
+	lui x5, 1
+	addi x5, x5, 4
+	csrw mtvec, x5
+	flw f1, 1(gp)
+	flw f1, 1(gp)
+	flw f1, 0(gp)
+	jalr x0, x1, 0
+.data
+Value: .word 0x3F800000
+`, func(config *emulator.EmulatorConfig) {
+		config.TrapMode = true
+	})
+	inst.run(t)
+
+	// x5, x30, and f1
+	if usage := inst.RegisterUsage(); usage != 3 {
+		t.Errorf("Expected 3 registers to be counted as used after the faulting flw, got %d", usage)
+	}
+}
+
+// Builds a FAT12 image with 512 byte sectors and clusters, one reserved sector, two FATs, a one sector root
+// directory, and 60 clusters. The root has a volume label, a deleted file, hello.txt (a lowercase short name)
+// spanning clusters 2 and 5, and DOCS, which has "Long Name.md" under a long file name.
+func writeFATImage(tb testing.TB, hello []byte) string {
+	tb.Helper()
+	const sector = 512
+	image := make([]byte, 64*sector)
+
+	// boot sector
+	binary.LittleEndian.PutUint16(image[11:], sector)
+	image[13] = 1 // sectors per cluster
+	binary.LittleEndian.PutUint16(image[14:], 1)
+	image[16] = 2 // FATs
+	binary.LittleEndian.PutUint16(image[17:], 16)
+	binary.LittleEndian.PutUint16(image[19:], 64)
+	binary.LittleEndian.PutUint16(image[22:], 1)
+	image[510], image[511] = 0x55, 0xAA
+
+	// the cluster chains, each 12 bit entry shares a byte with its neighbor
+	setFAT := func(cluster, value uint32) {
+		for _, fat := range []int{sector, 2 * sector} {
+			offset := fat + int(cluster+cluster/2)
+			if cluster&1 == 0 {
+				image[offset] = byte(value)
+				image[offset+1] = image[offset+1]&0xF0 | byte(value>>8)&0x0F
+			} else {
+				image[offset] = image[offset]&0x0F | byte(value<<4)
+				image[offset+1] = byte(value >> 4)
+			}
+		}
+	}
+	setFAT(2, 5)
+	setFAT(5, 0xFFF)
+	setFAT(3, 0xFFF)
+	setFAT(4, 0xFFF)
+
+	dataStart := 4 * sector
+	cluster := func(n int) []byte {
+		return image[dataStart+(n-2)*sector : dataStart+(n-1)*sector]
+	}
+	entry := func(dir []byte, i int, name string, attributes byte, firstCluster uint16, size uint32) []byte {
+		e := dir[i*32 : (i+1)*32]
+		copy(e, fmt.Sprintf("%-11s", name))
+		e[11] = attributes
+		binary.LittleEndian.PutUint16(e[26:], firstCluster)
+		binary.LittleEndian.PutUint32(e[28:], size)
+		return e
+	}
+
+	root := image[3*sector : 4*sector]
+	entry(root, 0, "EMULATOR", 0x08, 0, 0)
+	entry(root, 1, "OLD     TXT", 0, 6, 3)[0] = 0xE5
+	entry(root, 2, "HELLO   TXT", 0, 2, uint32(len(hello)))[12] = 0x18
+	entry(root, 3, "DOCS", 0x10, 3, 0)
+	copy(cluster(2), hello)
+	copy(cluster(5), hello[sector:])
+
+	docs := cluster(3)
+	entry(docs, 0, ".", 0x10, 3, 0)
+	entry(docs, 1, "..", 0x10, 0, 0)
+	longName := entry(docs, 2, "", 0x0F, 0, 0)
+	longName[0] = 0x41 // the first and last part of the name
+	chars := utf16.Encode([]rune("Long Name.md\x00"))
+	for i, offset := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+		binary.LittleEndian.PutUint16(longName[offset:], chars[i])
+	}
+	entry(docs, 3, "LONGNA~1MD", 0, 4, 5)
+	copy(cluster(4), "notes")
+
+	path := filepath.Join(tb.TempDir(), "disk.img")
+	if e := os.WriteFile(path, image, 0644); e != nil {
+		tb.Fatalf("Failed to write the FAT image: %v", e)
+	}
+	return path
+}
+
+func TestFATImage(t *testing.T) {
+	hello := bytes.Repeat([]byte("hello, world\n"), 50) // 700 bytes, so it needs both of its clusters
+	fs, e := emulator.MountFileSystem(writeFATImage(t, hello))
+	if e != nil {
+		t.Fatalf("Failed to mount the FAT image: %v", e)
+	}
+
+	files := fs.GetFiles()
+	if len(files) != 2 {
+		t.Errorf("Expected only hello.txt and DOCS/Long Name.md, got %d files", len(files))
+	}
+	if !bytes.Equal(files["hello.txt"], hello) {
+		t.Errorf("Expected hello.txt to be read from its cluster chain, got %d bytes", len(files["hello.txt"]))
+	}
+	if string(files["DOCS/Long Name.md"]) != "notes" {
+		t.Errorf("Expected DOCS/Long Name.md to contain notes, got %q", files["DOCS/Long Name.md"])
+	}
+
+	// a chain that loops back on itself
+	image, _ := os.ReadFile(writeFATImage(t, hello))
+	image[512+7] = image[512+7]&0x0F | 0x50 // cluster 5 points to cluster 5
+	image[512+8] = 0
+	path := filepath.Join(t.TempDir(), "loop.img")
+	os.WriteFile(path, image, 0644)
+	if _, e := emulator.MountFATImage(path); e == nil {
+		t.Errorf("Expected a cluster chain that loops to be rejected")
+	}
+
+	image[510] = 0
+	os.WriteFile(path, image, 0644)
+	if _, e := emulator.MountFATImage(path); e == nil {
+		t.Errorf("Expected an image without the boot sector signature to be rejected")
+	}
+}
+
+// Opens hello.txt and reads it, then makes the calls that fail, and creates out.txt with openat and writes to
+// it. The results are stored in order after the buffer.
+func TestFileSystemCalls(t *testing.T) {
+	fs := emulator.NewVirtualFileSystem()
+	fs.AddFile("hello.txt", []byte("hello, world"))
+	inst := newTestEmulator(t, `
+.text
+	lui x5, 0x80003
+	addi x6, x0, 1
+	sw x6, 0(x5)
+	addi x10, gp, 0
+	addi x11, x0, 0
+	addi x17, x0, 1024
+	ecall
+	sw x10, 40(gp)
+	addi x20, x10, 0
+	addi x10, x0, 5
+	addi x11, gp, 32
+	addi x12, x20, 0
+	addi x17, x0, 63
+	ecall
+	sw x10, 44(gp)
+	addi x10, x0, 1
+	addi x11, gp, 32
+	addi x12, x20, 0
+	addi x17, x0, 64
+	ecall
+	sw x10, 48(gp)
+	addi x10, x20, 0
+	addi x11, x0, 0
+	addi x12, x0, 7
+	addi x17, x0, 62
+	ecall
+	sw x10, 52(gp)
+	addi x10, x20, 0
+	addi x17, x0, 57
+	ecall
+	sw x10, 56(gp)
+	addi x10, gp, 12
+	addi x11, x0, 0
+	addi x17, x0, 1024
+	ecall
+	sw x10, 60(gp)
+	addi x10, gp, 0
+	lui x11, 1
+	addi x11, x11, -1535
+	addi x17, x0, 1024
+	ecall
+	sw x10, 64(gp)
+	addi x10, x0, -100
+	addi x11, gp, 24
+	addi x12, x0, 0x201
+	addi x17, x0, 56
+	ecall
+	sw x10, 68(gp)
+	addi x21, x10, 0
+	addi x10, x0, 5
+	addi x11, gp, 32
+	addi x12, x21, 0
+	addi x17, x0, 64
+	ecall
+	sw x10, 72(gp)
+	addi x10, x0, 1
+	addi x11, gp, 32
+	addi x12, x21, 0
+	addi x17, x0, 63
+	ecall
+	sw x10, 76(gp)
+	addi x10, x21, 0
+	addi x17, x0, 57
+	ecall
+	sw x10, 80(gp)
+	jalr x0, x1, 0
+.data
+Hello: .ascii "hello.txt"
+Missing: .ascii "missing.txt"
+Out: .ascii "out.txt"
+Buffer: .space 8
+Results: .space 44
+`, func(config *emulator.EmulatorConfig) {
+		config.FileSystem = fs
+	})
+	inst.run(t)
+
+	expected := []struct {
+		name  string
+		value int32
+	}{
+		{"opening hello.txt", 3},
+		{"reading 5 bytes", 5},
+		{"writing to a read only file", -9},     // EBADF
+		{"seeking from an invalid whence", -22}, // EINVAL
+		{"closing hello.txt", 0},
+		{"opening a missing file", -2},                 // ENOENT
+		{"exclusively creating an existing file", -17}, // EEXIST
+		{"creating out.txt with openat", 3},
+		{"writing 5 bytes", 5},
+		{"reading from a write only file", -9},
+		{"closing out.txt", 0},
+	}
+	for i, e := range expected {
+		if result := int32(inst.word(uint32(40 + i*4))); result != e.value {
+			t.Errorf("Expected %s to return %d, got %d", e.name, e.value, result)
+		}
+	}
+
+	if buffer := inst.word(32); buffer != binary.LittleEndian.Uint32([]byte("hell")) {
+		t.Errorf("Expected the buffer to start with the bytes read, got 0x%08X", buffer)
+	}
+	files := inst.GetFileSystem().GetModifiedFiles()
+	if len(files) != 1 || string(files["out.txt"]) != "hello" {
+		t.Errorf("Expected only out.txt to be modified and to contain hello, got %v", files)
+	}
+	if string(fs.GetFiles()["hello.txt"]) != "hello, world" || len(fs.GetFiles()) != 1 {
+		t.Errorf("Expected the configured filesystem to be unchanged")
+	}
+}
+
+// The handler records mcause, mepc, mtval, and mstatus of every trap after the trap count, and returns to
+// the instruction after the one that trapped. mtvec is in vectored mode, which synchronous traps ignore, so
+// they all go to the handler at its base address.
+func TestTraps(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	jal x0, Main
+Handler:
+	lw x28, 0(gp)
+	slli x29, x28, 4
+	add x29, x29, gp
+	csrr x30, mcause
+	sw x30, 4(x29)
+	csrr x30, mepc
+	sw x30, 8(x29)
+	csrr x30, mtval
+	sw x30, 12(x29)
+	csrr x30, mstatus
+	sw x30, 16(x29)
+	addi x28, x28, 1
+	sw x28, 0(gp)
+	csrr x30, mepc
+	addi x30, x30, 4
+	csrw mepc, x30
+	mret
+Main:
+	lui x5, 1
+	addi x5, x5, 5
+	csrw mtvec, x5
+	csrsi mstatus, 8
+	ecall
+	csrw cycle, x5
+	lw x6, 1(gp)
+	sw x5, 2(gp)
+	csrr x7, mstatus
+	sw x7, 68(gp)
+	jalr x0, x1, 0
+.data
+Traps: .space 68
+Status: .word 0
+`, func(config *emulator.EmulatorConfig) {
+		config.TrapMode = true
+	})
+	inst.run(t)
+
+	if count := inst.word(0); count != 4 {
+		t.Fatalf("Expected 4 traps, got %d", count)
+	}
+
+	// in the handler, interrupts are disabled, MPIE has the enabled state, and MPP is machine mode
+	const handlerStatus = 0x1880
+	expected := []struct {
+		name                     string
+		cause, epc, tval, status uint32
+	}{
+		{"ecall", 11, 0x1058, 0, handlerStatus},
+		{"csrw cycle", 2, 0x105C, inst.program.ProgramText[23], handlerStatus},
+		{"misaligned lw", 4, 0x1060, inst.dataAddress + 1, handlerStatus},
+		{"misaligned sw", 6, 0x1064, inst.dataAddress + 2, handlerStatus},
+	}
+	for i, e := range expected {
+		offset := uint32(4 + i*16)
+		cause, epc, tval, status := inst.word(offset), inst.word(offset+4), inst.word(offset+8), inst.word(offset+12)
+		if cause != e.cause || epc != e.epc || tval != e.tval || status != e.status {
+			t.Errorf("Expected %s to trap with mcause %d, mepc 0x%X, mtval 0x%X, and mstatus 0x%X, got %d, 0x%X, 0x%X, and 0x%X",
+				e.name, e.cause, e.epc, e.tval, e.status, cause, epc, tval, status)
+		}
+	}
+
+	// mret enabled interrupts again
+	if status := inst.word(68); status != 0x1888 {
+		t.Errorf("Expected mstatus to be 0x1888 after the last mret, got 0x%X", status)
+	}
+
+	// the faulting lw does not write x6, so only x5, x7, and the three handler registers count as used
+	if usage := inst.RegisterUsage(); usage != 5 {
+		t.Errorf("Expected 5 registers to be used, got %d", usage)
+	}
+}
+
+// Each instruction stores the old value it read, then the last value is read with csrr
+func TestCSR(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	addi x7, x0, 0x0F0
+	csrrw x8, mscratch, x7
+	sw x8, 0(gp)
+	addi x7, x0, 0x00F
+	csrrs x8, mscratch, x7
+	sw x8, 4(gp)
+	addi x7, x0, 0x0C3
+	csrrc x8, mscratch, x7
+	sw x8, 8(gp)
+	csrrsi x8, mscratch, 1
+	sw x8, 12(gp)
+	csrrci x8, mscratch, 0x0C
+	sw x8, 16(gp)
+	csrrwi x8, mscratch, 0x15
+	sw x8, 20(gp)
+	csrr x8, mscratch
+	sw x8, 24(gp)
+	csrw misa, x0
+	csrr x8, misa
+	sw x8, 28(gp)
+	jalr x0, x1, 0
+.data
+Results: .space 32
+`)
+	inst.run(t)
+
+	expected := []struct {
+		name  string
+		value uint32
+	}{
+		{"csrrw", 0},
+		{"csrrs", 0x0F0},
+		{"csrrc", 0x0FF},
+		{"csrrsi", 0x03C},
+		{"csrrci", 0x03D},
+		{"csrrwi", 0x031},
+		{"csrr after csrrwi", 0x015},
+		{"misa, which ignores writes", 0x40001125},
+	}
+	for i, e := range expected {
+		if result := inst.word(uint32(i * 4)); result != e.value {
+			t.Errorf("Expected %s to read 0x%03X, got 0x%03X", e.name, e.value, result)
+		}
+	}
+}
+
+// The OS setup registers the handler and raises a software interrupt, which has to wait until the program
+// starts. The program then raises one that is delivered before its next instruction, one while every interrupt
+// is masked, which is discarded, and runs with the timer interrupt every 10 instructions.
+const interruptSource = `
+.text
+Handler:
+	lui x5, 0x80003
+	lw x6, 28(x5)
+	slli x6, x6, 2
+	add x6, x6, gp
+	lw x7, 24(x6)
+	addi x7, x7, 1
+	sw x7, 24(x6)
+	jalr x0, x1, 0
+Setup:
+	lui x5, 0x80003
+	lui x6, 1
+	sw x6, 16(x5)
+	addi x6, x0, 6
+	sw x6, -48(x5)
+	addi x6, x0, 2
+	sw x6, -36(x5)
+	lw x7, 32(gp)
+	sw x7, 0(gp)
+	jalr x0, x1, 0
+Main:
+	lw x7, 32(gp)
+	sw x7, 4(gp)
+	lui x5, 0x80003
+	addi x6, x0, 2
+	sw x6, -36(x5)
+	lw x7, 32(gp)
+	sw x7, 8(gp)
+	sw x0, -48(x5)
+	sw x6, -36(x5)
+	lw x7, -40(x5)
+	sw x7, 12(gp)
+	addi x7, x0, 4
+	sw x7, -48(x5)
+	addi x8, x0, 0
+	lw x7, 32(gp)
+	sw x7, 16(gp)
+	sw x6, -48(x5)
+	addi x6, x0, 10
+	sw x6, -44(x5)
+	addi x7, x0, 100
+Spin:
+	addi x7, x7, -1
+	bne x7, x0, Spin
+	sw x0, -44(x5)
+	jalr x0, x1, 0
+.data
+Results: .space 24
+Counts: .space 20
+`
+
+func TestInterrupts(t *testing.T) {
+	labels := assembler.Assemble(interruptSource).Labels
+	setup, main := testTextAddress+labels["Setup"], testTextAddress+labels["Main"]
+	inst := newTestEmulator(t, interruptSource, func(config *emulator.EmulatorConfig) {
+		config.ProfileIgnoreRangeStart = testTextAddress
+		config.ProfileIgnoreRangeEnd = main
+		config.OSGlobalPointer = config.GlobalDataAddress
+	})
+
+	inst.Emulate(setup)
+	inst.Emulate(main)
+	if errors := inst.GetErrors(); len(errors) != 0 {
+		t.Fatalf("Expected the program to run without errors, got %d: %v", len(errors), errors)
+	}
+
+	expected := []struct {
+		name  string
+		value uint32
+	}{
+		{"software interrupts during the setup", 0},
+		{"software interrupts when the program starts", 1},
+		{"software interrupts after raising one", 2},
+		{"pending interrupts after raising a masked one", 0},
+		{"software interrupts after unmasking", 2},
+	}
+	for i, e := range expected {
+		if value := inst.word(uint32(i * 4)); value != e.value {
+			t.Errorf("Expected %d %s, got %d", e.value, e.name, value)
+		}
+	}
+
+	if timer := inst.word(28); timer != 20 {
+		t.Errorf("Expected 20 timer interrupts, got %d", timer)
+	}
+}
+
+// The OS setup enables the keyboard and mouse interrupts and the handler logs the ID and data of each, then the
+// program reads the keyboard and mouse registers after the events were sent
+const inputSource = `
+.text
+Handler:
+	lui x5, 0x80003
+	lw x6, 0(gp)
+	slli x7, x6, 4
+	add x7, x7, gp
+	lw x8, 28(x5)
+	sw x8, 4(x7)
+	lw x9, 32(x5)
+	sw x9, 8(x7)
+	addi x9, x0, 4
+	bne x8, x9, Logged
+	lw x9, 36(x5)
+	sw x9, 12(x7)
+	lw x9, 40(x5)
+	sw x9, 16(x7)
+Logged:
+	addi x6, x6, 1
+	sw x6, 0(gp)
+	jalr x0, x1, 0
+Setup:
+	lui x5, 0x80003
+	lui x6, 1
+	sw x6, 16(x5)
+	addi x6, x0, 0x18
+	sw x6, -48(x5)
+	jalr x0, x1, 0
+Main:
+	lui x5, 0x80003
+	addi x5, x5, -512
+	lw x6, 36(x5)
+	sw x6, 68(gp)
+	lw x6, 8(x5)
+	sw x6, 72(gp)
+	lw x6, 32(x5)
+	sw x6, 76(gp)
+	lw x6, 32(x5)
+	sw x6, 80(gp)
+	lw x6, 32(x5)
+	sw x6, 84(gp)
+	lw x6, 32(x5)
+	sw x6, 88(gp)
+	lw x6, 36(x5)
+	sw x6, 92(gp)
+	lw x6, 64(x5)
+	sw x6, 96(gp)
+	lw x6, 68(x5)
+	sw x6, 100(gp)
+	lw x6, 72(x5)
+	sw x6, 104(gp)
+	jalr x0, x1, 0
+.data
+Count: .word 0
+Log: .space 64
+Results: .space 40
+`
+
+func TestKeyboardAndMouse(t *testing.T) {
+	labels := assembler.Assemble(inputSource).Labels
+	setup, main := testTextAddress+labels["Setup"], testTextAddress+labels["Main"]
+	newEmulator := func() *testEmulator {
+		return newTestEmulator(t, inputSource, func(config *emulator.EmulatorConfig) {
+			config.ProfileIgnoreRangeStart = testTextAddress
+			config.ProfileIgnoreRangeEnd = main
+			config.OSGlobalPointer = config.GlobalDataAddress
+		})
+	}
+
+	inst := newEmulator()
+	inst.Emulate(setup)
+	inst.KeyEvent(65, true)
+	inst.KeyEvent(66, true)
+	inst.MouseEvent(10, -3, 5)
+	inst.KeyEvent(65, false)
+	inst.Emulate(main)
+	if errors := inst.GetErrors(); len(errors) != 0 {
+		t.Fatalf("Expected the program to run without errors, got %d: %v", len(errors), errors)
+	}
+
+	// the interrupts are delivered in the order the events were sent
+	if count := inst.word(0); count != 4 {
+		t.Fatalf("Expected 4 interrupts, got %d", count)
+	}
+	interrupts := []struct {
+		name string
+		data [4]uint32 // the ID and the data words
+	}{
+		{"pressing A", [4]uint32{emulator.InterruptIDKeyboard, 0x141}},
+		{"pressing B", [4]uint32{emulator.InterruptIDKeyboard, 0x142}},
+		{"moving the mouse", [4]uint32{emulator.InterruptIDMouse, 10, 0xFFFFFFFD, 5}},
+		{"releasing A", [4]uint32{emulator.InterruptIDKeyboard, 0x041}},
+	}
+	for i, e := range interrupts {
+		data := [4]uint32{}
+		for j := range data {
+			data[j] = inst.word(uint32(4 + i*16 + j*4))
+		}
+		if data != e.data {
+			t.Errorf("Expected the interrupt for %s to be %X, got %X", e.name, e.data, data)
+		}
+	}
+
+	registers := []struct {
+		name  string
+		value uint32
+	}{
+		{"the key event count", 3},
+		{"the state of keys 64 to 95, where only B is down", 0x4},
+		{"the first key event", 0x80000141},
+		{"the second key event", 0x80000142},
+		{"the third key event", 0x80000041},
+		{"the key event FIFO once empty", 0},
+		{"the key event count once empty", 0},
+		{"the mouse x", 10},
+		{"the mouse y", 0xFFFFFFFD},
+		{"the mouse buttons", 5},
+	}
+	for i, e := range registers {
+		if value := inst.word(uint32(68 + i*4)); value != e.value {
+			t.Errorf("Expected %s to be 0x%X, got 0x%X", e.name, e.value, value)
+		}
+	}
+
+	// the FIFO keeps the newest events, also while the keyboard interrupt is masked
+	inst = newEmulator()
+	for key := uint32(0); key < 40; key++ {
+		inst.KeyEvent(key, true)
+	}
+	inst.Emulate(main)
+	if count, first := inst.word(68), inst.word(76); count != 32 || first != 0x80000108 {
+		t.Errorf("Expected 32 key events starting with pressing key 8, got %d starting with 0x%X", count, first)
+	}
+}
+
+// Every AMO stores the value it read, which is 6 for all of them, and leaves the result of applying -3 to it
+func TestAtomics(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	addi x6, gp, 0
+	addi x7, x0, -3
+	amoswap.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amoadd.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amoxor.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amoand.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amoor.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amomin.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amomax.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amominu.w x8, x7, (x6)
+	sw x8, 36(x6)
+	addi x6, x6, 4
+	amomaxu.w x8, x7, (x6)
+	sw x8, 36(x6)
+	jalr x0, x1, 0
+.data
+Values: .word 6, 6, 6, 6, 6, 6, 6, 6, 6
+Old: .space 36
+`)
+	inst.run(t)
+
+	expected := []struct {
+		name  string
+		value uint32
+	}{
+		{"amoswap.w", 0xFFFFFFFD},
+		{"amoadd.w", 3},
+		{"amoxor.w", 0xFFFFFFFB},
+		{"amoand.w", 4},
+		{"amoor.w", 0xFFFFFFFF},
+		{"amomin.w", 0xFFFFFFFD},
+		{"amomax.w", 6},
+		{"amominu.w", 6},
+		{"amomaxu.w", 0xFFFFFFFD},
+	}
+	for i, e := range expected {
+		if value := inst.word(uint32(i * 4)); value != e.value {
+			t.Errorf("Expected %s to leave 0x%08X in memory, got 0x%08X", e.name, e.value, value)
+		}
+		if old := inst.word(uint32(36 + i*4)); old != 6 {
+			t.Errorf("Expected %s to return the old value 6, got %d", e.name, old)
+		}
+	}
+}
+
+// Hart 0 reserves the word with lr.w, then lets hart 1 store to the word the argument points to and park
+// before trying sc.w, which has to fail when hart 1 stored to the reserved word and succeed otherwise
+func TestReservations(t *testing.T) {
+	inst := newTestEmulator(t, `
+.text
+	jal x0, Main
+Worker:
+	sw x0, 0(a0)
+	jalr x0, x1, 0
+Main:
+	lui x5, 0x80003
+	addi x5, x5, -384
+	lui x6, 1
+	addi x6, x6, 4
+	sw x6, 8(x5)
+	addi x6, sp, -1024
+	sw x6, 16(x5)
+	addi x9, x0, 1
+	addi x7, x0, 5
+
+	lr.w x8, (gp)
+	sw gp, 12(x5)
+	sw x9, 20(x5)
+First:
+	lw x6, 24(x5)
+	bne x6, x9, First
+	sc.w x8, x7, (gp)
+	sw x8, 8(gp)
+
+	lr.w x8, (gp)
+	addi x6, gp, 4
+	sw x6, 12(x5)
+	sw x9, 20(x5)
+Second:
+	lw x6, 24(x5)
+	bne x6, x9, Second
+	sc.w x8, x7, (gp)
+	sw x8, 12(gp)
+	jalr x0, x1, 0
+.data
+Reserved: .word 7
+Other: .word 7
+Results: .space 8
+`, func(config *emulator.EmulatorConfig) {
+		config.Harts = 2
+	})
+	inst.run(t)
+
+	if result := inst.word(8); result != 1 {
+		t.Errorf("Expected sc.w to fail after the other hart stored to the reserved word, got %d", result)
+	}
+	if result := inst.word(12); result != 0 {
+		t.Errorf("Expected sc.w to succeed after the other hart stored to another word, got %d", result)
+	}
+	if reserved, other := inst.word(0), inst.word(4); reserved != 5 || other != 0 {
+		t.Errorf("Expected the reserved word to be 5 and the other word 0, got %d and %d", reserved, other)
+	}
+}
+
+// Three harts count in the same loop until hart 0 reaches its target, the others have to count about as far
+// whatever the seed is
+func TestHartScheduling(t *testing.T) {
+	const source = `
+.text
+	jal x0, Main
+Counter:
+	lw x5, 0(a0)
+	addi x5, x5, 1
+	sw x5, 0(a0)
+	jal x0, Counter
+Main:
+	lui x5, 0x80003
+	addi x5, x5, -384
+	lui x6, 1
+	addi x6, x6, 4
+	sw x6, 8(x5)
+	addi x6, gp, 4
+	sw x6, 12(x5)
+	addi x6, sp, -1024
+	sw x6, 16(x5)
+	addi x6, x0, 1
+	sw x6, 20(x5)
+	addi x6, gp, 8
+	sw x6, 12(x5)
+	addi x6, sp, -2048
+	sw x6, 16(x5)
+	addi x6, x0, 2
+	sw x6, 20(x5)
+	addi x8, x0, 2000
+Loop:
+	lw x7, 0(gp)
+	addi x7, x7, 1
+	sw x7, 0(gp)
+	bne x7, x8, Loop
+	jalr x0, x1, 0
+.data
+Counts: .word 0, 0, 0
+`
+	for seed := uint32(1); seed <= 3; seed++ {
+		inst := newTestEmulator(t, source, func(config *emulator.EmulatorConfig) {
+			config.Harts = 3
+			config.RandomSeed = seed
+		})
+		inst.run(t)
+
+		for hart := uint32(1); hart < 3; hart++ {
+			if count := inst.word(hart * 4); count < 1600 || count > 2400 {
+				t.Errorf("Expected hart %d to count to about 2000 with seed %d, got %d", hart, seed, count)
+			}
+		}
+	}
+}
+
+// The loop runs an instruction and calls one that crosses from page 1 into page 2, then rewrites both to
+// load 5 instead of 1, the second time around both have to run as rewritten. The instruction across the
+// page boundary is only rewritten in its upper half, which is on page 2.
+func TestSelfModifyingCode(t *testing.T) {
+	const source = `
+.text
+	addi x20, x1, 0
+	addi x9, x0, 0
+	addi x10, x0, 2
+Loop:
+	addi x6, x0, 1
+	slli x7, x9, 2
+	add x7, x7, gp
+	sw x6, 16(x7)
+	lw x5, 8(gp)
+	jalr x1, x5, 0
+	sw x6, 24(x7)
+	lw x5, 0(gp)
+	lw x8, 4(gp)
+	sw x8, 0(x5)
+	lui x5, 2
+	lw x8, 12(gp)
+	sw x8, 0(x5)
+	addi x9, x9, 1
+	bne x9, x10, Loop
+	jalr x0, x20, 0
+.data
+Rewrites: .space 16
+Results: .space 16
+`
+	const (
+		addi1 = 0x00100313 // addi x6, x0, 1
+		addi5 = 0x00500313 // addi x6, x0, 5
+		cNop  = 0x0001
+		cJrRa = 0x8082
+	)
+
+	for _, disableDecodeCache := range []bool{false, true} {
+		inst := newTestEmulator(t, source, func(config *emulator.EmulatorConfig) {
+			config.DisableDecodeCache = disableDecodeCache
+		})
+		memory := inst.Memory()
+		memory.WriteWord(0x1FFC, (addi1&0xFFFF)<<16|cNop)
+		memory.WriteWord(0x2000, cJrRa<<16|addi1>>16)
+		memory.WriteWord(inst.dataAddress, testTextAddress+assembler.Assemble(source).Labels["Loop"])
+		memory.WriteWord(inst.dataAddress+4, addi5)
+		memory.WriteWord(inst.dataAddress+8, 0x1FFE)
+		memory.WriteWord(inst.dataAddress+12, cJrRa<<16|addi5>>16)
+		inst.run(t)
+
+		expected := []struct {
+			name  string
+			value uint32
+		}{
+			{"the instruction in the loop", 1},
+			{"the rewritten instruction in the loop", 5},
+			{"the instruction across the page boundary", 1},
+			{"the rewritten instruction across the page boundary", 5},
+		}
+		for i, e := range expected {
+			if value := inst.word(uint32(16 + i*4)); value != e.value {
+				t.Errorf("Expected %s to load %d with the decode cache disabled %t, got %d", e.name, e.value, disableDecodeCache, value)
+			}
+		}
+	}
+}
+
+// The program jumps to mixed 2 and 4 byte code, which the assembler cannot emit, so it is written to memory
+// as halfwords. The 32 bit instructions at 0x3002 and 0x301A start halfway into a word.
+func TestCompressed(t *testing.T) {
+	const source = `
+.text
+	addi x21, x1, 0
+	addi x15, x0, 0
+	lui x5, 3
+	jalr x20, x5, 0
+	sw x10, 0(gp)
+	sw x11, 4(gp)
+	sw x12, 8(gp)
+	sw x13, 12(gp)
+	sw x14, 16(gp)
+	sw x15, 20(gp)
+	jalr x0, x21, 0
+.data
+Results: .space 24
+`
+	code := []uint16{
+		0x4505,         // 0x3000: c.li a0, 1
+		0x0593, 0x0070, // 0x3002: addi a1, x0, 7
+		0x2829,         // 0x3006: c.jal 0x3020
+		0x0297, 0x0000, // 0x3008: auipc x5, 0
+		0x02F1,         // 0x300C: c.addi x5, 28
+		0x9282,         // 0x300E: c.jalr x5
+		0x0363, 0x0000, // 0x3010: beq x0, x0, 0x3016
+		0x4785,         // 0x3014: c.li a5, 1
+		0x4705,         // 0x3016: c.li a4, 1
+		0x0705,         // 0x3018: c.addi a4, 1
+		0x0067, 0x000A, // 0x301A: jalr x0, x20, 0
+		0x0001, // 0x301E: padding
+		0x8606, // 0x3020: c.mv a2, ra
+		0x8082, // 0x3022: c.jr ra
+		0x8686, // 0x3024: c.mv a3, ra
+		0x8082, // 0x3026: c.jr ra
+	}
+
+	for _, disableDecodeCache := range []bool{false, true} {
+		inst := newTestEmulator(t, source, func(config *emulator.EmulatorConfig) {
+			config.DisableDecodeCache = disableDecodeCache
+		})
+		for i := 0; i < len(code); i += 2 {
+			inst.Memory().WriteWord(0x3000+uint32(i*2), uint32(code[i])|uint32(code[i+1])<<16)
+		}
+
+		var steps []string // the address and the call stack before each instruction of the mixed code
+		inst.AddBreakpoint(0x3000, emulator.Breakpoint{ID: 1})
+		inst.SetBreakCallback(func(inst *emulator.EmulatorInstance, breakpointID int, reason string) {
+			if inst.PC() >= 0x3000 {
+				steps = append(steps, fmt.Sprintf("%#x %#x", inst.PC(), inst.CallStack()))
+				inst.BreakNext()
+			}
+		})
+		inst.run(t)
+
+		expectedSteps := []string{
+			"0x3000 []", "0x3002 []", "0x3006 []", "0x3020 [0x3006]", "0x3022 [0x3006]", "0x3008 []", "0x300c []",
+			"0x300e []", "0x3024 [0x300e]", "0x3026 [0x300e]", "0x3010 []", "0x3016 []", "0x3018 []", "0x301a []",
+		}
+		if fmt.Sprint(steps) != fmt.Sprint(expectedSteps) {
+			t.Errorf("Expected the mixed code with the decode cache disabled %t to run\n%v\ngot\n%v", disableDecodeCache, expectedSteps, steps)
+		}
+
+		expected := []struct {
+			name  string
+			value uint32
+		}{
+			{"c.li", 1},
+			{"the instruction spanning two words", 7},
+			{"the return address of c.jal", 0x3008},
+			{"the return address of c.jalr", 0x3010},
+			{"the compressed branch target and the instruction after it", 2},
+			{"the instruction branched over", 0},
+		}
+		for i, e := range expected {
+			if value := inst.word(uint32(i * 4)); value != e.value {
+				t.Errorf("Expected %s to give %#x with the decode cache disabled %t, got %#x", e.name, e.value, disableDecodeCache, value)
+			}
+		}
+	}
+}
+
+func benchmarkEmulate(b *testing.B, disableDecodeCache bool) {
+	for i := 0; i < b.N; i++ {
+		// assembling and creating the emulator, which clears the display buffer, should not be part of the
+		// measurement
+		b.StopTimer()
+		inst := newTestEmulator(b, benchmarkSource, func(config *emulator.EmulatorConfig) {
+			config.DisableDecodeCache = disableDecodeCache
+		})
+		b.StartTimer()
+
+		inst.Emulate(testTextAddress)
+
+		if len(inst.GetErrors()) != 0 {
+			b.Fatalf("Unexpected runtime error after %d instructions", inst.GetTotalInstructionsExecuted())
+		}
+	}
+}
+
+func BenchmarkEmulate(b *testing.B) {
+	benchmarkEmulate(b, false)
+}
+
+func BenchmarkEmulateWithoutDecodeCache(b *testing.B) {
+	benchmarkEmulate(b, true)
+}
@@ -24,6 +24,15 @@ type EvaluationRunResult struct {
 	Regs      int    `json:"regs"` // stats
 	Mem       int    `json:"mem"`  // stats
 	NumErrors int    `json:"numErrors"`
+
+	Caches   *CacheReport    `json:"caches,omitempty"`   // only when caches are simulated
+	Pipeline *PipelineReport `json:"pipeline,omitempty"` // only when the pipeline is modeled
+	Branches *BranchReport   `json:"branches,omitempty"` // only when branches are predicted
+
+	Files map[string][]byte `json:"files,omitempty"` // files the run created or wrote to, keyed by path
+
+	FinalFrame []byte          `json:"finalFrame,omitempty"` // PNG of the final frame, only when captured
+	Frames     []CapturedFrame `json:"frames,omitempty"`     // sorted by DI, only the ones asked for, see screenshot.go
 }
 
 type streamingMessage struct {
@@ -41,10 +50,33 @@ type memoryImageContext struct {
 	osEntry                   uint32
 	asmStaticInstructionCount int
 	asmStaticMemoryCount      int
+	assembled                 *assembler.AssembledResult
+}
+
+// Optional settings for a batch run, the zero value runs the assignment with the defaults
+type BatchRunOptions struct {
+	FileSystem  *VirtualFileSystem     // mounted in every run, each run gets its own copy
+	TrapMode    bool                   // faults trap to the program's handler, see traps.go
+	Harts       int                    // number of harts, defaults to 1, see harts.go
+	Trace       TraceOptions           // each run writes its trace to the path with the seed appended, see tracer.go
+	Caches      *CacheHierarchyConfig  // simulated caches, see cacheSim.go
+	Pipeline    *PipelineConfig        // pipeline timing model, see pipeline.go
+	Predictor   *BranchPredictorConfig // branch predictor, see branchPredictor.go
+	Screenshots ScreenshotOptions      // frames captured in every run, see screenshot.go
 }
 
 func BatchRun(elfFilePath, asmFilePath string, seeds []uint32, streamToStdout bool) ([]EvaluationRunResult, error) {
+	return BatchRunWithOptions(elfFilePath, asmFilePath, seeds, streamToStdout, BatchRunOptions{})
+}
+
+func BatchRunWithOptions(elfFilePath, asmFilePath string, seeds []uint32, streamToStdout bool, options BatchRunOptions) ([]EvaluationRunResult, error) {
 	memImg, e := buildMemoryImage(elfFilePath, asmFilePath)
+	if e == nil && options.Caches != nil {
+		e = options.Caches.Validate()
+	}
+	if e == nil && options.Predictor != nil {
+		e = options.Predictor.Validate()
+	}
 	if e != nil {
 		if streamToStdout {
 			msg := streamingMessage{
@@ -72,7 +104,7 @@ func BatchRun(elfFilePath, asmFilePath string, seeds []uint32, streamToStdout bo
 
 	// start workers
 	for i := 0; i < numCPUs; i++ {
-		go evalWorker(memImg, inputQueue, &stdOutMutex, streamToStdout, results)
+		go evalWorker(memImg, options, inputQueue, &stdOutMutex, streamToStdout, results)
 	}
 
 	// queue up seeds
@@ -93,7 +125,7 @@ func BatchRun(elfFilePath, asmFilePath string, seeds []uint32, streamToStdout bo
 	return finalResults, nil
 }
 
-func evalWorker(memImg memoryImageContext, seedQueue chan uint32, stdOutMutex *sync.Mutex, streamToStdout bool, results chan EvaluationRunResult) {
+func evalWorker(memImg memoryImageContext, options BatchRunOptions, seedQueue chan uint32, stdOutMutex *sync.Mutex, streamToStdout bool, results chan EvaluationRunResult) {
 	for seed := range seedQueue {
 		// configure emulator
 		numErrors := 0
@@ -106,6 +138,12 @@ func evalWorker(memImg memoryImageContext, seedQueue chan uint32, stdOutMutex *s
 			ProfileIgnoreRangeStart: memImg.osCodeStart,
 			ProfileIgnoreRangeEnd:   memImg.osCodeEnd,
 			RandomSeed:              seed,
+			FileSystem:              options.FileSystem,
+			TrapMode:                options.TrapMode,
+			Harts:                   options.Harts,
+			Caches:                  options.Caches,
+			Pipeline:                options.Pipeline,
+			BranchPredictor:         options.Predictor,
 			RuntimeErrorCallback: func(e RuntimeException) {
 				numErrors++
 			},
@@ -117,11 +155,32 @@ func evalWorker(memImg memoryImageContext, seedQueue chan uint32, stdOutMutex *s
 
 		emulator := NewEmulator(config)
 
+		var frames *frameCapturer
+		if len(options.Screenshots.AtDI) > 0 {
+			frames = newFrameCapturer(options.Screenshots.AtDI)
+			emulator.bus.tickers = append(emulator.bus.tickers, frames)
+		}
+
+		var closeTrace func() error
+		if options.Trace.Path != "" {
+			var e error
+			closeTrace, e = traceToFile(emulator, tracePathForSeed(options.Trace.Path, seed), options.Trace.Format, memImg.assembled, memImg.assemblyEntry)
+			if e != nil {
+				streamTraceError(fmt.Errorf("could not create trace: %v", e), stdOutMutex, streamToStdout)
+			}
+		}
+
 		emulator.Emulate(memImg.osEntry) // running assignment setup
 		config.GlobalDataAddress = memImg.asmGlobalPointer
 		emulator.ResetRegisters(config)
 		emulator.Emulate(memImg.assemblyEntry) // running assembly
 
+		if closeTrace != nil {
+			if e := closeTrace(); e != nil {
+				streamTraceError(fmt.Errorf("could not write trace: %v", e), stdOutMutex, streamToStdout)
+			}
+		}
+
 		// check if the emulator passed
 		passed := emulator.solutionValidity == 2
 
@@ -134,6 +193,21 @@ func evalWorker(memImg memoryImageContext, seedQueue chan uint32, stdOutMutex *s
 			Regs:      int(emulator.regUsage),
 			Mem:       int(emulator.memUsage) + memImg.asmStaticMemoryCount,
 			NumErrors: numErrors,
+			Caches:    emulator.GetCacheStats(),
+			Pipeline:  emulator.GetPipelineStats(memImg.assembled, memImg.assemblyEntry),
+			Branches:  emulator.GetBranchStats(memImg.assembled, memImg.assemblyEntry),
+		}
+
+		if emulator.fs != nil {
+			if files := emulator.fs.GetModifiedFiles(); len(files) > 0 {
+				result.Files = files
+			}
+		}
+		if options.Screenshots.Final {
+			result.FinalFrame = emulator.display.encodePNG()
+		}
+		if frames != nil {
+			result.Frames = frames.finish(emulator)
 		}
 
 		if streamToStdout {
@@ -151,6 +225,18 @@ func evalWorker(memImg memoryImageContext, seedQueue chan uint32, stdOutMutex *s
 	}
 }
 
+// A run whose trace fails still reports its result, the error is only streamed
+func streamTraceError(e error, stdOutMutex *sync.Mutex, streamToStdout bool) {
+	if !streamToStdout {
+		return
+	}
+
+	stdOutMutex.Lock()
+	mb, _ := json.Marshal(streamingMessage{Type: "error", Body: e.Error()})
+	fmt.Println(string(mb))
+	stdOutMutex.Unlock()
+}
+
 func buildMemoryImage(elfFilePath, asmFilePath string) (memoryImageContext, error) {
 	// loading the elf file
 	f, e := elf.Open(elfFilePath)
@@ -262,5 +348,6 @@ func buildMemoryImage(elfFilePath, asmFilePath string) (memoryImageContext, erro
 		asmGlobalPointer:          assemblyGlobalPointer,
 		asmStaticInstructionCount: len(assembleRes.ProgramText),
 		asmStaticMemoryCount:      len(assembleRes.ProgramData),
+		assembled:                 assembleRes,
 	}, nil
 }
@@ -17,6 +17,7 @@ func (inst *EmulatorInstance) newException(format string, args ...interface{}) R
 	callStack = append(callStack, inst.pc)
 
 	exception := RuntimeException{
+		hart:      inst.hartID,
 		regs:      regs,
 		pc:        inst.pc,
 		callStack: callStack,
@@ -32,7 +33,7 @@ func (inst *EmulatorInstance) newMemoryAccessedBeforeInitializedException(addr u
 }
 
 func (inst *EmulatorInstance) newMemoryAccessNotAlignedException(addr uint32, accessType string) RuntimeException {
-	return inst.newException("Memory access not aligned at 0x%08X for type %s", addr, accessType)
+	return inst.newTrapException(TrapCauseLoadMisaligned, addr, "Memory access not aligned at 0x%08X for type %s", addr, accessType)
 }
 
 func (inst *EmulatorInstance) newRegisterAccessedBeforeInitializedException(register uint32) RuntimeException {
@@ -41,7 +42,7 @@ func (inst *EmulatorInstance) newRegisterAccessedBeforeInitializedException(regi
 
 func (inst *EmulatorInstance) newSegmentationFaultException(addr uint32) RuntimeException {
 	addr += 0x80000000 // because of some other line of code but this can be changed later. All references are in the reserved memory section, so look there
-	return inst.newException("Segmentation fault accessing 0x%08X", addr)
+	return inst.newTrapException(TrapCauseLoadFault, addr, "Segmentation fault accessing 0x%08X", addr)
 }
 
 func (inst *EmulatorInstance) newIllegalRegisterWrite() RuntimeException {
@@ -0,0 +1,50 @@
+package emulator
+
+// Gives the external tests the debugger's control over the emulator
+
+func (inst *EmulatorInstance) EnableJournal() {
+	inst.enableJournal()
+}
+
+func (inst *EmulatorInstance) SetBreakCallback(callback func(inst *EmulatorInstance, breakpointID int, reason string)) {
+	inst.breakCallback = callback
+}
+
+// Pauses the running hart before its next instruction, like stepping in does
+func (inst *EmulatorInstance) BreakNext() {
+	inst.breakNext = true
+	inst.breakHart = inst.hartID
+}
+
+func (inst *EmulatorInstance) StepBack() error {
+	return inst.stepBack()
+}
+
+func (inst *EmulatorInstance) ReverseContinue() error {
+	return inst.reverseContinue()
+}
+
+// The memory the emulator runs on, which restoring a snapshot replaces
+func (inst *EmulatorInstance) Memory() *MemoryImage {
+	return inst.memory
+}
+
+// The number of registers the program wrote, which the autograder reports
+func (inst *EmulatorInstance) RegisterUsage() uint32 {
+	return inst.regUsage
+}
+
+// Encodes the display tiles like the standalone runner streams them
+func EncodeDisplayTiles(width, height int, updates []VirtualDisplayUpdate, compress bool) ([]byte, error) {
+	return encodeDisplayTiles(width, height, updates, compress)
+}
+
+// The address of the instruction the running hart executes next
+func (inst *EmulatorInstance) PC() uint32 {
+	return inst.pc
+}
+
+// The addresses of the calls the running hart is in, with the innermost last
+func (inst *EmulatorInstance) CallStack() []uint32 {
+	return append([]uint32{}, inst.callStack...)
+}
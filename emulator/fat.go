@@ -0,0 +1,255 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// Minimal FAT12/16/32 reader, only used to load every file out of an image when it is mounted. Long file
+// names are supported, everything else (timestamps, attributes, etc.) is ignored.
+
+var errCorruptFATImage = errors.New("corrupt or unsupported FAT image")
+
+type fatImage struct {
+	image             []byte
+	bytesPerSector    uint32
+	sectorsPerCluster uint32
+	fatStart          uint32 // byte offset of the first FAT
+	rootDirStart      uint32 // byte offset of the FAT12/16 root directory
+	rootDirSize       uint32
+	dataStart         uint32 // byte offset of cluster 2
+	rootCluster       uint32 // FAT32 only
+	clusterCount      uint32
+	fatBits           int
+}
+
+func readFATImage(image []byte) (map[string][]byte, error) {
+	if len(image) < 512 || image[510] != 0x55 || image[511] != 0xAA {
+		return nil, errCorruptFATImage
+	}
+
+	fat := &fatImage{image: image}
+	fat.bytesPerSector = uint32(binary.LittleEndian.Uint16(image[11:]))
+	fat.sectorsPerCluster = uint32(image[13])
+	reservedSectors := uint32(binary.LittleEndian.Uint16(image[14:]))
+	numFATs := uint32(image[16])
+	rootEntryCount := uint32(binary.LittleEndian.Uint16(image[17:]))
+	totalSectors := uint32(binary.LittleEndian.Uint16(image[19:]))
+	if totalSectors == 0 {
+		totalSectors = binary.LittleEndian.Uint32(image[32:])
+	}
+	fatSize := uint32(binary.LittleEndian.Uint16(image[22:]))
+	if fatSize == 0 {
+		fatSize = binary.LittleEndian.Uint32(image[36:])
+	}
+
+	if fat.bytesPerSector == 0 || fat.sectorsPerCluster == 0 {
+		return nil, errCorruptFATImage
+	}
+
+	rootDirSectors := (rootEntryCount*32 + fat.bytesPerSector - 1) / fat.bytesPerSector
+	firstDataSector := reservedSectors + numFATs*fatSize + rootDirSectors
+	if firstDataSector > totalSectors {
+		return nil, errCorruptFATImage
+	}
+
+	fat.fatStart = reservedSectors * fat.bytesPerSector
+	fat.rootDirStart = (reservedSectors + numFATs*fatSize) * fat.bytesPerSector
+	fat.rootDirSize = rootEntryCount * 32
+	fat.dataStart = firstDataSector * fat.bytesPerSector
+	fat.clusterCount = (totalSectors - firstDataSector) / fat.sectorsPerCluster
+
+	// the FAT type is determined only by the number of clusters
+	if fat.clusterCount < 4085 {
+		fat.fatBits = 12
+	} else if fat.clusterCount < 65525 {
+		fat.fatBits = 16
+	} else {
+		fat.fatBits = 32
+		fat.rootCluster = binary.LittleEndian.Uint32(image[44:])
+	}
+
+	var rootDir []byte
+	if fat.fatBits == 32 {
+		var e error
+		rootDir, e = fat.readChain(fat.rootCluster)
+		if e != nil {
+			return nil, e
+		}
+	} else {
+		if uint64(fat.rootDirStart)+uint64(fat.rootDirSize) > uint64(len(image)) {
+			return nil, errCorruptFATImage
+		}
+		rootDir = image[fat.rootDirStart : fat.rootDirStart+fat.rootDirSize]
+	}
+
+	files := make(map[string][]byte)
+	if e := fat.readDirectory(rootDir, "", files, 0); e != nil {
+		return nil, e
+	}
+
+	return files, nil
+}
+
+func (fat *fatImage) nextCluster(cluster uint32) (uint32, bool) {
+	switch fat.fatBits {
+	case 12:
+		offset := fat.fatStart + cluster + cluster/2
+		if int(offset)+2 > len(fat.image) {
+			return 0, false
+		}
+		value := uint32(binary.LittleEndian.Uint16(fat.image[offset:]))
+		if cluster&1 != 0 {
+			value >>= 4
+		} else {
+			value &= 0xFFF
+		}
+		return value, value < 0xFF8
+	case 16:
+		offset := fat.fatStart + cluster*2
+		if int(offset)+2 > len(fat.image) {
+			return 0, false
+		}
+		value := uint32(binary.LittleEndian.Uint16(fat.image[offset:]))
+		return value, value < 0xFFF8
+	default:
+		offset := fat.fatStart + cluster*4
+		if int(offset)+4 > len(fat.image) {
+			return 0, false
+		}
+		value := binary.LittleEndian.Uint32(fat.image[offset:]) & 0x0FFFFFFF
+		return value, value < 0x0FFFFFF8
+	}
+}
+
+// Reads the contents of every cluster in the chain starting at the given cluster
+func (fat *fatImage) readChain(cluster uint32) ([]byte, error) {
+	clusterSize := fat.bytesPerSector * fat.sectorsPerCluster
+	data := []byte{}
+
+	// the chain can never be longer than the number of clusters, this protects against cycles
+	for i := uint32(0); i <= fat.clusterCount; i++ {
+		if cluster < 2 || cluster-2 >= fat.clusterCount {
+			return nil, errCorruptFATImage
+		}
+
+		start := uint64(fat.dataStart) + uint64(cluster-2)*uint64(clusterSize)
+		if start+uint64(clusterSize) > uint64(len(fat.image)) {
+			return nil, errCorruptFATImage
+		}
+		data = append(data, fat.image[start:start+uint64(clusterSize)]...)
+
+		next, ok := fat.nextCluster(cluster)
+		if !ok {
+			return data, nil
+		}
+		cluster = next
+	}
+
+	return nil, errCorruptFATImage
+}
+
+func (fat *fatImage) readDirectory(entries []byte, prefix string, files map[string][]byte, depth int) error {
+	if depth > 32 {
+		return errCorruptFATImage
+	}
+
+	longName := []uint16{}
+	for i := 0; i+32 <= len(entries); i += 32 {
+		entry := entries[i : i+32]
+		if entry[0] == 0x00 {
+			break // end of directory
+		} else if entry[0] == 0xE5 {
+			longName = longName[:0] // deleted
+			continue
+		}
+
+		attributes := entry[11]
+		if attributes == 0x0F {
+			// long file name entry, they are stored in reverse order before the short name entry
+			chars := make([]uint16, 0, 13)
+			for _, offset := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				chars = append(chars, binary.LittleEndian.Uint16(entry[offset:]))
+			}
+			if entry[0]&0x40 != 0 {
+				longName = longName[:0]
+			}
+			longName = append(chars, longName...)
+			continue
+		}
+
+		name := fatShortName(entry)
+		if len(longName) > 0 {
+			name = fatLongName(longName)
+			longName = longName[:0]
+		}
+
+		if attributes&0x08 != 0 || name == "." || name == ".." {
+			continue // volume label or relative entry
+		}
+
+		cluster := uint32(binary.LittleEndian.Uint16(entry[20:]))<<16 | uint32(binary.LittleEndian.Uint16(entry[26:]))
+		size := binary.LittleEndian.Uint32(entry[28:])
+
+		if attributes&0x10 != 0 {
+			// subdirectory
+			if cluster == 0 {
+				continue
+			}
+			subEntries, e := fat.readChain(cluster)
+			if e != nil {
+				return e
+			}
+			if e := fat.readDirectory(subEntries, prefix+name+"/", files, depth+1); e != nil {
+				return e
+			}
+			continue
+		}
+
+		data := []byte{}
+		if size > 0 {
+			chain, e := fat.readChain(cluster)
+			if e != nil {
+				return e
+			}
+			if uint32(len(chain)) < size {
+				return errCorruptFATImage
+			}
+			data = chain[:size]
+		}
+		files[prefix+name] = data
+	}
+
+	return nil
+}
+
+func fatShortName(entry []byte) string {
+	base := strings.TrimRight(string(entry[0:8]), " ")
+	ext := strings.TrimRight(string(entry[8:11]), " ")
+
+	// windows stores the case of all lowercase short names in the reserved byte
+	if entry[12]&0x08 != 0 {
+		base = strings.ToLower(base)
+	}
+	if entry[12]&0x10 != 0 {
+		ext = strings.ToLower(ext)
+	}
+
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+func fatLongName(chars []uint16) string {
+	// the name is terminated by 0x0000 and padded with 0xFFFF
+	for i, c := range chars {
+		if c == 0x0000 || c == 0xFFFF {
+			chars = chars[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(chars))
+}
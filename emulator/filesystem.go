@@ -0,0 +1,539 @@
+package emulator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Virtual File System
+//
+// The filesystem is an in-memory tree, optionally seeded from a host directory or a FAT image, and is
+// mapped into the reserved region starting at 0x80800000. The region starts with a directory so programs can find files without any ecalls:
+//
+//	0x00:             number of files
+//	0x04 + i*64:      offset of file i's data from 0x80800000
+//	0x08 + i*64:      size of file i in bytes
+//	0x0C + i*64:      path of file i, NUL terminated ('/' separated, relative to the mount root)
+//
+// File data follows the directory, each file aligned to 4 bytes. Writes to file data through the region
+// modify the file in place, but files can only be created or resized through the following ecalls, which
+// return a negative errno on failure:
+//
+//	56   openat: a1 = path, a2 = flags, returns fd in a0
+//	57   close:  a0 = fd
+//	62   lseek:  a0 = fd, a1 = offset, a2 = whence (0 = SET, 1 = CUR, 2 = END), returns new position in a0
+//	63   read:   a0 = length, a1 = buffer, a2 = fd, returns number of bytes read in a0
+//	64   write:  a0 = length, a1 = buffer, a2 = fd, returns number of bytes written in a0
+//	1024 open:   a0 = path, a1 = flags, returns fd in a0
+//
+// Unlike Linux, read and write take the fd in a2 and the length in a0. Writing to stdout has always been
+// ecall 64 with the fd in a2 and the length in a0, so writes to files keep that order and reads match them.
+//
+// Open flags are the newlib values since that is what the assignment OS is built against.
+//
+// Each emulator gets a copy-on-write clone of the filesystem it was configured with, so runs never see
+// each other's changes and the original files are never modified.
+
+const (
+	fsDirectoryEntrySize = 64
+	fsMaxNameLength      = fsDirectoryEntrySize - 9 // offset, size, and the NUL terminator
+	fsFirstFD            = 3                        // 0-2 are stdin, stdout, and stderr
+	fsMaxOpenFiles       = 64
+)
+
+// open flags, from newlib's sys/_default_fcntl.h
+const (
+	fsOpenAccessMode = 0x0003 // O_RDONLY = 0, O_WRONLY = 1, O_RDWR = 2
+	fsOpenWriteOnly  = 0x0001
+	fsOpenReadWrite  = 0x0002
+	fsOpenAppend     = 0x0008
+	fsOpenCreate     = 0x0200
+	fsOpenTruncate   = 0x0400
+	fsOpenExclusive  = 0x0800
+)
+
+// errno values returned by the filesystem ecalls
+const (
+	errnoENOENT = 2
+	errnoEBADF  = 9
+	errnoEACCES = 13
+	errnoEEXIST = 17
+	errnoEINVAL = 22
+	errnoEMFILE = 24
+)
+
+type VirtualFile struct {
+	name     string
+	data     []byte
+	shared   bool // data is shared with the filesystem this was cloned from and must be copied before writing
+	modified bool // written to or created since the filesystem was mounted
+}
+
+type openVirtualFile struct {
+	file     *VirtualFile
+	position uint32
+	flags    uint32
+}
+
+func NewVirtualFileSystem() *VirtualFileSystem {
+	return &VirtualFileSystem{
+		files:       map[string]*VirtualFile{},
+		openFiles:   map[uint32]*openVirtualFile{},
+		layoutStale: true,
+	}
+}
+
+// Mounts a host directory or a FAT image, depending on what the path points to
+func MountFileSystem(path string) (*VirtualFileSystem, error) {
+	info, e := os.Stat(path)
+	if e != nil {
+		return nil, e
+	}
+
+	if info.IsDir() {
+		return MountDirectory(path)
+	}
+	return MountFATImage(path)
+}
+
+// Loads every file under the host directory into a new filesystem
+func MountDirectory(hostPath string) (*VirtualFileSystem, error) {
+	fs := NewVirtualFileSystem()
+	e := filepath.WalkDir(hostPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, e := filepath.Rel(hostPath, path)
+		if e != nil {
+			return e
+		}
+
+		b, e := os.ReadFile(path)
+		if e != nil {
+			return e
+		}
+
+		fs.AddFile(filepath.ToSlash(relPath), b)
+		return nil
+	})
+	if e != nil {
+		return nil, fmt.Errorf("error mounting directory %s: %v", hostPath, e)
+	}
+
+	return fs, nil
+}
+
+// Loads every file in the FAT12/16/32 image into a new filesystem
+func MountFATImage(imagePath string) (*VirtualFileSystem, error) {
+	b, e := os.ReadFile(imagePath)
+	if e != nil {
+		return nil, fmt.Errorf("error reading FAT image %s: %v", imagePath, e)
+	}
+
+	files, e := readFATImage(b)
+	if e != nil {
+		return nil, fmt.Errorf("error mounting FAT image %s: %v", imagePath, e)
+	}
+
+	fs := NewVirtualFileSystem()
+	for name, data := range files {
+		fs.AddFile(name, data)
+	}
+	return fs, nil
+}
+
+// Adds a file to the filesystem, replacing any file with the same path
+func (fs *VirtualFileSystem) AddFile(name string, data []byte) {
+	name = strings.TrimPrefix(name, "/")
+	fs.files[name] = &VirtualFile{name: name, data: data}
+	fs.layoutStale = true
+}
+
+// Makes a copy-on-write copy of the filesystem. File data is only copied when the clone writes to it.
+func (fs *VirtualFileSystem) Clone() *VirtualFileSystem {
+	newFS := NewVirtualFileSystem()
+	for name, file := range fs.files {
+		newFS.files[name] = &VirtualFile{name: file.name, data: file.data, shared: true, modified: file.modified}
+	}
+	return newFS
+}
+
+// Returns a copy of the contents of every file, keyed by path
+func (fs *VirtualFileSystem) GetFiles() map[string][]byte {
+	files := make(map[string][]byte, len(fs.files))
+	for name, file := range fs.files {
+		files[name] = append([]byte{}, file.data...)
+	}
+	return files
+}
+
+// Returns a copy of the contents of every file that was created or written to, keyed by path
+func (fs *VirtualFileSystem) GetModifiedFiles() map[string][]byte {
+	files := make(map[string][]byte)
+	for name, file := range fs.files {
+		if file.modified {
+			files[name] = append([]byte{}, file.data...)
+		}
+	}
+	return files
+}
+
+// Whether the slash separated path stays inside the directory it is relative to once cleaned, so it is
+// not absolute and does not start with ".."
+func isLocalPath(name string) bool {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" {
+		return false
+	}
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// Writes every file that was created or written to under the host directory. Files are only ever written
+// inside it, a path that would leave it is an error.
+func (fs *VirtualFileSystem) SaveModifiedFiles(hostPath string) error {
+	for name, data := range fs.GetModifiedFiles() {
+		if !isLocalPath(name) {
+			return fmt.Errorf("refusing to save %s outside of %s", name, hostPath)
+		}
+		path := filepath.Join(hostPath, filepath.FromSlash(name))
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			return e
+		}
+		if e := os.WriteFile(path, data, 0644); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Makes the file's data safe to write to, copying it if it is still shared
+func (file *VirtualFile) makeWritable() {
+	if file.shared {
+		file.data = append([]byte{}, file.data...)
+		file.shared = false
+	}
+	file.modified = true
+}
+
+// Recomputes where each file is mapped, this is only done after files are added or resized
+func (fs *VirtualFileSystem) updateLayout() {
+	if !fs.layoutStale {
+		return
+	}
+
+	fs.layout = make([]*VirtualFile, 0, len(fs.files))
+	for _, file := range fs.files {
+		fs.layout = append(fs.layout, file)
+	}
+	sort.Slice(fs.layout, func(i, j int) bool {
+		return fs.layout[i].name < fs.layout[j].name
+	})
+
+	fs.offsets = make([]uint32, len(fs.layout))
+	offset := uint32(4 + len(fs.layout)*fsDirectoryEntrySize)
+	for i, file := range fs.layout {
+		fs.offsets[i] = offset
+		offset += (uint32(len(file.data)) + 3) & ^uint32(3)
+	}
+	fs.directorySize = uint32(4 + len(fs.layout)*fsDirectoryEntrySize)
+	fs.mappedSize = offset
+	fs.layoutStale = false
+}
+
+// Finds the file mapped at the offset into the filesystem region, returns -1 if there is none
+func (fs *VirtualFileSystem) fileAtOffset(offset uint32) int {
+	fs.updateLayout()
+
+	i := sort.Search(len(fs.offsets), func(i int) bool {
+		return fs.offsets[i] > offset
+	}) - 1
+	if i < 0 || offset-fs.offsets[i] >= uint32(len(fs.layout[i].data)) {
+		return -1
+	}
+	return i
+}
+
+// Reads the word at the offset into the filesystem region
+func (fs *VirtualFileSystem) readWord(offset uint32) (uint32, bool) {
+	fs.updateLayout()
+	offset &= 0xFFFFFFFC
+
+	if offset >= fs.mappedSize {
+		return 0, false
+	} else if offset == 0 {
+		return uint32(len(fs.layout)), true
+	} else if offset < fs.directorySize {
+		entry := (offset - 4) / fsDirectoryEntrySize
+		entryOffset := (offset - 4) % fsDirectoryEntrySize
+		file := fs.layout[entry]
+		switch entryOffset {
+		case 0:
+			return fs.offsets[entry], true
+		case 4:
+			return uint32(len(file.data)), true
+		default:
+			name := file.name
+			if len(name) > fsMaxNameLength {
+				name = name[:fsMaxNameLength]
+			}
+
+			value := uint32(0)
+			for i := uint32(0); i < 4; i++ {
+				if c := entryOffset - 8 + i; c < uint32(len(name)) {
+					value |= uint32(name[c]) << (i * 8)
+				}
+			}
+			return value, true
+		}
+	}
+
+	i := fs.fileAtOffset(offset)
+	if i < 0 {
+		return 0, true // alignment padding
+	}
+
+	data := fs.layout[i].data
+	value := uint32(0)
+	for j := uint32(0); j < 4; j++ {
+		if c := offset - fs.offsets[i] + j; c < uint32(len(data)) {
+			value |= uint32(data[c]) << (j * 8)
+		}
+	}
+	return value, true
+}
+
+// Writes to the file data mapped at the offset into the filesystem region. Returns false if there is no
+// file data at the offset, the directory and padding can't be written to.
+func (fs *VirtualFileSystem) writeWord(offset, bitmask, value uint32) bool {
+	i := fs.fileAtOffset(offset)
+	if i < 0 {
+		return false
+	}
+
+	file := fs.layout[i]
+	file.makeWritable()
+
+	wordOffset := offset&0xFFFFFFFC - fs.offsets[i]
+	value <<= (offset & 0x3) * 8
+	for j := uint32(0); j < 4; j++ {
+		if bitmask&(0xFF<<(j*8)) == 0 {
+			continue
+		}
+		if c := wordOffset + j; c < uint32(len(file.data)) {
+			file.data[c] = byte(value >> (j * 8))
+		}
+	}
+	return true
+}
+
+func (fs *VirtualFileSystem) open(name string, flags uint32) int32 {
+	if len(fs.openFiles) >= fsMaxOpenFiles {
+		return -errnoEMFILE
+	}
+
+	name = strings.TrimPrefix(name, "/")
+	file, ok := fs.files[name]
+	if !ok {
+		if flags&fsOpenCreate == 0 || name == "" {
+			return -errnoENOENT
+		}
+		if !isLocalPath(name) {
+			return -errnoEACCES // the file could never be saved, e.g. "../x"
+		}
+
+		file = &VirtualFile{name: name, data: []byte{}, modified: true}
+		fs.files[name] = file
+		fs.layoutStale = true
+	} else if flags&fsOpenCreate != 0 && flags&fsOpenExclusive != 0 {
+		return -errnoEEXIST
+	}
+
+	writable := flags&fsOpenAccessMode == fsOpenWriteOnly || flags&fsOpenAccessMode == fsOpenReadWrite
+	if flags&fsOpenTruncate != 0 && writable && len(file.data) > 0 {
+		file.makeWritable()
+		file.data = file.data[:0]
+		fs.layoutStale = true
+	}
+
+	// finding the lowest free file descriptor, like a real OS would
+	fd := uint32(fsFirstFD)
+	for {
+		if _, ok := fs.openFiles[fd]; !ok {
+			break
+		}
+		fd++
+	}
+
+	fs.openFiles[fd] = &openVirtualFile{file: file, flags: flags}
+	return int32(fd)
+}
+
+func (fs *VirtualFileSystem) close(fd uint32) int32 {
+	if _, ok := fs.openFiles[fd]; !ok {
+		return -errnoEBADF
+	}
+	delete(fs.openFiles, fd)
+	return 0
+}
+
+func (fs *VirtualFileSystem) seek(fd uint32, offset int32, whence uint32) int32 {
+	openFile, ok := fs.openFiles[fd]
+	if !ok {
+		return -errnoEBADF
+	}
+
+	position := int64(offset)
+	switch whence {
+	case 0:
+		// SEEK_SET
+	case 1:
+		// SEEK_CUR
+		position += int64(openFile.position)
+	case 2:
+		// SEEK_END
+		position += int64(len(openFile.file.data))
+	default:
+		return -errnoEINVAL
+	}
+
+	if position < 0 || position > 0x7FFFFFFF {
+		return -errnoEINVAL
+	}
+
+	openFile.position = uint32(position)
+	return int32(position)
+}
+
+// Reads up to length bytes from the file, returns the bytes read or a negative errno
+func (fs *VirtualFileSystem) read(fd uint32, length uint32) ([]byte, int32) {
+	openFile, ok := fs.openFiles[fd]
+	if !ok {
+		return nil, -errnoEBADF
+	}
+
+	if openFile.flags&fsOpenAccessMode == fsOpenWriteOnly {
+		return nil, -errnoEBADF
+	}
+
+	data := openFile.file.data
+	if openFile.position >= uint32(len(data)) {
+		return nil, 0 // end of file
+	}
+
+	data = data[openFile.position:]
+	if uint32(len(data)) > length {
+		data = data[:length]
+	}
+	openFile.position += uint32(len(data))
+	return data, 0
+}
+
+// Writes the data to the file, growing it if needed. Returns the number of bytes written or a negative errno.
+func (fs *VirtualFileSystem) write(fd uint32, data []byte) int32 {
+	openFile, ok := fs.openFiles[fd]
+	if !ok {
+		return -errnoEBADF
+	}
+
+	accessMode := openFile.flags & fsOpenAccessMode
+	if accessMode != fsOpenWriteOnly && accessMode != fsOpenReadWrite {
+		return -errnoEBADF
+	}
+
+	file := openFile.file
+	file.makeWritable()
+	if openFile.flags&fsOpenAppend != 0 {
+		openFile.position = uint32(len(file.data))
+	}
+
+	end := openFile.position + uint32(len(data))
+	if end > uint32(len(file.data)) {
+		// growing the file, any gap left by seeking past the end is filled with zeros
+		file.data = append(file.data, make([]byte, end-uint32(len(file.data)))...)
+		fs.layoutStale = true
+	}
+
+	copy(file.data[openFile.position:], data)
+	openFile.position = end
+	return int32(len(data))
+}
+
+// Handles the filesystem ecalls, returns false if the ecall is not a filesystem call so it can be passed
+// on to the OS
+func (inst *EmulatorInstance) executeFileSystemCall(syscall uint32) bool {
+	fs := inst.fs
+	switch syscall {
+	case 56:
+		// openat, the directory fd is ignored since there is no working directory
+		inst.registers[10] = uint32(fs.open(inst.readCString(inst.registers[11]), inst.registers[12]))
+	case 1024:
+		// open
+		inst.registers[10] = uint32(fs.open(inst.readCString(inst.registers[10]), inst.registers[11]))
+	case 57:
+		// close
+		if _, ok := fs.openFiles[inst.registers[10]]; !ok {
+			return false // might be a file the OS knows about
+		}
+		inst.registers[10] = uint32(fs.close(inst.registers[10]))
+	case 62:
+		// lseek
+		if _, ok := fs.openFiles[inst.registers[10]]; !ok {
+			return false
+		}
+		inst.registers[10] = uint32(fs.seek(inst.registers[10], int32(inst.registers[11]), inst.registers[12]))
+	case 63:
+		// read
+		// buffer length is in x10
+		// buffer address is in x11
+		// file descriptor is in x12
+		if _, ok := fs.openFiles[inst.registers[12]]; !ok {
+			return false
+		}
+		data, errno := fs.read(inst.registers[12], inst.registers[10])
+		if errno != 0 {
+			inst.registers[10] = uint32(errno)
+			return true
+		}
+		for i, b := range data {
+			inst.memWriteByte(inst.registers[11]+uint32(i), uint32(b))
+		}
+		inst.registers[10] = uint32(len(data))
+	case 64:
+		// write
+		// buffer length is in x10
+		// buffer address is in x11
+		// file descriptor is in x12
+		if _, ok := fs.openFiles[inst.registers[12]]; !ok {
+			return false // stdout is handled by the emulator
+		}
+		data := []byte{}
+		for i := uint32(0); i < inst.registers[10]; i++ {
+			data = append(data, byte(inst.memReadByte(inst.registers[11]+i)))
+		}
+		inst.registers[10] = uint32(fs.write(inst.registers[12], data))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Reads a NUL terminated string out of emulator memory
+func (inst *EmulatorInstance) readCString(addr uint32) string {
+	builder := strings.Builder{}
+	for i := uint32(0); i < 4096; i++ {
+		b := byte(inst.memReadByte(addr + i))
+		if b == 0 {
+			break
+		}
+		builder.WriteByte(b)
+	}
+	return builder.String()
+}
@@ -0,0 +1,437 @@
+package emulator
+
+import (
+	"math"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+)
+
+// RV32F single-precision floating point. The float registers hold the raw IEEE 754 bits. Results are
+// calculated in float64 and then rounded to float32 with the instruction's rounding mode, which is exact
+// for add, subtract, multiply, divide, and square root since float64 has more than twice the precision.
+// The fused multiply-adds round the sum to odd first, see executeFMA.
+
+const (
+	fflagNX = 1 << 0 // inexact
+	fflagUF = 1 << 1 // underflow
+	fflagOF = 1 << 2 // overflow
+	fflagDZ = 1 << 3 // divide by zero
+	fflagNV = 1 << 4 // invalid operation
+)
+
+const (
+	roundingModeRNE = 0b000
+	roundingModeRTZ = 0b001
+	roundingModeRDN = 0b010
+	roundingModeRUP = 0b011
+	roundingModeRMM = 0b100
+	roundingModeDYN = 0b111
+)
+
+const canonicalNaN = 0x7FC00000
+
+func (inst *EmulatorInstance) fregRead(reg uint32) uint32 {
+	// checking if the register is valid
+	if inst.fregInit&(1<<reg) == 0 && (inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd) {
+		inst.newException("Register accessed before initialized: f%d", reg)
+		return 0
+	}
+
+	return inst.fregisters[reg]
+}
+
+func (inst *EmulatorInstance) fregWrite(reg uint32, value uint32) {
+	if inst.trap != nil {
+		// the instruction faulted, so it does not write its result
+		return
+	}
+
+	// counting the register as used like regWrite does
+	if inst.fregInit&(1<<reg) == 0 && (inst.pc < inst.profileIgnoreRangeStart || inst.pc >= inst.profileIgnoreRangeEnd) {
+		inst.regUsage++
+	}
+	inst.fregInit |= 1 << reg
+	inst.fregisters[reg] = value
+	if inst.tracer != nil && inst.tracer.active {
+		inst.tracer.fregWrites |= 1 << reg
+	}
+}
+
+func (inst *EmulatorInstance) fregReadFloat(reg uint32) float32 {
+	return math.Float32frombits(inst.fregRead(reg))
+}
+
+func isSignalingNaN(bits uint32) bool {
+	return bits&0x7F800000 == 0x7F800000 && bits&0x007FFFFF != 0 && bits&0x00400000 == 0
+}
+
+// Resolves the dynamic rounding mode, returns false if the rounding mode is reserved
+func (inst *EmulatorInstance) getRoundingMode(rm uint32) (uint32, bool) {
+	if rm == roundingModeDYN {
+		rm = inst.frm
+	}
+	return rm, rm <= roundingModeRMM
+}
+
+// Rounds the result of an operation to float32 and accumulates the exception flags
+func (inst *EmulatorInstance) roundFloat(value float64, rm uint32) uint32 {
+	if math.IsNaN(value) {
+		return canonicalNaN
+	}
+
+	result := float32(value) // round to nearest, ties to even
+	if float64(result) != value {
+		inst.fflags |= fflagNX
+
+		switch rm {
+		case roundingModeRTZ:
+			if math.Abs(float64(result)) > math.Abs(value) {
+				result = math.Nextafter32(result, 0)
+			}
+		case roundingModeRDN:
+			if float64(result) > value {
+				result = math.Nextafter32(result, float32(math.Inf(-1)))
+			}
+		case roundingModeRUP:
+			if float64(result) < value {
+				result = math.Nextafter32(result, float32(math.Inf(1)))
+			}
+		case roundingModeRMM:
+			// only differs from ties to even when exactly halfway
+			other := math.Nextafter32(result, float32(math.Copysign(math.Inf(1), value-float64(result))))
+			if (float64(result)+float64(other))/2 == value && math.Abs(float64(other)) > math.Abs(float64(result)) {
+				result = other
+			}
+		}
+
+		if math.IsInf(float64(result), 0) || math.Abs(value) > math.MaxFloat32 {
+			inst.fflags |= fflagOF
+		} else if math.Abs(value) < 0x1p-126 {
+			inst.fflags |= fflagUF
+		}
+	}
+
+	return math.Float32bits(result)
+}
+
+// Rounds to an integer with the rounding mode, the result still needs to be range checked
+func roundToInteger(value float64, rm uint32) float64 {
+	switch rm {
+	case roundingModeRTZ:
+		return math.Trunc(value)
+	case roundingModeRDN:
+		return math.Floor(value)
+	case roundingModeRUP:
+		return math.Ceil(value)
+	case roundingModeRMM:
+		return math.Round(value)
+	}
+	return math.RoundToEven(value)
+}
+
+func (inst *EmulatorInstance) executeFLW(instruction uint32) {
+	_, rd, rs1, imm, _ := assembler.DecodeITypeInstruction(instruction)
+	// sign extending the immediate
+	if imm&0x800 != 0 {
+		imm |= 0xFFFFF000
+	}
+
+	inst.fregWrite(rd, inst.memReadWord(inst.regRead(rs1)+imm, false))
+}
+
+func (inst *EmulatorInstance) executeFSW(instruction uint32) {
+	_, rs1, rs2, imm, _ := assembler.DecodeSTypeInstruction(instruction)
+	// sign extending the immediate
+	if imm&0x800 != 0 {
+		imm |= 0xFFFFF000
+	}
+
+	inst.memWriteWord(inst.regRead(rs1)+imm, inst.fregRead(rs2))
+}
+
+func (inst *EmulatorInstance) executeFMA(instruction uint32) {
+	opcode, rd, rs1, rs2, rs3, format, rm := assembler.DecodeR4TypeInstruction(instruction)
+	rm, ok := inst.getRoundingMode(rm)
+	if format != 0b00 || !ok {
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported R4-Type instruction exception: op=%d fmt=%d rm=%d", opcode, format, rm)
+		return
+	}
+
+	a, b, c := inst.fregRead(rs1), inst.fregRead(rs2), inst.fregRead(rs3)
+	if isSignalingNaN(a) || isSignalingNaN(b) || isSignalingNaN(c) {
+		inst.fflags |= fflagNV
+	}
+
+	x := float64(math.Float32frombits(a))
+	y := float64(math.Float32frombits(b))
+	z := float64(math.Float32frombits(c))
+	switch opcode {
+	case assembler.OPCODE_FMSUB:
+		z = -z
+	case assembler.OPCODE_FNMSUB:
+		x = -x
+	case assembler.OPCODE_FNMADD:
+		x = -x
+		z = -z
+	}
+
+	// infinity times zero is invalid even when the addend is a quiet NaN
+	if (math.IsInf(x, 0) && y == 0) || (x == 0 && math.IsInf(y, 0)) {
+		inst.fflags |= fflagNV
+	}
+
+	// the product of two float32s is exact in float64, but the sum is not, and rounding it to float64 and
+	// then to float32 could round twice, e.g. to a tie that was not one. The error of the sum is recovered
+	// exactly (TwoSum) and kept in the lowest bit (round to odd), which is enough for roundFloat to round
+	// correctly in every rounding mode since float64 has more than 2 bits more than float32.
+	product := x * y
+	result := product + z
+	if !math.IsInf(result, 0) && !math.IsNaN(result) {
+		addend := result - product
+		err := (product - (result - addend)) + (z - addend)
+		if err != 0 && math.Float64bits(result)&1 == 0 {
+			result = math.Nextafter(result, math.Copysign(math.Inf(1), err))
+		}
+	}
+	if math.IsNaN(result) && !math.IsNaN(x) && !math.IsNaN(y) && !math.IsNaN(z) {
+		inst.fflags |= fflagNV
+	}
+	inst.fregWrite(rd, inst.roundFloat(result, rm))
+}
+
+func (inst *EmulatorInstance) executeFP(instruction uint32) {
+	opcode, rd, rs1, rs2, func7, func3 := assembler.DecodeRTypeInstruction(instruction)
+
+	unsupported := func() {
+		inst.newTrapException(TrapCauseIllegalInstruction, instruction, "Unsupported FP-Type instruction exception: op=%d func3=%d func7=%d", opcode, func3, func7)
+	}
+
+	switch func7 {
+	case 0b0000000, 0b0000100, 0b0001000, 0b0001100:
+		// FADD.S/FSUB.S/FMUL.S/FDIV.S
+		rm, ok := inst.getRoundingMode(func3)
+		if !ok {
+			unsupported()
+			return
+		}
+
+		a, b := inst.fregRead(rs1), inst.fregRead(rs2)
+		if isSignalingNaN(a) || isSignalingNaN(b) {
+			inst.fflags |= fflagNV
+		}
+		x := float64(math.Float32frombits(a))
+		y := float64(math.Float32frombits(b))
+
+		result := 0.0
+		switch func7 {
+		case 0b0000000:
+			result = x + y
+		case 0b0000100:
+			result = x - y
+		case 0b0001000:
+			result = x * y
+		case 0b0001100:
+			if y == 0 && x != 0 && !math.IsNaN(x) && !math.IsInf(x, 0) {
+				inst.fflags |= fflagDZ
+			}
+			result = x / y
+		}
+
+		if math.IsNaN(result) && !math.IsNaN(x) && !math.IsNaN(y) {
+			inst.fflags |= fflagNV
+		}
+		inst.fregWrite(rd, inst.roundFloat(result, rm))
+	case 0b0101100:
+		// FSQRT.S
+		rm, ok := inst.getRoundingMode(func3)
+		if !ok || rs2 != 0 {
+			unsupported()
+			return
+		}
+
+		a := inst.fregRead(rs1)
+		x := float64(math.Float32frombits(a))
+		if isSignalingNaN(a) || x < 0 {
+			inst.fflags |= fflagNV
+		}
+		inst.fregWrite(rd, inst.roundFloat(math.Sqrt(x), rm))
+	case 0b0010000:
+		// FSGNJ.S/FSGNJN.S/FSGNJX.S, these only operate on the sign bit
+		a, b := inst.fregRead(rs1), inst.fregRead(rs2)
+		switch func3 {
+		case 0b000:
+			inst.fregWrite(rd, a&0x7FFFFFFF|b&0x80000000)
+		case 0b001:
+			inst.fregWrite(rd, a&0x7FFFFFFF|^b&0x80000000)
+		case 0b010:
+			inst.fregWrite(rd, a^b&0x80000000)
+		default:
+			unsupported()
+		}
+	case 0b0010100:
+		// FMIN.S/FMAX.S
+		if func3 > 0b001 {
+			unsupported()
+			return
+		}
+
+		a, b := inst.fregRead(rs1), inst.fregRead(rs2)
+		if isSignalingNaN(a) || isSignalingNaN(b) {
+			inst.fflags |= fflagNV
+		}
+		x, y := math.Float32frombits(a), math.Float32frombits(b)
+
+		// a NaN is only returned when both are NaN, and -0 is less than +0
+		result := a
+		if x != x && y != y {
+			result = canonicalNaN
+		} else if x != x {
+			result = b
+		} else if y != y {
+			result = a
+		} else if func3 == 0b000 && (y < x || (x == y && b&0x80000000 != 0)) {
+			result = b
+		} else if func3 == 0b001 && (y > x || (x == y && b&0x80000000 == 0)) {
+			result = b
+		}
+		inst.fregWrite(rd, result)
+	case 0b1010000:
+		// FEQ.S/FLT.S/FLE.S
+		a, b := inst.fregRead(rs1), inst.fregRead(rs2)
+		x, y := math.Float32frombits(a), math.Float32frombits(b)
+
+		// feq only signals on signaling NaNs, the ordered comparisons signal on any NaN
+		isNaN := x != x || y != y
+		result := false
+		switch func3 {
+		case 0b010:
+			if isSignalingNaN(a) || isSignalingNaN(b) {
+				inst.fflags |= fflagNV
+			}
+			result = x == y
+		case 0b001:
+			if isNaN {
+				inst.fflags |= fflagNV
+			}
+			result = x < y
+		case 0b000:
+			if isNaN {
+				inst.fflags |= fflagNV
+			}
+			result = x <= y
+		default:
+			unsupported()
+			return
+		}
+
+		if result {
+			inst.regWrite(rd, 1)
+		} else {
+			inst.regWrite(rd, 0)
+		}
+	case 0b1100000:
+		// FCVT.W.S/FCVT.WU.S
+		rm, ok := inst.getRoundingMode(func3)
+		if !ok || rs2 > 1 {
+			unsupported()
+			return
+		}
+
+		x := float64(inst.fregReadFloat(rs1))
+		rounded := roundToInteger(x, rm)
+
+		// out of range values saturate, NaN is treated as positive infinity
+		minimum, maximum := float64(math.MinInt32), float64(math.MaxInt32)
+		if rs2 == 1 {
+			minimum, maximum = 0, math.MaxUint32
+		}
+
+		result := uint32(0)
+		if math.IsNaN(x) || rounded > maximum {
+			inst.fflags |= fflagNV
+			result = uint32(int64(maximum))
+		} else if rounded < minimum {
+			inst.fflags |= fflagNV
+			result = uint32(int64(minimum))
+		} else {
+			if rounded != x {
+				inst.fflags |= fflagNX
+			}
+			result = uint32(int64(rounded))
+		}
+		inst.regWrite(rd, result)
+	case 0b1101000:
+		// FCVT.S.W/FCVT.S.WU
+		rm, ok := inst.getRoundingMode(func3)
+		if !ok || rs2 > 1 {
+			unsupported()
+			return
+		}
+
+		x := float64(int32(inst.regRead(rs1)))
+		if rs2 == 1 {
+			x = float64(inst.regRead(rs1))
+		}
+		inst.fregWrite(rd, inst.roundFloat(x, rm))
+	case 0b1110000:
+		// FMV.X.W/FCLASS.S
+		if rs2 != 0 {
+			unsupported()
+			return
+		}
+
+		switch func3 {
+		case 0b000:
+			inst.regWrite(rd, inst.fregRead(rs1))
+		case 0b001:
+			inst.regWrite(rd, classifyFloat(inst.fregRead(rs1)))
+		default:
+			unsupported()
+		}
+	case 0b1111000:
+		// FMV.W.X
+		if rs2 != 0 || func3 != 0b000 {
+			unsupported()
+			return
+		}
+
+		inst.fregWrite(rd, inst.regRead(rs1))
+	default:
+		unsupported()
+	}
+}
+
+// Returns the FCLASS.S mask, exactly one bit is set
+func classifyFloat(bits uint32) uint32 {
+	negative := bits&0x80000000 != 0
+	exponent := bits & 0x7F800000
+	fraction := bits & 0x007FFFFF
+
+	switch {
+	case exponent == 0x7F800000 && fraction == 0:
+		if negative {
+			return 1 << 0 // -infinity
+		}
+		return 1 << 7 // +infinity
+	case exponent == 0x7F800000:
+		if isSignalingNaN(bits) {
+			return 1 << 8
+		}
+		return 1 << 9 // quiet NaN
+	case exponent == 0 && fraction == 0:
+		if negative {
+			return 1 << 3 // -0
+		}
+		return 1 << 4 // +0
+	case exponent == 0:
+		if negative {
+			return 1 << 2 // negative subnormal
+		}
+		return 1 << 5 // positive subnormal
+	default:
+		if negative {
+			return 1 << 1 // negative normal
+		}
+		return 1 << 6 // positive normal
+	}
+}
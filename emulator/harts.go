@@ -0,0 +1,112 @@
+package emulator
+
+// Multi-hart emulation. Every hart has its own registers, pc, CSRs, and call stack, but they all share the
+// memory and peripherals. Only one hart runs at a time: the running hart's hartContext is embedded in the
+// EmulatorInstance so the rest of the emulator does not need to know about harts, and it is swapped with the
+// saved context of the next running hart in round-robin order. The number of instructions a hart runs before
+// being switched out is random, but drawn from the random seed, so a run is reproducible while different
+// seeds interleave the harts differently. Harts are never switched inside the OS or an interrupt handler, so
+// ECALLs and interrupts are atomic.
+//
+// Hart 0 runs the program, the other harts start parked and are started through the hart control registers
+// (see memReadReserved). A started hart that returns to 0x20352035 is parked again. The program ends when
+// hart 0 returns or any hart calls exit.
+
+const hartQuantumMax = 64 // the most instructions a hart runs before the next hart is scheduled
+
+const noReservation = 0xFFFFFFFF // never matches since reservations are word aligned
+
+// Parks every hart except hart 0, which becomes the running hart
+func (inst *EmulatorInstance) resetHarts() {
+	inst.hartID = 0
+	inst.running = true
+	for i := range inst.harts {
+		inst.harts[i] = hartContext{hartID: uint32(i)}
+		inst.reservations[i] = noReservation
+	}
+	inst.hartQuantum = 0
+	inst.exited = false
+}
+
+// Called before every instruction, switches harts when the quantum of the running hart is used up
+func (inst *EmulatorInstance) scheduleHarts() {
+	if len(inst.harts) == 1 || inst.isInOSCode || inst.interrupt != nil {
+		return
+	}
+
+	if inst.hartQuantum > 0 {
+		inst.hartQuantum--
+		return
+	}
+
+	inst.switchHart()
+}
+
+// Saves the running hart and restores the next running hart after it, which may be the same hart
+func (inst *EmulatorInstance) switchHart() {
+	inst.harts[inst.hartID] = inst.hartContext
+	for i := 1; i <= len(inst.harts); i++ {
+		next := (int(inst.hartID) + i) % len(inst.harts)
+		if inst.harts[next].running {
+			inst.hartContext = inst.harts[next]
+			break
+		}
+	}
+
+	inst.hartQuantum = uint32(inst.scheduler.Intn(hartQuantumMax))
+	inst.schedulerDraws++
+}
+
+// Returns the context of the given hart, the running hart's context is not saved in harts
+func (inst *EmulatorInstance) getHart(id uint32) *hartContext {
+	if id == inst.hartID {
+		return &inst.hartContext
+	}
+	return &inst.harts[id]
+}
+
+// Starts a parked hart with the start address, argument, and stack pointer set in the hart control registers
+func (inst *EmulatorInstance) startHart(id uint32) {
+	if id >= uint32(len(inst.harts)) || inst.getHart(id).running {
+		inst.newException("Cannot start hart %d, only parked harts can be started and there are %d harts", id, len(inst.harts))
+		return
+	}
+
+	hart := hartContext{
+		hartID:            id,
+		running:           true,
+		regInit:           0x10F | 1<<10,
+		pc:                inst.hartStart[0] - 4,
+		instructionLength: 4,
+		callStack:         []uint32{},
+	}
+	hart.registers[1] = 0x20352035 // returning parks the hart
+	hart.registers[2] = inst.hartStart[2]
+	hart.registers[3] = inst.userGlobalPointer
+	hart.registers[8] = inst.hartStart[2] // frame pointer
+	hart.registers[10] = inst.hartStart[1]
+
+	inst.harts[id] = hart
+	inst.reservations[id] = noReservation
+}
+
+// Returns a bitmap with bit n set while hart n is running
+func (inst *EmulatorInstance) getRunningHarts() uint32 {
+	running := uint32(0)
+	for i := range inst.harts {
+		if inst.getHart(uint32(i)).running {
+			running |= 1 << i
+		}
+	}
+	return running
+}
+
+// Invalidates the reservations of every hart on the word at addr, called on every store
+func (inst *EmulatorInstance) clearReservations(addr uint32) {
+	addr &^= 0x3
+	for i, reservation := range inst.reservations {
+		if reservation == addr {
+			inst.reservations[i] = noReservation
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package emulator
+
+// Interrupts are delivered to the handler the OS registers at 0x80003010. When one is delivered, the
+// user context is saved and the handler is called with ra set to the magic number 0x20352037, so
+// returning from the handler resumes the interrupted code. Interrupts do not nest: any that are raised
+// while a handler (or an ECALL) is running stay pending until it returns. The same goes for the OS setup,
+// which registers the handler before it is ready to service interrupts, so none are delivered until the
+// program starts.
+
+const (
+	InterruptIDTimer    = 1 // raised every N user instructions, see the timer period register
+	InterruptIDSoftware = 2 // raised by writing to the software interrupt trigger register
+	InterruptIDKeyboard = 3 // raised on every key press and release
+	InterruptIDMouse    = 4 // raised when the mouse moves or a button changes
+)
+
+const maxPendingInterrupts = 64
+
+// Queues an interrupt to be delivered. Masked interrupts are discarded. Safe to call from other goroutines.
+func (inst *EmulatorInstance) Interrupt(interrupt *Interrupt) {
+	inst.interruptMutex.Lock()
+	defer inst.interruptMutex.Unlock()
+
+	if interrupt.ID >= 32 || inst.interruptMask&(1<<interrupt.ID) == 0 {
+		return // masked
+	}
+
+	if len(inst.pendingInterrupts) >= maxPendingInterrupts {
+		return // dropped, the handler is not keeping up
+	}
+
+	inst.pendingInterrupts = append(inst.pendingInterrupts, interrupt)
+	inst.hasPendingInterrupt.Store(true)
+}
+
+// Returns a bitmap of the IDs of the interrupts that are waiting to be delivered
+func (inst *EmulatorInstance) getPendingInterrupts() uint32 {
+	inst.interruptMutex.Lock()
+	defer inst.interruptMutex.Unlock()
+
+	pending := uint32(0)
+	for _, interrupt := range inst.pendingInterrupts {
+		pending |= 1 << interrupt.ID
+	}
+	return pending
+}
+
+func (inst *EmulatorInstance) setInterruptMask(mask uint32) {
+	inst.interruptMutex.Lock()
+	defer inst.interruptMutex.Unlock()
+
+	inst.interruptMask = mask
+
+	// discarding pending interrupts that are now masked
+	kept := inst.pendingInterrupts[:0]
+	for _, interrupt := range inst.pendingInterrupts {
+		if mask&(1<<interrupt.ID) != 0 {
+			kept = append(kept, interrupt)
+		}
+	}
+	inst.pendingInterrupts = kept
+	inst.hasPendingInterrupt.Store(len(kept) > 0)
+}
+
+func (inst *EmulatorInstance) tickTimer() {
+	inst.timerCounter++
+	if inst.timerCounter < inst.timerPeriod {
+		return
+	}
+	inst.timerCounter = 0
+
+	// only one timer interrupt is outstanding at a time, otherwise a slow handler would never catch up
+	if (inst.interrupt != nil && inst.interrupt.ID == InterruptIDTimer) || inst.getPendingInterrupts()&(1<<InterruptIDTimer) != 0 {
+		return
+	}
+
+	inst.Interrupt(&Interrupt{
+		ID:   InterruptIDTimer,
+		Data: []uint32{inst.di},
+	})
+}
+
+// Saves the user context and jumps to the OS interrupt handler. The caller must make sure that an
+// interrupt is pending and that the emulator is not already servicing one.
+func (inst *EmulatorInstance) deliverInterrupt() {
+	inst.interruptMutex.Lock()
+	if len(inst.pendingInterrupts) == 0 {
+		inst.hasPendingInterrupt.Store(false)
+		inst.interruptMutex.Unlock()
+		return
+	}
+	interrupt := inst.pendingInterrupts[0]
+	inst.pendingInterrupts = inst.pendingInterrupts[1:]
+	inst.hasPendingInterrupt.Store(len(inst.pendingInterrupts) > 0)
+	inst.interruptMutex.Unlock()
+
+	// need to interrupt, save the current state
+	interrupt.pc = inst.pc
+
+	// saving registers
+	for i := 0; i < 32; i++ {
+		interrupt.registers[i] = inst.registers[i]
+	}
+
+	// saving call stack
+	interrupt.callStack = make([]uint32, len(inst.callStack))
+	copy(interrupt.callStack, inst.callStack)
+
+	inst.interrupt = interrupt
+	inst.registers[3] = inst.osGlobalPointer
+	inst.registers[1] = 0x20352037 // 0x20352037 is the magic number for the RISC-V emulator to know when to resume from an interrupt
+	inst.pc = inst.osInterruptHandlerEntry
+}
+
+// Restores the context saved when the current interrupt was delivered
+func (inst *EmulatorInstance) resumeFromInterrupt() {
+	inst.pc = inst.interrupt.pc
+	for i := 0; i < 32; i++ {
+		inst.registers[i] = inst.interrupt.registers[i]
+	}
+	inst.callStack = make([]uint32, len(inst.interrupt.callStack))
+	copy(inst.callStack, inst.interrupt.callStack)
+	inst.interrupt = nil
+}
@@ -0,0 +1,242 @@
+package emulator
+
+import "errors"
+
+// Execution journal for reverse debugging. Before every user instruction the journal records the hart that
+// runs it, its pc, and its call stack depth, and while it runs, the registers and memory words it writes.
+// That is enough to find the instruction to step back to and the breakpoints that were hit on the way. The
+// entries are kept in a ring buffer along with a checkpoint, a snapshot taken at the breakpoint check (see
+// snapshot.go), every journalCheckpointInterval instructions.
+//
+// Going back to an earlier instruction restores the last checkpoint before it and runs forward to it again
+// with the output and breakpoints silenced, which gives the same state since emulation is deterministic.
+// When the ring is full, the oldest checkpoint and its entries are dropped, so the debugger can go back
+// between journalCheckpointInterval*(journalMaxCheckpoints-1) and
+// journalCheckpointInterval*journalMaxCheckpoints instructions. Keyboard and mouse input is not journaled,
+// so input that arrived after the checkpoint is not seen again when running forward to the target.
+//
+// Journaling is opt-in, the debugger only enables it when the launch configuration sets reverseDebugging.
+// Checkpoints share memory pages and files copy-on-write and only copy the pixels the program wrote, and only
+// when the display changed since the previous checkpoint.
+
+const journalCheckpointInterval = 4096 // user instructions between checkpoints
+const journalMaxCheckpoints = 64
+
+type journalEntry struct {
+	di        uint32 // the dynamic instruction count when the instruction was about to run, which identifies it
+	hart      uint32
+	pc        uint32
+	depth     int      // call stack depth, for stepping back over calls
+	regWrites uint32   // bit n is set when the instruction wrote register n
+	memWrites []uint32 // addresses the instruction stored to, reused when the entry is overwritten
+}
+
+type journalCheckpoint struct {
+	di       uint32
+	snapshot *Snapshot
+}
+
+type journalTravel struct {
+	target         uint32 // di of the entry to go back to
+	checkCondition bool   // reverse continue to a conditional breakpoint, which is only hit if its condition holds
+}
+
+type executionJournal struct {
+	entries     []journalEntry // ring buffer, the oldest entry is at start
+	start       int
+	length      int
+	checkpoints []journalCheckpoint // oldest first
+	request     *journalTravel      // set by the debugger while paused, done as soon as the emulator continues
+	replay      *journalTravel      // running forward to the target
+	scratch     journalEntry        // takes the writes made before the first entry is recorded
+
+	// the callbacks are silenced while running forward to the target
+	stdOutCallback       func(byte)
+	runtimeErrorCallback func(RuntimeException)
+	breakCallback        func(*EmulatorInstance, int, string)
+}
+
+// Records every user instruction from now on so the debugger can step back
+func (inst *EmulatorInstance) enableJournal() {
+	inst.journal = &executionJournal{
+		entries: make([]journalEntry, journalCheckpointInterval*journalMaxCheckpoints),
+	}
+}
+
+// Returns the ith oldest entry
+func (j *executionJournal) at(i int) *journalEntry {
+	return &j.entries[(j.start+i)%len(j.entries)]
+}
+
+// Returns the entry of the instruction that is running
+func (j *executionJournal) current() *journalEntry {
+	if j.length == 0 {
+		return &j.scratch
+	}
+	return j.at(j.length - 1)
+}
+
+// Called before every user instruction, right before the breakpoints are checked
+func (j *executionJournal) record(inst *EmulatorInstance) {
+	if len(j.checkpoints) == 0 || inst.di-j.checkpoints[len(j.checkpoints)-1].di >= journalCheckpointInterval {
+		j.checkpoint(inst)
+	}
+
+	entry := j.at(j.length)
+	j.length++
+	*entry = journalEntry{
+		di:        inst.di,
+		hart:      inst.hartID,
+		pc:        inst.pc,
+		depth:     len(inst.callStack),
+		memWrites: entry.memWrites[:0],
+	}
+
+	if j.replay != nil && inst.di == j.replay.target {
+		j.arrive(inst)
+	}
+}
+
+func (j *executionJournal) checkpoint(inst *EmulatorInstance) {
+	var previous *Snapshot
+	if len(j.checkpoints) != 0 {
+		previous = j.checkpoints[len(j.checkpoints)-1].snapshot
+	}
+
+	if len(j.checkpoints) == journalMaxCheckpoints {
+		// dropping the oldest checkpoint and the entries that could only be reached from it
+		copy(j.checkpoints, j.checkpoints[1:])
+		j.checkpoints = j.checkpoints[:len(j.checkpoints)-1]
+		for j.length > 0 && j.at(0).di < j.checkpoints[0].di {
+			j.start = (j.start + 1) % len(j.entries)
+			j.length--
+		}
+	}
+
+	snapshot := inst.snapshot(previous)
+	snapshot.pausedAtBreakCheck = true // restoring it continues with the breakpoint check of this instruction
+	j.checkpoints = append(j.checkpoints, journalCheckpoint{inst.di, snapshot})
+}
+
+// Returns the journal if the debugger can travel back from where the emulator is paused
+func (inst *EmulatorInstance) pausedJournal() (*executionJournal, error) {
+	if inst.journal == nil {
+		return nil, errors.New("reverse debugging is not enabled, set reverseDebugging in the launch configuration")
+	}
+	if !inst.checkingBreakpoints || inst.journal.length == 0 {
+		return nil, errors.New("can only go back while paused on an instruction")
+	}
+	return inst.journal, nil
+}
+
+// Goes back to the previous instruction of the paused hart, stepping over calls, once the emulator continues
+func (inst *EmulatorInstance) stepBack() error {
+	j, err := inst.pausedJournal()
+	if err != nil {
+		return err
+	}
+
+	depth := len(inst.callStack)
+	for i := j.length - 1; i >= 0; i-- {
+		entry := j.at(i)
+		if entry.di < inst.di && entry.hart == inst.hartID && entry.depth <= depth {
+			j.request = &journalTravel{target: entry.di}
+			return nil
+		}
+	}
+	return errors.New("cannot step back any further, the journal does not go back that far")
+}
+
+// Goes back to the last instruction that hit a breakpoint, or as far back as the journal goes, once the
+// emulator continues
+func (inst *EmulatorInstance) reverseContinue() error {
+	j, err := inst.pausedJournal()
+	if err != nil {
+		return err
+	}
+
+	j.request = inst.findReverseBreakpoint()
+	return nil
+}
+
+// Searches the journal backwards from the running instruction for one that hits a breakpoint
+func (inst *EmulatorInstance) findReverseBreakpoint() *journalTravel {
+	j := inst.journal
+	for i := j.length - 1; i >= 0; i-- {
+		entry := j.at(i)
+		if entry.di >= inst.di {
+			continue
+		}
+
+		for reg := range inst.registerBreakpoints {
+			if entry.regWrites&(1<<reg) != 0 {
+				return &journalTravel{target: entry.di}
+			}
+		}
+		for _, addr := range entry.memWrites {
+			if _, ok := inst.memoryBreakpoints[addr]; ok {
+				return &journalTravel{target: entry.di}
+			}
+		}
+		if bp, ok := inst.breakpoints[entry.pc]; ok {
+			// the condition depends on the state at the breakpoint, so it is checked once the emulator is there
+			return &journalTravel{target: entry.di, checkCondition: bp.condition != ""}
+		}
+	}
+
+	return &journalTravel{target: j.at(0).di}
+}
+
+// Restores the last checkpoint before the requested instruction and starts running forward to it
+func (inst *EmulatorInstance) travel() {
+	j := inst.journal
+	travel := j.request
+	j.request = nil
+
+	i := len(j.checkpoints) - 1
+	for i > 0 && j.checkpoints[i].di > travel.target {
+		i--
+	}
+	checkpoint := j.checkpoints[i]
+
+	// the instructions after the checkpoint are recorded again on the way to the target
+	j.checkpoints = j.checkpoints[:i+1]
+	for j.length > 0 && j.current().di > checkpoint.di {
+		j.length--
+	}
+
+	if j.replay == nil {
+		j.stdOutCallback = inst.stdOutCallback
+		j.runtimeErrorCallback = inst.runtimeErrorCallback
+		j.breakCallback = inst.breakCallback
+		inst.stdOutCallback = nil
+		inst.runtimeErrorCallback = nil
+		inst.breakCallback = func(*EmulatorInstance, int, string) {}
+	}
+	inst.breakNext = false
+	inst.breakAddr = 0xFFFFFFFF
+
+	inst.Restore(checkpoint.snapshot)
+	j.replay = travel
+	if checkpoint.di == travel.target {
+		j.arrive(inst)
+	}
+}
+
+// Called when running forward reaches the target, pauses the emulator there
+func (j *executionJournal) arrive(inst *EmulatorInstance) {
+	travel := j.replay
+	j.replay = nil
+	inst.stdOutCallback = j.stdOutCallback
+	inst.runtimeErrorCallback = j.runtimeErrorCallback
+	inst.breakCallback = j.breakCallback
+
+	if travel.checkCondition && !inst.breakpointConditionHolds(inst.breakpoints[inst.pc].condition) {
+		// the breakpoint was not hit here, so going further back
+		j.request = inst.findReverseBreakpoint()
+		return
+	}
+
+	inst.breakNext = true
+	inst.breakHart = inst.hartID
+}
@@ -9,7 +9,11 @@ func (inst *EmulatorInstance) memReadByte(addr uint32) uint32 {
 	bitmask := uint32(0xFF)
 	bitmask <<= (addr & 0x3) << 3
 
-	return inst.memReadRaw(addr, bitmask, false)
+	value := inst.memReadRaw(addr, bitmask, false)
+	if inst.tracer != nil {
+		inst.tracer.memAccess(addr, 1, value, false)
+	}
+	return value
 }
 
 func (inst *EmulatorInstance) memReadHalf(addr uint32) uint32 {
@@ -23,7 +27,11 @@ func (inst *EmulatorInstance) memReadHalf(addr uint32) uint32 {
 		return 0
 	}
 
-	return inst.memReadRaw(addr, bitmask, false)
+	value := inst.memReadRaw(addr, bitmask, false)
+	if inst.tracer != nil {
+		inst.tracer.memAccess(addr, 2, value, false)
+	}
+	return value
 }
 
 func (inst *EmulatorInstance) memReadWord(addr uint32, isInstruction bool) uint32 {
@@ -33,7 +41,11 @@ func (inst *EmulatorInstance) memReadWord(addr uint32, isInstruction bool) uint3
 		return 0
 	}
 
-	return inst.memReadRaw(addr, 0xFFFFFFFF, isInstruction)
+	value := inst.memReadRaw(addr, 0xFFFFFFFF, isInstruction)
+	if inst.tracer != nil && !isInstruction {
+		inst.tracer.memAccess(addr, 4, value, false)
+	}
+	return value
 }
 
 func (inst *EmulatorInstance) memReadRaw(addr uint32, bitmask uint32, isInstruction bool) uint32 {
@@ -44,6 +56,10 @@ func (inst *EmulatorInstance) memReadRaw(addr uint32, bitmask uint32, isInstruct
 		// reserved memory
 		return (inst.memReadReserved(addr&0xFFFFFFFF) & bitmask) >> ((addr & 0x3) * 8)
 	}
+	if inst.caches != nil && !isInstruction {
+		// fetches are simulated once per instruction, see cacheSim.go
+		inst.caches.data(inst, addr, false)
+	}
 	if isInstruction && inst.iCache.StartAddr != blockAddr {
 		newBlock, ok := inst.memory.Blocks[blockAddr>>12]
 		if !ok {
@@ -89,6 +105,9 @@ func (inst *EmulatorInstance) memWriteRaw(addr, bitmask, value uint32) {
 		inst.memWriteReserved(addr&0xFFFFFFFF, bitmask, value)
 		return
 	}
+	if inst.caches != nil {
+		inst.caches.data(inst, addr, true)
+	}
 
 	if inst.dCache.StartAddr != blockAddr {
 		newBlock, ok := inst.memory.Blocks[blockAddr>>12]
@@ -103,6 +122,19 @@ func (inst *EmulatorInstance) memWriteRaw(addr, bitmask, value uint32) {
 		inst.dCache = newBlock
 	}
 
+	if inst.dCache.shared.Load() {
+		// the page is shared with other images, see MemoryImage.Clone
+		sharedPage := inst.dCache
+		inst.dCache = inst.memory.unsharePage(blockAddr >> 12)
+		if inst.iCache == sharedPage {
+			inst.iCache = inst.dCache
+		}
+	}
+
+	// stores by any hart break lr.w reservations on the word, and stores to code invalidate its decoded instructions
+	inst.clearReservations(addr)
+	inst.invalidateDecoded(addr)
+
 	// now that the cache is loaded, we can write to it
 	offset := (addr & 0xFFF) >> 2
 	inst.dCache.Block[offset] = (inst.dCache.Block[offset] & ^bitmask) | (value << ((addr & 0x3) * 8))
@@ -113,6 +145,11 @@ func (inst *EmulatorInstance) memWriteRaw(addr, bitmask, value uint32) {
 			inst.memUsage++
 		}
 
+		if inst.journal != nil {
+			entry := inst.journal.current()
+			entry.memWrites = append(entry.memWrites, addr)
+		}
+
 		if bp, ok := inst.memoryBreakpoints[addr]; ok {
 			inst.breakCallback(inst, bp.ID, "data breakpoint")
 		}
@@ -127,6 +164,9 @@ func (inst *EmulatorInstance) memWriteByte(addr, value uint32) {
 	bitmask <<= (addr & 0x3) << 3
 
 	inst.memWriteRaw(addr, bitmask, value)
+	if inst.tracer != nil {
+		inst.tracer.memAccess(addr, 1, value, true)
+	}
 }
 
 func (inst *EmulatorInstance) memWriteHalf(addr, value uint32) {
@@ -141,6 +181,9 @@ func (inst *EmulatorInstance) memWriteHalf(addr, value uint32) {
 	}
 
 	inst.memWriteRaw(addr, bitmask, value)
+	if inst.tracer != nil {
+		inst.tracer.memAccess(addr, 2, value, true)
+	}
 }
 
 func (inst *EmulatorInstance) memWriteWord(addr, value uint32) {
@@ -151,15 +194,47 @@ func (inst *EmulatorInstance) memWriteWord(addr, value uint32) {
 	}
 	//sendOutput(fmt.Sprintf("writing to addr %d\n", addr), true)
 	inst.memWriteRaw(addr, 0xFFFFFFFF, value)
+	if inst.tracer != nil {
+		inst.tracer.memAccess(addr, 4, value, true)
+	}
 }
 
 func (inst *EmulatorInstance) memReadReserved(addr uint32) uint32 {
 	/*
 	 * Reserved Memory Map
-	 * 0x80000000 - 0x80002FEB: Future Reserved
+	 * 0x80000000 - 0x80002DFF: Future Reserved
+	 *
+	 * 0x80002E00 - 0x80002E1F: Keyboard Key State Bitmap READONLY (bit n set while key code n is held)
+	 * 0x80002E20 - 0x80002E23: Keyboard Event FIFO READONLY (pops an event, see popKeyEvent)
+	 * 0x80002E24 - 0x80002E27: Keyboard Event FIFO Count READONLY
+	 * 0x80002E28 - 0x80002E3F: Future Reserved
+	 * 0x80002E40 - 0x80002E43: Mouse X READONLY
+	 * 0x80002E44 - 0x80002E47: Mouse Y READONLY
+	 * 0x80002E48 - 0x80002E4B: Mouse Buttons READONLY
+	 * 0x80002E4C - 0x80002E7F: Future Reserved
+	 * 0x80002E80 - 0x80002E83: Hart Count READONLY
+	 * 0x80002E84 - 0x80002E87: Hart ID READONLY (of the hart doing the access)
+	 * 0x80002E88 - 0x80002E8B: Hart Start Address
+	 * 0x80002E8C - 0x80002E8F: Hart Start Argument (passed in a0)
+	 * 0x80002E90 - 0x80002E93: Hart Start Stack Pointer
+	 * 0x80002E94 - 0x80002E97: Hart Start WRITEONLY (starts the parked hart ID written, see harts.go)
+	 * 0x80002E98 - 0x80002E9B: Running Harts READONLY (bit n set while hart n is running)
+	 * 0x80002E9C - 0x80002EFF: Future Reserved
 	 *
+	 * 0x80002F00 - 0x80002F03: Virtual Display Double Buffering Enable (1 draws to the back buffer, see peripherals.go)
+	 * 0x80002F04 - 0x80002F07: Virtual Display Present WRITEONLY (shows the back buffer and counts the frame)
+	 * 0x80002F08 - 0x80002F0B: Virtual Display Frame Counter READONLY (frames presented)
+	 * 0x80002F0C - 0x80002F0F: Virtual Display VSync Status READONLY (display refreshes since the last present)
+	 * 0x80002F10 - 0x80002FCF: Future Reserved
+	 *
+	 * 0x80002FD0 - 0x80002FD3: Interrupt Enable Mask (bit n enables interrupt ID n)
+	 * 0x80002FD4 - 0x80002FD7: Timer Interrupt Period (in user instructions, 0 disables)
+	 * 0x80002FD8 - 0x80002FDB: Pending Interrupts READONLY (bit n set when interrupt ID n is pending)
+	 * 0x80002FDC - 0x80002FDF: Software Interrupt Trigger WRITEONLY (raises the interrupt ID written)
+	 * 0x80002FE0 - 0x80002FE3: Virtual Display Draw Command WRITEONLY (executes the command on write, see draw.go)
+	 * 0x80002FE4 - 0x80002FEB: Virtual Display Draw Parameters 4-5 WRITEONLY
 	 * 0x80002FEC - 0x80002FEF: Virtual Display Shape Draw Filled Rectangle Color (executes the draw on write)
-	 * 0x80002FF0 - 0x80002FFF: Virtual Display Shape Draw Parameters
+	 * 0x80002FF0 - 0x80002FFF: Virtual Display Shape Draw Parameters 0-3
 	 * 0x80003000 - 0x80003003: OS ECALL Handler Entry Point
 	 * 0x80003004 - 0x80003007: StdOut Pipe WRITEONLY
 	 * 0x80003008 - 0x8000300B: Virtual Display Width
@@ -171,147 +246,31 @@ func (inst *EmulatorInstance) memReadReserved(addr uint32) uint32 {
 	 * 0x80003020 - 0x8000FFFF: Interrupt context
 	 *
 	 * 0x80010000 - 0x807FFFFF: Virtual Display Pixel Data [RGBA][RGBA]...
-	 * 0x80800000 - 0xFFFFFFFF: Virutal FAT Storage Filesystem READONLY (for now)
+	 * 0x80800000 - 0xFFFFFFFF: Virutal FAT Storage Filesystem (see filesystem.go for the layout)
+	 *
+	 * Each device is a Peripheral on the bus of the emulator (see bus.go), assignments can attach their own
+	 * in the future reserved ranges
 	 */
 
-	addr &= 0x7FFFFFFF
-
-	if addr < 0x3000 {
+	device := inst.bus.find(addr)
+	if device == nil {
 		// future reserved - create a new memory access exception
-		inst.newSegmentationFaultException(addr)
+		inst.newSegmentationFaultException(addr & 0x7FFFFFFF)
 		return 0
-	} else if addr < 0x3020 {
-		switch addr {
-		case 0x3000:
-			// OS ECALL Handler Entry Point
-			return inst.osEntry
-		case 0x3004:
-			// StdOut Pipe WRITEONLY
-			inst.newSegmentationFaultException(addr)
-			return 0
-		case 0x3008:
-			// Virtual Display Width
-			return uint32(inst.display.width)
-		case 0x300C:
-			// Virtual Display Height
-			return uint32(inst.display.height)
-		case 0x3010:
-			// OS Interrupt Handler Entry Point
-			return inst.osInterruptHandlerEntry
-		case 0x3014:
-			return inst.randomSeed
-		case 0x3018:
-			// Solution Correctness WRITEONLY (only from OS code)
-			inst.newSegmentationFaultException(addr)
-			return 0
-		case 0x301C:
-			// Interrupt ID
-			if inst.interrupt == nil {
-				return 0
-			}
-			return uint32(inst.interrupt.ID)
-
-		}
-	} else if addr < 0x10000 {
-		// interrupt context data
-		offset := addr - 0x3020
-		if inst.interrupt == nil || ((offset >> 2) > uint32(len(inst.interrupt.Data))) {
-			inst.newSegmentationFaultException(addr)
-			return 0
-		}
-
-		return inst.interrupt.Data[offset>>2]
-	} else if addr < 0x00800000 {
-		// Virtual Display Pixel Data
-		offset := addr - 0x10000
-		return inst.display.data[offset>>2]
-	} else if addr < 0x80000000 {
-		offset := addr - 0x100000
-		if inst.fs == nil || ((offset >> 2) > uint32(len(inst.fs.data))) {
-			inst.newSegmentationFaultException(addr)
-			return 0
-		}
-		return inst.fs.data[offset>>2]
 	}
 
-	return 0 // not possible
+	value, ok := device.peripheral.Read(inst, addr-device.start)
+	if !ok {
+		inst.newSegmentationFaultException(addr & 0x7FFFFFFF)
+		return 0
+	}
+	return value
 }
 
 func (inst *EmulatorInstance) memWriteReserved(addr, bitmask, value uint32) {
-	addr &= 0x7FFFFFFF
-
-	// memory map is defined in memReadReserved
-	if addr < 0x2FEC {
-		// future reserved - create a new memory access exception
-		inst.newSegmentationFaultException(addr)
-		return
-	} else if addr < 0x3020 {
-		switch addr & 0xFFFFFFFC {
-		case 0x2FEC:
-			// Virtual Display Shape Draw Filled Rectangle Color (executes the draw on write)
-			inst.display.drawFilledRectangle(value)
-		case 0x2FF0:
-			// Virtual Display Shape Draw Parameters 0
-			inst.display.shapeDrawParams[0] = value
-		case 0x2FF4:
-			// Virtual Display Shape Draw Parameters 1
-			inst.display.shapeDrawParams[1] = value
-		case 0x2FF8:
-			// Virtual Display Shape Draw Parameters 2
-			inst.display.shapeDrawParams[2] = value
-		case 0x2FFC:
-			// Virtual Display Shape Draw Parameters 3
-			inst.display.shapeDrawParams[3] = value
-		case 0x3000:
-			// OS ECALL Handler Entry Point
-			inst.osEntry = value
-		case 0x3004:
-			// StdOut Pipe WRITEONLY
-			if inst.stdOutCallback != nil {
-				inst.stdOutCallback(byte(value))
-			}
-		case 0x3008:
-			// Virtual Display Width
-			inst.display.width = int(value)
-		case 0x300C:
-			// Virtual Display Height
-			inst.display.height = int(value)
-		case 0x3010:
-			// OS Interrupt Handler Entry Point
-			inst.osInterruptHandlerEntry = value
-		case 0x3014:
-			// Random number seed READONLY
-			inst.newSegmentationFaultException(addr)
-			return
-		case 0x3018:
-			// Solution Correctness WRITEONLY (only from OS code)
-			if inst.pc >= inst.profileIgnoreRangeStart && inst.pc < inst.profileIgnoreRangeEnd {
-				inst.solutionValidity = value
-			} else {
-				inst.newSegmentationFaultException(addr)
-			}
-		case 0x301C:
-			// Interrupt ID
-			inst.newSegmentationFaultException(addr)
-			return
-		}
-	} else if addr < 0x10000 {
-		// interrupt data READONLY - create a new memory access exception
-		inst.newSegmentationFaultException(addr)
-		return
-	} else if addr < 0x00800000 {
-		offset := addr - 0x10000
-		updateRegion := inst.display.getUpdateOffset(offset >> 2)
-
-		// Virtual Display Pixel Data
-		inst.display.dataMutex.Lock()
-		inst.display.displayWrites++
-		inst.display.data[offset>>2] = (inst.display.data[offset>>2] & ^bitmask) | (value << ((addr & 0x3) * 8))
-		inst.display.updateRegions[updateRegion] = true
-		inst.display.dataMutex.Unlock()
-	} else if addr < 0x80000000 {
-		// Virtual FAT Storage Filesystem READONLY (for now)
-		inst.newSegmentationFaultException(addr)
-		return
+	// memory map is defined in memReadReserved, the devices are in bus.go
+	device := inst.bus.find(addr)
+	if device == nil || !device.peripheral.Write(inst, addr-device.start, bitmask, value) {
+		inst.newSegmentationFaultException(addr & 0x7FFFFFFF)
 	}
 }
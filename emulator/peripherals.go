@@ -18,17 +18,10 @@ func (s *VirtualDisplay) GetUpdates() []VirtualDisplayUpdate {
 	for y := 0; y < s.height; y += 16 {
 		for x := 0; x < s.width; x += 16 {
 			if s.updateRegions[(y>>4)*numRegionsPerRow+(x>>4)] {
-				outData := make([]uint32, 16*16)
-				for ox := 0; ox < 16; ox++ {
-					for oy := 0; oy < 16; oy++ {
-						outData[oy*16+ox] = s.data[(y+oy)*s.width+(x+ox)]
-					}
-				}
-
 				updates = append(updates, VirtualDisplayUpdate{
 					RegionX: x,
 					RegionY: y,
-					Data:    outData,
+					Data:    s.getTile(x, y),
 				})
 				s.updateRegions[(y>>4)*numRegionsPerRow+(x>>4)] = false
 			}
@@ -47,17 +40,10 @@ func (s *VirtualDisplay) GetEntireScreen() []VirtualDisplayUpdate {
 	updates := make([]VirtualDisplayUpdate, 0)
 	for y := 0; y < s.height; y += 16 {
 		for x := 0; x < s.width; x += 16 {
-			outData := make([]uint32, 16*16)
-			for ox := 0; ox < 16; ox++ {
-				for oy := 0; oy < 16; oy++ {
-					outData[oy*16+ox] = s.data[(y+oy)*s.width+(x+ox)]
-				}
-			}
-
 			updates = append(updates, VirtualDisplayUpdate{
 				RegionX: x,
 				RegionY: y,
-				Data:    outData,
+				Data:    s.getTile(x, y),
 			})
 			s.updateRegions[(y>>4)*numRegionsPerRow+(x>>4)] = false
 		}
@@ -66,6 +52,36 @@ func (s *VirtualDisplay) GetEntireScreen() []VirtualDisplayUpdate {
 	return updates
 }
 
+// Returns the 16x16 pixels at x, y row by row, the pixels of tiles on the right and bottom edges that are
+// past the display are zero
+func (s *VirtualDisplay) getTile(x, y int) []uint32 {
+	tile := make([]uint32, 16*16)
+	for oy := 0; oy < 16 && y+oy < s.height; oy++ {
+		for ox := 0; ox < 16 && x+ox < s.width; ox++ {
+			tile[oy*16+ox] = s.data[(y+oy)*s.width+(x+ox)]
+		}
+	}
+	return tile
+}
+
+func (s *VirtualDisplay) getSize() (int, int) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	return s.width, s.height
+}
+
+// Returns a copy of the pixels on screen, row by row, and the size of the display
+func (s *VirtualDisplay) getFrame() ([]uint32, int, int) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	size := s.width * s.height
+	if size < 0 || size > len(s.data) {
+		size = 0
+	}
+	return append([]uint32{}, s.data[:size]...), s.width, s.height
+}
+
 func (s *VirtualDisplay) getUpdateOffset(dataOffset uint32) int {
 	// there are (s.width+15)/16 update regions per row
 	// each update region is 16x16 pixels
@@ -76,18 +92,210 @@ func (s *VirtualDisplay) getUpdateOffset(dataOffset uint32) int {
 	return int(dataY)*numRegionsPerRow + int(dataX)
 }
 
-func (s *VirtualDisplay) drawFilledRectangle(color uint32) {
-	// uses shape draw params. 0 is x, 1 is y, 2 is width, 3 is height
-	x := s.shapeDrawParams[0]
-	y := s.shapeDrawParams[1]
-	width := s.shapeDrawParams[2]
-	height := s.shapeDrawParams[3]
+// Double buffering. The program enables it with the double buffering register, then all its writes and draw
+// commands go to a back buffer, and data, which is what the viewers get, only changes when the program
+// presents the frame. Presenting copies the regions drawn since the last present, so the back buffer keeps
+// the frame and the next one can be drawn on top of it. The display refreshes every displayRefreshPeriod
+// user instructions, and the vsync status register counts the refreshes since the last present, so a program
+// can wait for the frame to be shown before drawing the next one.
+
+const displayRefreshPeriod = 10000 // user instructions, 100 refreshes in the default runtime limit
+
+// Returns the buffer the program draws to and the regions drawn, the caller holds the data mutex
+func (s *VirtualDisplay) target() ([]uint32, *[8200]bool) {
+	if s.back != nil {
+		return s.back, &s.backRegions
+	}
+	return s.data[:], &s.updateRegions
+}
 
+// Counts a write to the buffer the program draws to that can change the pixels before end, the viewers only
+// see the ones to the back buffer once they are presented
+func (s *VirtualDisplay) written(end int) {
+	if end > len(s.data) {
+		end = len(s.data)
+	}
+	if end > s.extent {
+		s.extent = end
+	}
+
+	if s.back != nil {
+		s.backWrites++
+	} else {
+		s.displayWrites++
+	}
+}
+
+func (s *VirtualDisplay) setDoubleBuffered(enabled bool) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	if enabled && s.back == nil {
+		// starting from the frame on screen, only the pixels before the extent can be nonzero
+		s.back = make([]uint32, len(s.data))
+		copy(s.back, s.data[:s.extent])
+		s.backRegions = [8200]bool{}
+	} else if !enabled && s.back != nil {
+		// the frame that was not presented is discarded
+		s.back = nil
+	}
+	s.backWrites++
+}
+
+// Shows the back buffer and counts the frame, without double buffering only the frame is counted
+func (s *VirtualDisplay) present() {
 	s.dataMutex.Lock()
 	defer s.dataMutex.Unlock()
-	for oy := 0; oy < int(height); oy++ {
-		for ox := 0; ox < int(width); ox++ {
-			s.data[(y+uint32(oy))*uint32(s.width)+(x+uint32(ox))] = color
+
+	s.frames++
+	s.refreshes = 0
+	if s.back == nil {
+		return
+	}
+
+	numRegionsPerRow := (s.width + 15) / 16
+	if numRegionsPerRow == 0 {
+		return
+	}
+	for region, drawn := range s.backRegions {
+		if !drawn {
+			continue
 		}
+		x0, y0 := (region%numRegionsPerRow)*16, (region/numRegionsPerRow)*16
+		_, _, x1, y1, visible := s.clip(x0, y0, 16, 16)
+		if visible {
+			for y := y0; y < y1; y++ {
+				copy(s.data[y*s.width+x0:y*s.width+x1], s.back[y*s.width+x0:y*s.width+x1])
+			}
+			s.updateRegions[region] = true
+		}
+		s.backRegions[region] = false
+	}
+	s.displayWrites++
+}
+
+// Called before every user instruction
+func (s *VirtualDisplay) tickRefresh() {
+	s.refreshCounter++
+	if s.refreshCounter >= displayRefreshPeriod {
+		s.refreshCounter = 0
+		s.refreshes++
 	}
 }
+
+func (s *VirtualDisplay) drawFilledRectangle(color uint32) {
+	// uses shape draw params. 0 is x, 1 is y, 2 is width, 3 is height, clipped to the display like the
+	// other draw commands in draw.go
+	x := int(int32(s.shapeDrawParams[0]))
+	y := int(int32(s.shapeDrawParams[1]))
+	width := int(s.shapeDrawParams[2])
+	height := int(s.shapeDrawParams[3])
+
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	s.written(s.width * s.height)
+	s.fillRectangle(x, y, width, height, color)
+}
+
+// Virtual Keyboard
+
+// Key codes follow the JavaScript KeyboardEvent.keyCode values, which all fit in a byte
+
+const maxKeyEvents = 32
+
+func (s *VirtualKeyboard) keyEvent(keyCode uint32, pressed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keyCode &= 0xFF
+	if pressed {
+		s.keyState[keyCode>>5] |= 1 << (keyCode & 0x1F)
+	} else {
+		s.keyState[keyCode>>5] &= ^(1 << (keyCode & 0x1F))
+	}
+
+	event := keyCode
+	if pressed {
+		event |= 0x100
+	}
+
+	if len(s.events) >= maxKeyEvents {
+		s.events = s.events[1:] // dropping the oldest event
+	}
+	s.events = append(s.events, event)
+}
+
+func (s *VirtualKeyboard) getKeyState(word uint32) uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.keyState[word]
+}
+
+// Pops the oldest key event from the FIFO. Bit 31 is set if the event is valid, bit 8 is set if the key was
+// pressed (cleared when released), and bits 0-7 are the key code.
+func (s *VirtualKeyboard) popKeyEvent() uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.events) == 0 {
+		return 0
+	}
+
+	event := s.events[0]
+	s.events = s.events[1:]
+	return event | 0x80000000
+}
+
+func (s *VirtualKeyboard) getNumKeyEvents() uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return uint32(len(s.events))
+}
+
+// Virtual Mouse
+
+// Buttons follow the JavaScript MouseEvent.buttons bitmask: bit 0 is the left button, bit 1 is the right
+// button, and bit 2 is the middle button
+
+func (s *VirtualMouse) mouseEvent(x, y int, buttons uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.x = int32(x)
+	s.y = int32(y)
+	s.buttons = buttons
+}
+
+func (s *VirtualMouse) getState() (int32, int32, uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.x, s.y, s.buttons
+}
+
+// Sends a key press or release to the virtual keyboard, raising a keyboard interrupt if it is enabled
+func (inst *EmulatorInstance) KeyEvent(keyCode uint32, pressed bool) {
+	inst.keyboard.keyEvent(keyCode, pressed)
+
+	data := keyCode & 0xFF
+	if pressed {
+		data |= 0x100
+	}
+	inst.Interrupt(&Interrupt{
+		ID:   InterruptIDKeyboard,
+		Data: []uint32{data},
+	})
+}
+
+// Sends the mouse position (in display pixels) and buttons to the virtual mouse, raising a mouse interrupt
+// if it is enabled
+func (inst *EmulatorInstance) MouseEvent(x, y int, buttons uint32) {
+	inst.mouse.mouseEvent(x, y, buttons)
+
+	inst.Interrupt(&Interrupt{
+		ID:   InterruptIDMouse,
+		Data: []uint32{uint32(x), uint32(y), buttons},
+	})
+}
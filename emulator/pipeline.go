@@ -0,0 +1,304 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+)
+
+// Pipeline timing model. This estimates how many cycles the user instructions would take on the classic
+// 5-stage in-order pipeline (IF, ID, EX, MEM, WB) without changing how they execute. Every instruction takes
+// one cycle plus the cycles it stalls in ID waiting for its operands and the cycles lost to control flow:
+//
+//   - With forwarding, a result can be used by the EX stage of the next instruction, except the result of a
+//     load (or atomic), which is only there after MEM, so an instruction that uses it right after the load
+//     stalls for one cycle (a load-use stall).
+//   - Without forwarding, results are written to the register file in the first half of WB and read in the
+//     second half of ID, so an instruction that uses the result of the one before it stalls for two cycles,
+//     and of the one before that for one cycle.
+//   - Jumps are resolved in ID and cost one cycle, taken branches and jalr are resolved in EX and cost two.
+//     Branches are predicted not taken, so branches that fall through cost nothing, unless a branch predictor
+//     is configured, then only mispredicted branches and jumps cost cycles (see branchPredictor.go).
+//
+// Every functional unit takes one cycle, stores need their data in EX like any other operand, and ECALLs
+// count as jumps since they go to the OS, even the ones the emulator services itself. The harts share the
+// pipeline, but instructions of different harts do not depend on each other. Only the instructions counted
+// in DI go through the model.
+
+const ecallInstruction = 0x00000073
+
+type PipelineConfig struct {
+	Forwarding bool `json:"forwarding"`
+}
+
+type PipelineReport struct {
+	Cycles        uint64               `json:"cycles"`
+	Instructions  uint64               `json:"instructions"`
+	CPI           float64              `json:"cpi"`
+	DataStalls    uint64               `json:"dataStalls"`    // cycles waiting for operands, including load-use stalls
+	LoadUseStalls uint64               `json:"loadUseStalls"` // cycles waiting for a load right before
+	FlushCycles   uint64               `json:"flushCycles"`   // cycles lost to jumps and taken or mispredicted branches
+	Lines         []PipelineLineReport `json:"lines,omitempty"`
+}
+
+// The cycles lost at one line of the source, for every time it ran
+type PipelineLineReport struct {
+	Line          int    `json:"line"` // 1-based
+	DataStalls    uint64 `json:"dataStalls"`
+	LoadUseStalls uint64 `json:"loadUseStalls"`
+	FlushCycles   uint64 `json:"flushCycles"`
+}
+
+type pipelineStalls struct {
+	data    uint64
+	loadUse uint64
+	flush   uint64
+}
+
+type pipelineModel struct {
+	config       PipelineConfig
+	cycle        uint64     // when the last instruction was in ID
+	ready        [64]uint64 // the first cycle an instruction can be in ID and use each register, floats are 32-63
+	loadResult   [64]bool   // whether the register was last written by a load, for counting load-use stalls
+	hart         uint32     // of the last instruction
+	instructions uint64
+	totals       pipelineStalls
+	stalls       map[uint32]*pipelineStalls // by pc
+}
+
+func newPipelineModel(config PipelineConfig) *pipelineModel {
+	return &pipelineModel{
+		config: config,
+		cycle:  1, // the first instruction is fetched in cycle 1, so the ID of the instruction before would be 1
+		stalls: map[uint32]*pipelineStalls{},
+	}
+}
+
+func (p *pipelineModel) clone() *pipelineModel {
+	if p == nil {
+		return nil
+	}
+
+	copied := *p
+	copied.stalls = make(map[uint32]*pipelineStalls, len(p.stalls))
+	for pc, stalls := range p.stalls {
+		s := *stalls
+		copied.stalls[pc] = &s
+	}
+	return &copied
+}
+
+// Returns the register the instruction writes and the registers it reads, -1 for none, registers 32-63 are
+// the float registers. x0 is never returned since it does not create a dependency.
+func pipelineOperands(instruction uint32) (dest int, sources [3]int, isLoad bool) {
+	dest = -1
+	sources = [3]int{-1, -1, -1}
+	rd := int((instruction >> 7) & 0x1F)
+	rs1 := int((instruction >> 15) & 0x1F)
+	rs2 := int((instruction >> 20) & 0x1F)
+	rs3 := int(instruction >> 27)
+
+	switch assembler.GetOpCode(instruction) {
+	case assembler.OPCODE_LUI, assembler.OPCODE_AUIPC, assembler.OPCODE_JAL:
+		dest = rd
+	case assembler.OPCODE_JALR, assembler.OPCODE_ITYPE:
+		dest, sources[0] = rd, rs1
+	case assembler.OPCODE_MEMITYPE:
+		dest, sources[0], isLoad = rd, rs1, true
+	case assembler.OPCODE_RTYPE:
+		dest, sources[0], sources[1] = rd, rs1, rs2
+	case assembler.OPCODE_AMO:
+		dest, sources[0], sources[1], isLoad = rd, rs1, rs2, true
+	case assembler.OPCODE_BTYPE, assembler.OPCODE_STYPE:
+		sources[0], sources[1] = rs1, rs2
+	case assembler.OPCODE_ENV:
+		func3 := (instruction >> 12) & 0x7
+		if func3 != 0 {
+			dest = rd
+			if func3&0b100 == 0 {
+				sources[0] = rs1 // the others encode an immediate in rs1
+			}
+		}
+	case assembler.OPCODE_FLW:
+		dest, sources[0], isLoad = 32+rd, rs1, true
+	case assembler.OPCODE_FSW:
+		sources[0], sources[1] = rs1, 32+rs2
+	case assembler.OPCODE_FMADD, assembler.OPCODE_FMSUB, assembler.OPCODE_FNMSUB, assembler.OPCODE_FNMADD:
+		dest, sources[0], sources[1], sources[2] = 32+rd, 32+rs1, 32+rs2, 32+rs3
+	case assembler.OPCODE_FP:
+		dest, sources[0], sources[1] = 32+rd, 32+rs1, 32+rs2
+		switch instruction >> 25 {
+		case 0b0101100, 0b1100000:
+			// fsqrt.s and fcvt.w[u].s have a single source
+			sources[1] = -1
+			if instruction>>25 == 0b1100000 {
+				dest = rd
+			}
+		case 0b1101000, 0b1111000:
+			// fcvt.s.w[u] and fmv.w.x read an integer register
+			sources[0], sources[1] = rs1, -1
+		case 0b1110000, 0b1010000:
+			// fmv.x.w, fclass.s, and the comparisons write an integer register
+			dest = rd
+			if instruction>>25 == 0b1110000 {
+				sources[1] = -1
+			}
+		}
+	}
+
+	if dest == 0 {
+		dest = -1
+	}
+	for i := range sources {
+		if sources[i] == 0 {
+			sources[i] = -1
+		}
+	}
+	return
+}
+
+// Called after the instruction at pc executed without a trap, the pc of the instance is where it went
+func (p *pipelineModel) retire(inst *EmulatorInstance, pc, instruction uint32) {
+	if pc >= inst.profileIgnoreRangeStart && pc < inst.profileIgnoreRangeEnd {
+		return
+	}
+
+	if inst.hartID != p.hart {
+		// instructions of different harts do not depend on each other
+		p.hart = inst.hartID
+		p.ready = [64]uint64{}
+	}
+
+	dest, sources, isLoad := pipelineOperands(instruction)
+
+	// stalling in ID until every operand is available
+	earliest := p.cycle + 1
+	issue := earliest
+	loadUse := false
+	for _, src := range sources {
+		if src >= 0 && p.ready[src] > issue {
+			issue = p.ready[src]
+			loadUse = p.loadResult[src] && p.config.Forwarding
+		}
+	}
+	p.cycle = issue
+
+	if dest >= 0 {
+		if !p.config.Forwarding {
+			p.ready[dest] = issue + 3
+		} else if isLoad {
+			p.ready[dest] = issue + 2
+		} else {
+			p.ready[dest] = issue + 1
+		}
+		p.loadResult[dest] = isLoad
+	}
+
+	// the instructions fetched after a jump or taken branch are flushed, unless it was predicted correctly
+	flush := uint64(0)
+	opcode := assembler.GetOpCode(instruction)
+	predicted := inst.predictor != nil && inst.predictor.resolved
+	if predicted {
+		inst.predictor.resolved = false
+	}
+	switch {
+	case predicted && inst.predictor.resolvedCorrect:
+	case opcode == assembler.OPCODE_JAL || instruction == ecallInstruction:
+		flush = 1
+	case opcode == assembler.OPCODE_BTYPE:
+		// a branch predicted taken that falls through flushes the instructions fetched from its target
+		if predicted || inst.branchTaken {
+			flush = 2
+		}
+	case opcode == assembler.OPCODE_JALR || inst.pc != pc:
+		flush = 2
+	}
+	p.cycle += flush
+
+	p.instructions++
+	stalls := issue - earliest
+	if stalls == 0 && flush == 0 {
+		return
+	}
+
+	lineStalls, ok := p.stalls[pc]
+	if !ok {
+		lineStalls = &pipelineStalls{}
+		p.stalls[pc] = lineStalls
+	}
+	lineStalls.data += stalls
+	p.totals.data += stalls
+	if loadUse {
+		lineStalls.loadUse += stalls
+		p.totals.loadUse += stalls
+	}
+	lineStalls.flush += flush
+	p.totals.flush += flush
+}
+
+// Returns the cycles, CPI, and the stalls by line of the source loaded at offset, which can be nil
+func (p *pipelineModel) report(source *assembler.AssembledResult, offset uint32) *PipelineReport {
+	report := &PipelineReport{
+		Instructions:  p.instructions,
+		DataStalls:    p.totals.data,
+		LoadUseStalls: p.totals.loadUse,
+		FlushCycles:   p.totals.flush,
+	}
+	if p.instructions != 0 {
+		// the last instruction still has to go through EX, MEM, and WB, and jumps flush at the end as well
+		report.Cycles = p.cycle + 3
+		report.CPI = float64(report.Cycles) / float64(p.instructions)
+	}
+
+	if source == nil {
+		return report
+	}
+
+	lines := map[int]*PipelineLineReport{}
+	for pc, stalls := range p.stalls {
+		if pc < offset {
+			continue
+		}
+		line, ok := source.AddressToLine[pc-offset]
+		if !ok {
+			continue
+		}
+		lineReport, ok := lines[line+1]
+		if !ok {
+			lineReport = &PipelineLineReport{Line: line + 1}
+			lines[line+1] = lineReport
+		}
+		lineReport.DataStalls += stalls.data
+		lineReport.LoadUseStalls += stalls.loadUse
+		lineReport.FlushCycles += stalls.flush
+	}
+	for _, lineReport := range lines {
+		report.Lines = append(report.Lines, *lineReport)
+	}
+	sort.Slice(report.Lines, func(i, j int) bool { return report.Lines[i].Line < report.Lines[j].Line })
+	return report
+}
+
+// Adds the cycles, CPI, and the stalls by line to the stats sent to the debugger
+func (r *PipelineReport) addStats(stats map[string]interface{}) {
+	stats["cycles"] = int(r.Cycles)
+	stats["cpi"] = r.CPI
+	stats["dataStalls"] = int(r.DataStalls)
+	stats["loadUseStalls"] = int(r.LoadUseStalls)
+	stats["flushCycles"] = int(r.FlushCycles)
+	stats["stalls"] = r.Lines
+}
+
+func (r *PipelineReport) String() string {
+	return fmt.Sprintf("Cycles = %d, CPI = %.3f, Data Stalls = %d (%d load-use), Flush Cycles = %d", r.Cycles, r.CPI, r.DataStalls, r.LoadUseStalls, r.FlushCycles)
+}
+
+// Returns the cycles and stalls of the pipeline model, or nil when it is not enabled. The stalls are broken
+// down by the lines of the source, which was loaded at offset and can be nil.
+func (inst *EmulatorInstance) GetPipelineStats(source *assembler.AssembledResult, offset uint32) *PipelineReport {
+	if inst.pipeline == nil {
+		return nil
+	}
+	return inst.pipeline.report(source, offset)
+}
@@ -0,0 +1,123 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Screenshots. The frame on screen can be exported as a PNG: the debugger saves it with the
+// riscv_saveScreenshot request, the standalone runner serves it at /screenshot.png, and batch runs capture
+// the final frame and the frames after chosen instruction counts so the autograder can compare them against
+// reference images. When the program double buffers, the frame is the one it last presented.
+//
+// Pixels are stored with red in the lowest byte and alpha in the highest, like the canvas of the web page,
+// and the alpha is not premultiplied.
+
+// The frames a batch run captures, the zero value captures none
+type ScreenshotOptions struct {
+	Final bool  // the frame on screen when the run ends
+	AtDI  []int // the frames on screen after these many user instructions, the final frame if the run ends first
+}
+
+type CapturedFrame struct {
+	DI  int    `json:"di"`  // the instruction count it was captured at
+	PNG []byte `json:"png"` // base64 in JSON
+}
+
+// Returns a copy of the frame on screen
+func (s *VirtualDisplay) Image() *image.NRGBA {
+	data, width, height := s.getFrame()
+	if len(data) == 0 {
+		width, height = 0, 0
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, pixel := range data {
+		img.Pix[i*4] = byte(pixel)
+		img.Pix[i*4+1] = byte(pixel >> 8)
+		img.Pix[i*4+2] = byte(pixel >> 16)
+		img.Pix[i*4+3] = byte(pixel >> 24)
+	}
+	return img
+}
+
+// Writes the frame on screen as a PNG
+func (s *VirtualDisplay) WritePNG(w io.Writer) error {
+	return png.Encode(w, s.Image())
+}
+
+// Saves the frame on screen to a PNG file
+func (s *VirtualDisplay) SavePNG(path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	if e := s.WritePNG(f); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+func (s *VirtualDisplay) encodePNG() []byte {
+	b := bytes.Buffer{}
+	s.WritePNG(&b) // writing to memory cannot fail
+	return b.Bytes()
+}
+
+// Captures the frames of a batch run, it runs before every user instruction like the peripherals do
+type frameCapturer struct {
+	at     []int // still to capture, sorted
+	frames []CapturedFrame
+}
+
+func newFrameCapturer(atDI []int) *frameCapturer {
+	at := append([]int{}, atDI...)
+	sort.Ints(at)
+	return &frameCapturer{at: at}
+}
+
+func (c *frameCapturer) Tick(inst *EmulatorInstance) {
+	// the instruction being counted has not executed yet
+	for len(c.at) > 0 && int(inst.di) > c.at[0] {
+		c.capture(inst, c.at[0])
+	}
+}
+
+func (c *frameCapturer) capture(inst *EmulatorInstance, di int) {
+	c.frames = append(c.frames, CapturedFrame{DI: di, PNG: inst.display.encodePNG()})
+	c.at = c.at[1:]
+}
+
+// Captures the frames the run ended before and returns all of them
+func (c *frameCapturer) finish(inst *EmulatorInstance) []CapturedFrame {
+	for len(c.at) > 0 {
+		c.capture(inst, c.at[0])
+	}
+	return c.frames
+}
+
+// Saves the frames the run captured, the final frame to path with the seed appended to the name like traces,
+// and the others with the seed and then their DI appended
+func (r *EvaluationRunResult) SaveFrames(path string) error {
+	if r.FinalFrame != nil {
+		if e := os.WriteFile(tracePathForSeed(path, r.Seed), r.FinalFrame, 0644); e != nil {
+			return e
+		}
+	}
+	ext := filepath.Ext(path)
+	for _, frame := range r.Frames {
+		framePath := fmt.Sprintf("%s-%d-%d%s", strings.TrimSuffix(path, ext), r.Seed, frame.DI, ext)
+		if e := os.WriteFile(framePath, frame.PNG, 0644); e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,857 @@
+package emulator
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// Snapshots of the entire emulator state. A snapshot holds everything the program can observe: every hart,
+// memory, the display, the filesystem, the interrupt controller, and the statistics, so a restored emulator
+// continues exactly where the snapshot was taken, including the hart schedule. The debugging state
+// (breakpoints and callbacks) and the runtime limit belong to the session and are not part of a snapshot.
+//
+// A snapshot can be taken while Emulate is not running or from a callback while the emulator is paused at a
+// breakpoint or step. It cannot be taken in the middle of an instruction, e.g. on a data breakpoint or a runtime
+// exception. Taking a snapshot is cheap since memory pages and files are shared copy-on-write. The debugger only
+// saves snapshots while paused on a breakpoint or step, and only launches from ones of the same program.
+//
+// On disk, a snapshot is the magic "RVEMSNAP" and a little-endian uint32 format version, followed by a gzip
+// stream with the fields in the order they are written by Save. Lengths are uint32s and precede the data
+// they describe, and maps are written sorted by key so the same state always produces the same file.
+
+const snapshotMagic = "RVEMSNAP"
+const snapshotVersion = 1 // snapshots of other versions cannot be loaded
+
+const snapshotMaxLength = 1 << 28 // sanity limit for lengths read from a file, larger than any valid length
+
+type snapshotFile struct {
+	name     string
+	data     []byte
+	modified bool
+}
+
+type snapshotOpenFile struct {
+	fd       uint32
+	name     string
+	position uint32
+	flags    uint32
+}
+
+type Snapshot struct {
+	// harts
+	harts          []hartContext // the running hart's entry is up to date
+	hartID         uint32        // the running hart
+	reservations   []uint32
+	hartQuantum    uint32
+	hartStart      [3]uint32
+	schedulerDraws uint64
+	exited         bool
+
+	memory *MemoryImage
+
+	// display
+	displayData     []uint32 // the pixels before the extent, shared between snapshots when the display was not written in between
+	displayWrites   int64
+	displayWidth    int
+	displayHeight   int
+	shapeDrawParams [6]uint32
+	backData        []uint32 // nil unless double buffered, shared like displayData
+	backWrites      int64
+	frames          uint32
+	refreshCounter  uint32
+	refreshes       uint32
+
+	// input devices
+	keyState     [8]uint32
+	keyEvents    []uint32
+	mouseX       int32
+	mouseY       int32
+	mouseButtons uint32
+
+	// filesystem
+	fileSystem bool // false if the emulator has none, the files are empty then
+	files      []snapshotFile
+	openFiles  []snapshotOpenFile
+
+	// interrupt controller
+	interrupt         *Interrupt
+	pendingInterrupts []*Interrupt
+	interruptMask     uint32
+	timerPeriod       uint32
+	timerCounter      uint32
+
+	// OS and program state
+	osEntry                 uint32
+	osGlobalPointer         uint32
+	osInterruptHandlerEntry uint32
+	userGlobalPointer       uint32
+	isInOSCode              bool
+	isInOSSetup             bool
+	exitCode                int32
+	heapPointer             uint32
+	wasEcall                bool
+	oldFramePointer         uint32
+	registerPreservation    [32]uint32
+	cycleOffset             uint64
+	instretOffset           uint64
+	trapMode                bool
+	randomSeed              uint32
+	solutionValidity        uint32
+
+	// statistics
+	executedInstructions    uint64
+	profileIgnoreRangeStart uint32
+	profileIgnoreRangeEnd   uint32
+	di                      uint32
+	memUsage                uint32
+	regUsage                uint32
+	lastUsedRegisters       uint32
+	errors                  []RuntimeException
+	caches                  *cacheHierarchy  // not saved to disk, a loaded snapshot starts with empty caches
+	pipeline                *pipelineModel   // not saved to disk either, a loaded snapshot starts counting cycles again
+	predictor               *branchPredictor // not saved to disk either, a loaded snapshot starts untrained
+
+	pausedAtBreakCheck bool // taken from a breakpoint or step callback, see Resume
+}
+
+// Returns a copy of the hart that does not share its call stack or CSRs
+func (hart hartContext) clone() hartContext {
+	hart.callStack = append([]uint32{}, hart.callStack...)
+	hart.trap = nil // traps are taken before the next instruction, so there is never one between instructions
+	if hart.csrs != nil {
+		csrs := make(map[uint32]uint32, len(hart.csrs))
+		for csr, value := range hart.csrs {
+			csrs[csr] = value
+		}
+		hart.csrs = csrs
+	}
+	return hart
+}
+
+func (interrupt *Interrupt) clone() *Interrupt {
+	if interrupt == nil {
+		return nil
+	}
+	copied := *interrupt
+	copied.Data = append([]uint32{}, interrupt.Data...)
+	copied.callStack = append([]uint32{}, interrupt.callStack...)
+	return &copied
+}
+
+// Captures the entire state of the emulator, see the top of this file for when this can be called
+func (inst *EmulatorInstance) Snapshot() (*Snapshot, error) {
+	if inst.executing {
+		return nil, errors.New("cannot take a snapshot in the middle of an instruction")
+	}
+	return inst.snapshot(nil), nil
+}
+
+// Captures the state between instructions. The display contents are shared with the previous snapshot, if
+// any, when the display has not been written since it was taken, which saves copying the display every time.
+func (inst *EmulatorInstance) snapshot(previous *Snapshot) *Snapshot {
+	s := &Snapshot{
+		hartID:                  inst.hartID,
+		reservations:            append([]uint32{}, inst.reservations...),
+		hartQuantum:             inst.hartQuantum,
+		hartStart:               inst.hartStart,
+		schedulerDraws:          inst.schedulerDraws,
+		exited:                  inst.exited,
+		memory:                  inst.memory.Clone(),
+		osEntry:                 inst.osEntry,
+		osGlobalPointer:         inst.osGlobalPointer,
+		osInterruptHandlerEntry: inst.osInterruptHandlerEntry,
+		userGlobalPointer:       inst.userGlobalPointer,
+		isInOSCode:              inst.isInOSCode,
+		isInOSSetup:             inst.isInOSSetup,
+		exitCode:                int32(inst.exitCode),
+		heapPointer:             inst.heapPointer,
+		wasEcall:                inst.wasEcall,
+		oldFramePointer:         inst.oldFramePointer,
+		registerPreservation:    inst.registerPreservation,
+		cycleOffset:             inst.cycleOffset,
+		instretOffset:           inst.instretOffset,
+		trapMode:                inst.trapMode,
+		randomSeed:              inst.randomSeed,
+		solutionValidity:        inst.solutionValidity,
+		executedInstructions:    inst.executedInstructions,
+		profileIgnoreRangeStart: inst.profileIgnoreRangeStart,
+		profileIgnoreRangeEnd:   inst.profileIgnoreRangeEnd,
+		di:                      inst.di,
+		memUsage:                inst.memUsage,
+		regUsage:                inst.regUsage,
+		lastUsedRegisters:       inst.lastUsedRegisters,
+		caches:                  inst.caches.clone(),
+		pipeline:                inst.pipeline.clone(),
+		predictor:               inst.predictor.clone(),
+		pausedAtBreakCheck:      inst.checkingBreakpoints,
+	}
+
+	s.harts = make([]hartContext, len(inst.harts))
+	for i := range inst.harts {
+		s.harts[i] = inst.getHart(uint32(i)).clone()
+	}
+
+	for _, e := range inst.errors {
+		e.callStack = append([]uint32{}, e.callStack...)
+		s.errors = append(s.errors, e)
+	}
+
+	inst.display.dataMutex.Lock()
+	// only the pixels the program wrote are kept, the rest are zero
+	extent := inst.display.extent
+	s.displayWrites = inst.display.displayWrites
+	if previous != nil && previous.displayWrites == s.displayWrites {
+		s.displayData = previous.displayData
+	} else {
+		s.displayData = append([]uint32{}, inst.display.data[:extent]...)
+	}
+	s.displayWidth = inst.display.width
+	s.displayHeight = inst.display.height
+	s.shapeDrawParams = inst.display.shapeDrawParams
+	s.backWrites = inst.display.backWrites
+	if inst.display.back != nil {
+		if previous != nil && previous.backData != nil && previous.backWrites == s.backWrites {
+			s.backData = previous.backData
+		} else {
+			s.backData = append([]uint32{}, inst.display.back[:extent]...)
+		}
+	}
+	s.frames = inst.display.frames
+	s.refreshCounter = inst.display.refreshCounter
+	s.refreshes = inst.display.refreshes
+	inst.display.dataMutex.Unlock()
+
+	inst.keyboard.mutex.Lock()
+	s.keyState = inst.keyboard.keyState
+	s.keyEvents = append([]uint32{}, inst.keyboard.events...)
+	inst.keyboard.mutex.Unlock()
+
+	s.mouseX, s.mouseY, s.mouseButtons = inst.mouse.getState()
+
+	// the files are shared with the running filesystem, so both sides copy them before writing
+	if inst.fs != nil {
+		s.fileSystem = true
+		for _, file := range inst.fs.files {
+			file.shared = true
+			s.files = append(s.files, snapshotFile{file.name, file.data, file.modified})
+		}
+		sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+		for fd, open := range inst.fs.openFiles {
+			s.openFiles = append(s.openFiles, snapshotOpenFile{fd, open.file.name, open.position, open.flags})
+		}
+		sort.Slice(s.openFiles, func(i, j int) bool { return s.openFiles[i].fd < s.openFiles[j].fd })
+	}
+
+	inst.interruptMutex.Lock()
+	s.interrupt = inst.interrupt.clone()
+	for _, interrupt := range inst.pendingInterrupts {
+		s.pendingInterrupts = append(s.pendingInterrupts, interrupt.clone())
+	}
+	s.interruptMask = inst.interruptMask
+	s.timerPeriod = inst.timerPeriod
+	s.timerCounter = inst.timerCounter
+	inst.interruptMutex.Unlock()
+
+	return s
+}
+
+// Returns an error unless the snapshot has the same code between start and end as the memory, which is how the
+// debugger checks that a snapshot was taken running the program it launches
+func (s *Snapshot) matchesCode(memory *MemoryImage, start, end uint32) error {
+	for addr := start; addr < end; addr += 4 {
+		expected, _ := memory.ReadWord(addr)
+		if actual, _ := s.memory.ReadWord(addr); actual != expected {
+			return fmt.Errorf("the code at 0x%08X is not the same, the snapshot was taken running a different program", addr)
+		}
+	}
+	return nil
+}
+
+// Replaces the state of the emulator with the snapshot, which can be restored any number of times. The
+// emulator must not be running. Call Resume to continue the program from where the snapshot was taken.
+func (inst *EmulatorInstance) Restore(s *Snapshot) {
+	inst.harts = make([]hartContext, len(s.harts))
+	for i, hart := range s.harts {
+		inst.harts[i] = hart.clone()
+	}
+	inst.hartContext = inst.harts[s.hartID]
+	inst.reservations = append([]uint32{}, s.reservations...)
+	inst.hartQuantum = s.hartQuantum
+	inst.hartStart = s.hartStart
+	inst.exited = s.exited
+
+	// replaying the scheduler so the harts are switched at the same points as in the original run
+	inst.scheduler = rand.New(rand.NewSource(int64(s.randomSeed)))
+	for i := uint64(0); i < s.schedulerDraws; i++ {
+		inst.scheduler.Intn(hartQuantumMax)
+	}
+	inst.schedulerDraws = s.schedulerDraws
+
+	inst.memory = s.memory.Clone()
+	inst.resetCaches()
+
+	inst.display.dataMutex.Lock()
+	copy(inst.display.data[:], s.displayData)
+	for i := len(s.displayData); i < inst.display.extent; i++ {
+		inst.display.data[i] = 0
+	}
+	inst.display.extent = len(s.displayData)
+	inst.display.displayWrites++ // the contents changed, so later snapshots cannot share the display with earlier ones
+	inst.display.width = s.displayWidth
+	inst.display.height = s.displayHeight
+	inst.display.shapeDrawParams = s.shapeDrawParams
+	for i := range inst.display.updateRegions {
+		inst.display.updateRegions[i] = true // the whole screen has to be sent again
+	}
+	inst.display.back = nil
+	if s.backData != nil {
+		inst.display.back = make([]uint32, len(inst.display.data))
+		copy(inst.display.back, s.backData)
+		if len(s.backData) > inst.display.extent {
+			inst.display.extent = len(s.backData)
+		}
+		for i := range inst.display.backRegions {
+			inst.display.backRegions[i] = true // and presented again
+		}
+	}
+	inst.display.backWrites++
+	inst.display.frames = s.frames
+	inst.display.refreshCounter = s.refreshCounter
+	inst.display.refreshes = s.refreshes
+	inst.display.dataMutex.Unlock()
+
+	inst.keyboard.mutex.Lock()
+	inst.keyboard.keyState = s.keyState
+	inst.keyboard.events = append([]uint32{}, s.keyEvents...)
+	inst.keyboard.mutex.Unlock()
+
+	inst.mouse.mouseEvent(int(s.mouseX), int(s.mouseY), s.mouseButtons)
+
+	inst.fs = nil
+	if s.fileSystem {
+		inst.fs = NewVirtualFileSystem()
+		for _, file := range s.files {
+			inst.fs.files[file.name] = &VirtualFile{name: file.name, data: file.data, shared: true, modified: file.modified}
+		}
+		for _, open := range s.openFiles {
+			if file, ok := inst.fs.files[open.name]; ok {
+				inst.fs.openFiles[open.fd] = &openVirtualFile{file, open.position, open.flags}
+			}
+		}
+	}
+
+	inst.interruptMutex.Lock()
+	inst.interrupt = s.interrupt.clone()
+	inst.pendingInterrupts = nil
+	for _, interrupt := range s.pendingInterrupts {
+		inst.pendingInterrupts = append(inst.pendingInterrupts, interrupt.clone())
+	}
+	inst.hasPendingInterrupt.Store(len(inst.pendingInterrupts) > 0)
+	inst.interruptMask = s.interruptMask
+	inst.timerPeriod = s.timerPeriod
+	inst.timerCounter = s.timerCounter
+	inst.interruptMutex.Unlock()
+
+	inst.osEntry = s.osEntry
+	inst.osGlobalPointer = s.osGlobalPointer
+	inst.osInterruptHandlerEntry = s.osInterruptHandlerEntry
+	inst.userGlobalPointer = s.userGlobalPointer
+	inst.isInOSCode = s.isInOSCode
+	inst.isInOSSetup = s.isInOSSetup
+	inst.exitCode = int(s.exitCode)
+	inst.heapPointer = s.heapPointer
+	inst.wasEcall = s.wasEcall
+	inst.oldFramePointer = s.oldFramePointer
+	inst.registerPreservation = s.registerPreservation
+	inst.cycleOffset = s.cycleOffset
+	inst.instretOffset = s.instretOffset
+	inst.trapMode = s.trapMode
+	inst.randomSeed = s.randomSeed
+	inst.solutionValidity = s.solutionValidity
+
+	inst.executedInstructions = s.executedInstructions
+	inst.profileIgnoreRangeStart = s.profileIgnoreRangeStart
+	inst.profileIgnoreRangeEnd = s.profileIgnoreRangeEnd
+	inst.di = s.di
+	inst.memUsage = s.memUsage
+	inst.regUsage = s.regUsage
+	inst.lastUsedRegisters = s.lastUsedRegisters
+	inst.errors = []RuntimeException{}
+	for _, e := range s.errors {
+		e.callStack = append([]uint32{}, e.callStack...)
+		inst.errors = append(inst.errors, e)
+	}
+	if inst.caches != nil {
+		if s.caches != nil {
+			inst.caches = s.caches.clone()
+		} else {
+			inst.caches = newCacheHierarchy(inst.caches.config, s.randomSeed)
+		}
+	}
+	if inst.pipeline != nil {
+		if s.pipeline != nil {
+			inst.pipeline = s.pipeline.clone()
+		} else {
+			inst.pipeline = newPipelineModel(inst.pipeline.config)
+		}
+	}
+	if inst.predictor != nil {
+		if s.predictor != nil {
+			inst.predictor = s.predictor.clone()
+		} else {
+			inst.predictor = newBranchPredictor(inst.predictor.config)
+		}
+	}
+
+	inst.bus.resetAdded(inst)
+
+	inst.resumeAtBreakCheck = s.pausedAtBreakCheck
+}
+
+// Continues the program from the instruction after the last one executed, e.g. after restoring a snapshot.
+// A snapshot taken while paused at a breakpoint continues with that instruction, checking the breakpoints
+// again so the program stops there if the breakpoint is still set.
+func (inst *EmulatorInstance) Resume() {
+	inst.run()
+}
+
+// Writes the snapshot in the on-disk format described at the top of this file
+func (s *Snapshot) Save(w io.Writer) error {
+	if _, e := io.WriteString(w, snapshotMagic); e != nil {
+		return e
+	}
+	if e := binary.Write(w, binary.LittleEndian, uint32(snapshotVersion)); e != nil {
+		return e
+	}
+
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+	sw := &snapshotWriter{w: bw}
+
+	sw.write(uint32(len(s.harts)))
+	for _, hart := range s.harts {
+		sw.writeHart(hart)
+	}
+	sw.write(s.hartID)
+	sw.write(s.reservations)
+	sw.write(s.hartQuantum)
+	sw.write(s.hartStart)
+	sw.write(s.schedulerDraws)
+	sw.write(s.exited)
+
+	pageNums := make([]uint32, 0, len(s.memory.Blocks))
+	for pageNum := range s.memory.Blocks {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+	sw.write(uint32(len(pageNums)))
+	for _, pageNum := range pageNums {
+		page := s.memory.Blocks[pageNum]
+		sw.write(page.StartAddr)
+		sw.write(page.Block[:])
+		sw.write(page.Initialized[:])
+	}
+
+	sw.write(uint32(len(s.displayData)))
+	sw.write(s.displayData)
+	sw.write(int32(s.displayWidth))
+	sw.write(int32(s.displayHeight))
+	sw.write(s.shapeDrawParams)
+	sw.write(s.backData != nil)
+	sw.writeLength(len(s.backData))
+	sw.write(s.backData)
+	sw.write(s.frames)
+	sw.write(s.refreshCounter)
+	sw.write(s.refreshes)
+
+	sw.write(s.keyState)
+	sw.writeLength(len(s.keyEvents))
+	sw.write(s.keyEvents)
+	sw.write(s.mouseX)
+	sw.write(s.mouseY)
+	sw.write(s.mouseButtons)
+
+	sw.write(s.fileSystem)
+	sw.writeLength(len(s.files))
+	for _, file := range s.files {
+		sw.writeString(file.name)
+		sw.writeLength(len(file.data))
+		sw.write(file.data)
+		sw.write(file.modified)
+	}
+	sw.writeLength(len(s.openFiles))
+	for _, open := range s.openFiles {
+		sw.write(open.fd)
+		sw.writeString(open.name)
+		sw.write(open.position)
+		sw.write(open.flags)
+	}
+
+	sw.write(s.interrupt != nil)
+	if s.interrupt != nil {
+		sw.writeInterrupt(s.interrupt)
+	}
+	sw.writeLength(len(s.pendingInterrupts))
+	for _, interrupt := range s.pendingInterrupts {
+		sw.writeInterrupt(interrupt)
+	}
+	sw.write(s.interruptMask)
+	sw.write(s.timerPeriod)
+	sw.write(s.timerCounter)
+
+	sw.write(s.osEntry)
+	sw.write(s.osGlobalPointer)
+	sw.write(s.osInterruptHandlerEntry)
+	sw.write(s.userGlobalPointer)
+	sw.write(s.isInOSCode)
+	sw.write(s.isInOSSetup)
+	sw.write(s.exitCode)
+	sw.write(s.heapPointer)
+	sw.write(s.wasEcall)
+	sw.write(s.oldFramePointer)
+	sw.write(s.registerPreservation)
+	sw.write(s.cycleOffset)
+	sw.write(s.instretOffset)
+	sw.write(s.trapMode)
+	sw.write(s.randomSeed)
+	sw.write(s.solutionValidity)
+
+	sw.write(s.executedInstructions)
+	sw.write(s.profileIgnoreRangeStart)
+	sw.write(s.profileIgnoreRangeEnd)
+	sw.write(s.di)
+	sw.write(s.memUsage)
+	sw.write(s.regUsage)
+	sw.write(s.lastUsedRegisters)
+	sw.writeLength(len(s.errors))
+	for _, e := range s.errors {
+		sw.write(e.hart)
+		sw.write(e.regs)
+		sw.write(e.pc)
+		sw.writeLength(len(e.callStack))
+		sw.write(e.callStack)
+		sw.writeString(e.message)
+	}
+
+	sw.write(s.pausedAtBreakCheck)
+
+	if sw.err != nil {
+		return sw.err
+	}
+	if e := bw.Flush(); e != nil {
+		return e
+	}
+	return zw.Close()
+}
+
+// Writes the snapshot to a file, replacing it if it exists
+func (s *Snapshot) SaveFile(path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+
+	if e := s.Save(f); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Reads a snapshot written by Save
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	header := make([]byte, len(snapshotMagic)+4)
+	if _, e := io.ReadFull(r, header); e != nil {
+		return nil, fmt.Errorf("not a snapshot: %w", e)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a snapshot: missing RVEMSNAP header")
+	}
+	version := binary.LittleEndian.Uint32(header[len(snapshotMagic):])
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", version, snapshotVersion)
+	}
+
+	zr, e := gzip.NewReader(r)
+	if e != nil {
+		return nil, e
+	}
+	sr := &snapshotReader{r: bufio.NewReader(zr)}
+	s := &Snapshot{}
+
+	s.harts = make([]hartContext, sr.readLength())
+	for i := range s.harts {
+		s.harts[i] = sr.readHart()
+	}
+	sr.read(&s.hartID)
+	s.reservations = make([]uint32, len(s.harts))
+	sr.read(s.reservations)
+	sr.read(&s.hartQuantum)
+	sr.read(&s.hartStart)
+	sr.read(&s.schedulerDraws)
+	sr.read(&s.exited)
+	if sr.err == nil && int(s.hartID) >= len(s.harts) {
+		return nil, fmt.Errorf("corrupt snapshot: running hart %d of %d", s.hartID, len(s.harts))
+	}
+
+	s.memory = NewMemoryImage()
+	numPages := sr.readLength()
+	for i := 0; i < numPages && sr.err == nil; i++ {
+		page := &MemoryPage{}
+		sr.read(&page.StartAddr)
+		sr.read(page.Block[:])
+		sr.read(page.Initialized[:])
+		s.memory.Blocks[page.StartAddr>>12] = page
+	}
+
+	maxPixels := len((*VirtualDisplay)(nil).data)
+	s.displayData = make([]uint32, sr.readLength())
+	if sr.err == nil && len(s.displayData) > maxPixels {
+		return nil, fmt.Errorf("corrupt snapshot: display has %d pixels", len(s.displayData))
+	}
+	sr.read(s.displayData)
+	var width, height int32
+	sr.read(&width)
+	sr.read(&height)
+	s.displayWidth = int(width)
+	s.displayHeight = int(height)
+	sr.read(&s.shapeDrawParams)
+	var doubleBuffered bool
+	sr.read(&doubleBuffered)
+	s.backData = make([]uint32, sr.readLength())
+	if sr.err == nil && len(s.backData) > maxPixels {
+		return nil, fmt.Errorf("corrupt snapshot: back buffer has %d pixels", len(s.backData))
+	}
+	sr.read(s.backData)
+	if !doubleBuffered {
+		s.backData = nil
+	}
+	sr.read(&s.frames)
+	sr.read(&s.refreshCounter)
+	sr.read(&s.refreshes)
+
+	sr.read(&s.keyState)
+	s.keyEvents = make([]uint32, sr.readLength())
+	sr.read(s.keyEvents)
+	sr.read(&s.mouseX)
+	sr.read(&s.mouseY)
+	sr.read(&s.mouseButtons)
+
+	sr.read(&s.fileSystem)
+	s.files = make([]snapshotFile, sr.readLength())
+	for i := range s.files {
+		s.files[i].name = sr.readString()
+		s.files[i].data = make([]byte, sr.readLength())
+		sr.read(s.files[i].data)
+		sr.read(&s.files[i].modified)
+	}
+	s.openFiles = make([]snapshotOpenFile, sr.readLength())
+	for i := range s.openFiles {
+		sr.read(&s.openFiles[i].fd)
+		s.openFiles[i].name = sr.readString()
+		sr.read(&s.openFiles[i].position)
+		sr.read(&s.openFiles[i].flags)
+	}
+
+	var hasInterrupt bool
+	sr.read(&hasInterrupt)
+	if hasInterrupt {
+		s.interrupt = sr.readInterrupt()
+	}
+	s.pendingInterrupts = make([]*Interrupt, sr.readLength())
+	for i := range s.pendingInterrupts {
+		s.pendingInterrupts[i] = sr.readInterrupt()
+	}
+	sr.read(&s.interruptMask)
+	sr.read(&s.timerPeriod)
+	sr.read(&s.timerCounter)
+
+	sr.read(&s.osEntry)
+	sr.read(&s.osGlobalPointer)
+	sr.read(&s.osInterruptHandlerEntry)
+	sr.read(&s.userGlobalPointer)
+	sr.read(&s.isInOSCode)
+	sr.read(&s.isInOSSetup)
+	sr.read(&s.exitCode)
+	sr.read(&s.heapPointer)
+	sr.read(&s.wasEcall)
+	sr.read(&s.oldFramePointer)
+	sr.read(&s.registerPreservation)
+	sr.read(&s.cycleOffset)
+	sr.read(&s.instretOffset)
+	sr.read(&s.trapMode)
+	sr.read(&s.randomSeed)
+	sr.read(&s.solutionValidity)
+
+	sr.read(&s.executedInstructions)
+	sr.read(&s.profileIgnoreRangeStart)
+	sr.read(&s.profileIgnoreRangeEnd)
+	sr.read(&s.di)
+	sr.read(&s.memUsage)
+	sr.read(&s.regUsage)
+	sr.read(&s.lastUsedRegisters)
+	s.errors = make([]RuntimeException, sr.readLength())
+	for i := range s.errors {
+		e := &s.errors[i]
+		sr.read(&e.hart)
+		sr.read(&e.regs)
+		sr.read(&e.pc)
+		e.callStack = make([]uint32, sr.readLength())
+		sr.read(e.callStack)
+		e.message = sr.readString()
+	}
+
+	sr.read(&s.pausedAtBreakCheck)
+
+	if sr.err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", sr.err)
+	}
+	return s, nil
+}
+
+// Reads a snapshot file written by SaveFile
+func LoadSnapshotFile(path string) (*Snapshot, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	return LoadSnapshot(f)
+}
+
+// Writes fields in order and keeps the first error so the caller only checks once
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(data interface{}) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.LittleEndian, data)
+	}
+}
+
+func (sw *snapshotWriter) writeLength(length int) {
+	sw.write(uint32(length))
+}
+
+func (sw *snapshotWriter) writeString(str string) {
+	sw.writeLength(len(str))
+	sw.write([]byte(str))
+}
+
+func (sw *snapshotWriter) writeHart(hart hartContext) {
+	sw.write(hart.hartID)
+	sw.write(hart.running)
+	sw.write(hart.registers)
+	sw.write(hart.regInit)
+	sw.write(hart.fregisters)
+	sw.write(hart.fregInit)
+	sw.write(hart.fflags)
+	sw.write(hart.frm)
+	sw.write(hart.pc)
+	sw.write(hart.instructionLength)
+
+	csrs := make([]uint32, 0, len(hart.csrs))
+	for csr := range hart.csrs {
+		csrs = append(csrs, csr)
+	}
+	sort.Slice(csrs, func(i, j int) bool { return csrs[i] < csrs[j] })
+	sw.writeLength(len(csrs))
+	for _, csr := range csrs {
+		sw.write(csr)
+		sw.write(hart.csrs[csr])
+	}
+
+	sw.writeLength(len(hart.callStack))
+	sw.write(hart.callStack)
+}
+
+func (sw *snapshotWriter) writeInterrupt(interrupt *Interrupt) {
+	sw.write(interrupt.ID)
+	sw.writeLength(len(interrupt.Data))
+	sw.write(interrupt.Data)
+	sw.write(interrupt.registers)
+	sw.write(interrupt.pc)
+	sw.writeLength(len(interrupt.callStack))
+	sw.write(interrupt.callStack)
+}
+
+// Reads fields in order, after the first error every read is skipped and returns zero values
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(data interface{}) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.LittleEndian, data)
+	}
+}
+
+func (sr *snapshotReader) readLength() int {
+	var length uint32
+	sr.read(&length)
+	if sr.err == nil && length > snapshotMaxLength {
+		sr.err = fmt.Errorf("length %d is too large", length)
+	}
+	if sr.err != nil {
+		return 0
+	}
+	return int(length)
+}
+
+func (sr *snapshotReader) readString() string {
+	str := make([]byte, sr.readLength())
+	sr.read(str)
+	return string(str)
+}
+
+func (sr *snapshotReader) readHart() hartContext {
+	hart := hartContext{}
+	sr.read(&hart.hartID)
+	sr.read(&hart.running)
+	sr.read(&hart.registers)
+	sr.read(&hart.regInit)
+	sr.read(&hart.fregisters)
+	sr.read(&hart.fregInit)
+	sr.read(&hart.fflags)
+	sr.read(&hart.frm)
+	sr.read(&hart.pc)
+	sr.read(&hart.instructionLength)
+
+	numCSRs := sr.readLength()
+	if numCSRs > 0 {
+		hart.csrs = make(map[uint32]uint32, numCSRs)
+	}
+	for i := 0; i < numCSRs && sr.err == nil; i++ {
+		var csr, value uint32
+		sr.read(&csr)
+		sr.read(&value)
+		hart.csrs[csr] = value
+	}
+
+	hart.callStack = make([]uint32, sr.readLength())
+	sr.read(hart.callStack)
+	return hart
+}
+
+func (sr *snapshotReader) readInterrupt() *Interrupt {
+	interrupt := &Interrupt{}
+	sr.read(&interrupt.ID)
+	interrupt.Data = make([]uint32, sr.readLength())
+	sr.read(interrupt.Data)
+	sr.read(&interrupt.registers)
+	sr.read(&interrupt.pc)
+	interrupt.callStack = make([]uint32, sr.readLength())
+	sr.read(interrupt.callStack)
+	return interrupt
+}
@@ -1,16 +1,20 @@
 package emulator
 
 import (
+	"bytes"
+	"compress/zlib"
 	"debug/elf"
-	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,7 +26,7 @@ import (
 // there needs to be a way to run the emulator on cpp code without VSCode. This file contains the code
 // to run the emulator without VSCode. To provide the peripheral support, this will host a web server on
 // port 2035 that will serve the virtual display, mouse, keyboard, and console.
-func runStandaloneEmulator(elfFilePath string, assemblyPath string, conn *websocket.Conn, emInst **EmulatorInstance) {
+func runStandaloneEmulator(elfFilePath string, assemblyPath string, fs *VirtualFileSystem, fsOutputPath string, trace TraceOptions, screenshotPath string, compressDisplay bool, conn *websocket.Conn, emInst **EmulatorInstance) {
 	fmt.Println("Running standalone emulator...")
 	f, e := elf.Open(elfFilePath)
 	if e != nil {
@@ -65,13 +69,14 @@ func runStandaloneEmulator(elfFilePath string, assemblyPath string, conn *websoc
 
 	assemblyEntry := uint32(0)
 	assemblyGlobalPointer := uint32(0)
+	var assembleRes *assembler.AssembledResult
 	if assemblyPath != "" {
 		b, e := os.ReadFile(assemblyPath)
 		if e != nil {
 			log.Fatalf("Could not read assembly file: %v", e)
 		}
 
-		assembleRes := assembler.Assemble(string(b))
+		assembleRes = assembler.Assemble(string(b))
 		if len(assembleRes.Diagnostics) > 0 {
 			builder := strings.Builder{}
 			builder.WriteByte('\n')
@@ -107,6 +112,7 @@ func runStandaloneEmulator(elfFilePath string, assemblyPath string, conn *websoc
 		OSGlobalPointer:         globalPointer,
 		HeapStartAddress:        0x10000000,
 		Memory:                  memoryImage,
+		FileSystem:              fs,
 		ProfileIgnoreRangeStart: uint32(f.Section(".text").Addr),
 		ProfileIgnoreRangeEnd:   uint32(f.Section(".text").Addr) + uint32(f.Section(".text").Size),
 		RuntimeErrorCallback: func(e RuntimeException) {
@@ -132,43 +138,50 @@ func runStandaloneEmulator(elfFilePath string, assemblyPath string, conn *websoc
 
 	emulator := NewEmulator(config)
 	*emInst = emulator
+	standaloneEmulator.Store(emulator)
+
+	if trace.Path != "" {
+		closeTrace, e := traceToFile(emulator, trace.Path, trace.Format, assembleRes, assemblyEntry)
+		if e != nil {
+			log.Fatalf("Could not create trace: %v", e)
+		}
+		defer func() {
+			if e := closeTrace(); e != nil {
+				log.Printf("Could not write trace: %v", e)
+			}
+		}()
+	}
 
 	displayWatcher := func() {
-		prevWrites := int64(0)
+		width, height := -1, -1
 		for !emulator.terminated {
-			time.Sleep(250 * time.Millisecond)
-			if prevWrites != emulator.display.displayWrites {
-				prevWrites = emulator.display.displayWrites
-
-				// send the display data
-				displayMessage := struct {
-					Type   string `json:"type"`
-					Data   string `json:"data"`
-					Width  int    `json:"width"`
-					Height int    `json:"height"`
-				}{Type: "display", Width: emulator.display.width, Height: emulator.display.height}
-
-				data := emulator.display.data
-				dBytes := make([]byte, 0, len(data)*4)
-				// converting data to bytes by breaking up the uint32s into 4 bytes
-				for i := 0; i < emulator.display.width*emulator.display.height; i++ {
-					dBytes = append(dBytes, byte(data[i]))
-					dBytes = append(dBytes, byte(data[i]>>8))
-					dBytes = append(dBytes, byte(data[i]>>16))
-					dBytes = append(dBytes, byte(data[i]>>24))
-				}
-
-				displayMessage.Data = base64.StdEncoding.EncodeToString(dBytes)
-
-				messageBytes, e := json.Marshal(displayMessage)
-				if e != nil {
-					log.Fatalf("Could not marshal display message: %v", e)
-				}
+			time.Sleep(displayFrameInterval)
+
+			// the whole screen is sent first and whenever its size changes since resizing clears the canvas,
+			// then only the tiles drawn since, which only change when a frame is presented if the program
+			// double buffers
+			var updates []VirtualDisplayUpdate
+			if w, h := emulator.display.getSize(); w != width || h != height {
+				width, height = w, h
+				updates = emulator.display.GetEntireScreen()
+			} else {
+				updates = emulator.display.GetUpdates()
+			}
+			if len(updates) == 0 {
+				continue
+			}
 
-				wsMutex.Lock()
-				conn.WriteMessage(websocket.TextMessage, messageBytes)
-				wsMutex.Unlock()
+			messageBytes, e := encodeDisplayTiles(width, height, updates, compressDisplay)
+			if e != nil {
+				// the tiles are lost, so the whole screen is sent again next time
+				log.Printf("Could not encode display message: %v", e)
+				width, height = -1, -1
+				continue
 			}
+
+			wsMutex.Lock()
+			conn.WriteMessage(websocket.BinaryMessage, messageBytes)
+			wsMutex.Unlock()
 		}
 	}
 	go displayWatcher()
@@ -188,11 +201,76 @@ func runStandaloneEmulator(elfFilePath string, assemblyPath string, conn *websoc
 		fmt.Printf("Emulator completed with exit code %d\n", emulator.GetExitCode())
 	}
 
+	if fsOutputPath != "" && emulator.GetFileSystem() != nil {
+		if e := emulator.GetFileSystem().SaveModifiedFiles(fsOutputPath); e != nil {
+			log.Printf("Could not save output files: %v", e)
+		}
+	}
+	if screenshotPath != "" {
+		if e := emulator.display.SavePNG(screenshotPath); e != nil {
+			log.Printf("Could not save the final frame: %v", e)
+		}
+	}
+
 	time.Sleep(100 * time.Millisecond)
 	fmt.Printf("Emulator ran %d instructions\n", emulator.GetTotalInstructionsExecuted())
 }
 
-func RunStandaloneWebserver(elfFilePath string, assemblyPath string) {
+// The emulator of the last run, the frame on screen is served at /screenshot.png
+var standaloneEmulator atomic.Pointer[EmulatorInstance]
+
+// Display streaming. The display watcher sends the 16x16 tiles drawn since the last message as a binary
+// websocket message up to 60 times a second, and the page patches them into its canvas. A message is a flags
+// byte, with bit 0 set when the rest of the message is zlib compressed, followed by little-endian uint32s:
+//
+//	width, height    of the display
+//	count            of the tiles
+//	x, y, pixels     for each tile, its top left corner and its 16x16 pixels row by row, 4 bytes each
+//
+// The pixels are RGBA bytes like the canvas expects. The page asks for compression in its run message when
+// the browser can decompress it.
+
+const displayFrameInterval = time.Second / 60
+
+func encodeDisplayTiles(width, height int, updates []VirtualDisplayUpdate, compress bool) ([]byte, error) {
+	b := bytes.Buffer{}
+	var w io.Writer = &b
+	var zw *zlib.Writer
+	if compress {
+		b.WriteByte(1)
+		zw, _ = zlib.NewWriterLevel(&b, zlib.BestSpeed) // the level is valid
+		w = zw
+	} else {
+		b.WriteByte(0)
+	}
+
+	body := make([]byte, 12, 12+len(updates)*(8+16*16*4))
+	binary.LittleEndian.PutUint32(body[0:], uint32(width))
+	binary.LittleEndian.PutUint32(body[4:], uint32(height))
+	binary.LittleEndian.PutUint32(body[8:], uint32(len(updates)))
+	for _, update := range updates {
+		body = binary.LittleEndian.AppendUint32(body, uint32(update.RegionX))
+		body = binary.LittleEndian.AppendUint32(body, uint32(update.RegionY))
+		for _, pixel := range update.Data {
+			body = binary.LittleEndian.AppendUint32(body, pixel)
+		}
+	}
+
+	if _, e := w.Write(body); e != nil {
+		return nil, e
+	}
+	if zw != nil {
+		if e := zw.Close(); e != nil {
+			return nil, e
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// Files the program writes to the filesystem are saved under fsOutputPath after each run, if it is set, each
+// run writes its execution trace to the trace path, if it is set, and the final frame is saved to the
+// screenshot path as a PNG, if it is set
+func RunStandaloneWebserver(elfFilePath string, assemblyPath string, fs *VirtualFileSystem, fsOutputPath string, trace TraceOptions, screenshotPath string) {
 	// open a websocket on port 2035 and listen for commands
 	// commands will be:
 	// - run: run the emulator with the given elf file and assembly file
@@ -237,17 +315,28 @@ func RunStandaloneWebserver(elfFilePath string, assemblyPath string) {
 			mType := message["type"].(string)
 			switch mType {
 			case "run":
-				go runStandaloneEmulator(elfFilePath, assemblyPath, conn, &emInst)
+				// {"type": "run", "compress": <whether the display messages can be compressed>}
+				compress, _ := message["compress"].(bool)
+				go runStandaloneEmulator(elfFilePath, assemblyPath, fs, fsOutputPath, trace, screenshotPath, compress, conn, &emInst)
 			case "stop":
 				if emInst != nil {
 					emInst.Terminate()
 				}
 			case "keyboard":
-				// TODO
-
+				// {"type": "keyboard", "key": <keyCode>, "pressed": <bool>}
+				key, _ := message["key"].(float64)
+				pressed, _ := message["pressed"].(bool)
+				if emInst != nil {
+					emInst.KeyEvent(uint32(key), pressed)
+				}
 			case "mouse":
-				// TODO
-
+				// {"type": "mouse", "x": <x>, "y": <y>, "buttons": <MouseEvent.buttons>}
+				x, _ := message["x"].(float64)
+				y, _ := message["y"].(float64)
+				buttons, _ := message["buttons"].(float64)
+				if emInst != nil {
+					emInst.MouseEvent(int(x), int(y), uint32(buttons))
+				}
 			default:
 				log.Printf("Unknown message type: %s", mType)
 			}
@@ -255,6 +344,7 @@ func RunStandaloneWebserver(elfFilePath string, assemblyPath string) {
 	}
 
 	http.HandleFunc("/ws", handler)
+	http.HandleFunc("/screenshot.png", handleGetScreenshot)
 	http.HandleFunc("/", handleGetPage)
 	log.Println("Connect to the emulator at http://localhost:2035")
 	http.ListenAndServe(":2035", nil)
@@ -265,6 +355,18 @@ func handleGetPage(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(htmlPage))
 }
 
+func handleGetScreenshot(w http.ResponseWriter, r *http.Request) {
+	emulator := standaloneEmulator.Load()
+	if emulator == nil {
+		http.Error(w, "The emulator has not run yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(emulator.display.encodePNG())
+}
+
 var htmlPage = `<html>
 <head>
 	<title>RISCV Emulator</title>
@@ -272,6 +374,7 @@ var htmlPage = `<html>
 <body style="background-color: #1E1E1E;">
 	<h1 style="color: white; display: inline-block;">RISCV Emulator</h1>
 	<button id="runButton" style="margin-left: 50px; height: 40px; width: 80px;">RUN</button>
+	<a href="/screenshot.png" download="screenshot.png" style="margin-left: 20px; color: white;">Screenshot</a>
 	<br/>
 	<canvas width="1000px" height="700px" style="border: 2px solid white;" id="display"></canvas>
 	<h2 style="color: white;">Console</h2>
@@ -289,43 +392,63 @@ var htmlPage = `<html>
 		// When the socket is opened, listen for messages
 		socket.onopen = function() {
 			socket.onmessage = function(event) {
+				if (event.data instanceof ArrayBuffer) {
+					let buffer = event.data;
+					displayQueue = displayQueue.then(function() {
+						return readDisplayMessage(buffer).then(patchDisplay);
+					}).catch(function(e) {
+						console.log("Could not show the display: " + e);
+					});
+					return;
+				}
+
 				var data = JSON.parse(event.data);
 				if (data.type == "console") {
 					consoleText += data.text.replaceAll("\n", "<br/>");
 					document.getElementById("console").innerHTML = consoleText;
-				} else if (data.type == "display") {
-					let canvas = document.getElementById("display");
-
-					let dataWidth = data.width;
-					let dataHeight = data.height;
-
-					// if the canvas is not the same size as the data, resize it
-					if (canvas.width != dataWidth || canvas.height != dataHeight) {
-						canvas.width = dataWidth;
-						canvas.height = dataHeight;
-					}
-
-					var raw = window.atob(data.data);
-					var rawLength = raw.length;
-					var array = new Uint8Array(new ArrayBuffer(rawLength));
-
-					for(i = 0; i < rawLength; i++) {
-						array[i] = raw.charCodeAt(i);
-					}
-
-					var ctx = canvas.getContext("2d");
-					var imageData = ctx.createImageData(dataWidth, dataHeight);
-					for (var i = 0; i < dataWidth * dataHeight * 4; i++) {
-						imageData.data[i * 4 + 0] = array[i * 4 + 0]; // red
-						imageData.data[i * 4 + 1] = array[i * 4 + 1]; // green
-						imageData.data[i * 4 + 2] = array[i * 4 + 2]; // blue
-						imageData.data[i * 4 + 3] = array[i * 4 + 3]; // alpha
-					}
-					ctx.putImageData(imageData, 0, 0);
 				}
 			};
 		};
 
+		// the display is streamed as binary messages with the tiles drawn since the last one, see
+		// encodeDisplayTiles, they are patched in one at a time since decompressing finishes out of order
+		socket.binaryType = "arraybuffer";
+		var compressDisplay = "DecompressionStream" in window;
+		var displayQueue = Promise.resolve();
+
+		function readDisplayMessage(buffer) {
+			let message = new Uint8Array(buffer);
+			if ((message[0] & 1) == 0) {
+				return Promise.resolve(message.subarray(1));
+			}
+			let stream = new Blob([message.subarray(1)]).stream().pipeThrough(new DecompressionStream("deflate"));
+			return new Response(stream).arrayBuffer().then(function(body) { return new Uint8Array(body); });
+		}
+
+		function patchDisplay(body) {
+			let view = new DataView(body.buffer, body.byteOffset, body.byteLength);
+			let width = view.getUint32(0, true);
+			let height = view.getUint32(4, true);
+			let count = view.getUint32(8, true);
+
+			// if the canvas is not the same size as the display, resize it
+			let canvas = document.getElementById("display");
+			if (canvas.width != width || canvas.height != height) {
+				canvas.width = width;
+				canvas.height = height;
+			}
+
+			let ctx = canvas.getContext("2d");
+			let offset = 12;
+			for (let i = 0; i < count; i++) {
+				let x = view.getUint32(offset, true);
+				let y = view.getUint32(offset + 4, true);
+				let tile = new ImageData(new Uint8ClampedArray(body.buffer, body.byteOffset + offset + 8, 16 * 16 * 4), 16, 16);
+				ctx.putImageData(tile, x, y); // the tiles at the edges are clipped to the canvas
+				offset += 8 + 16 * 16 * 4;
+			}
+		}
+
 		// when the socket closes, try to reconnect every 3 seconds
 		socket.onclose = function() {
 			setTimeout(function() {
@@ -337,10 +460,42 @@ var htmlPage = `<html>
 		document.getElementById("runButton").onclick = function() {
 			consoleText = "";
 			socket.send(JSON.stringify({
-				type: "run"
+				type: "run",
+				compress: compressDisplay
 			}));
 		};
 
+		// forwarding keyboard input to the emulator, repeats are ignored since the key is still held
+		function sendKey(event, pressed) {
+			if (event.repeat || event.target.tagName == "BUTTON") {
+				return;
+			}
+			socket.send(JSON.stringify({
+				type: "keyboard",
+				key: event.keyCode,
+				pressed: pressed
+			}));
+			event.preventDefault();
+		}
+		document.addEventListener("keydown", function(event) { sendKey(event, true); });
+		document.addEventListener("keyup", function(event) { sendKey(event, false); });
+
+		// forwarding mouse input to the emulator in display pixel coordinates
+		var displayCanvas = document.getElementById("display");
+		function sendMouse(event) {
+			let rect = displayCanvas.getBoundingClientRect();
+			socket.send(JSON.stringify({
+				type: "mouse",
+				x: Math.floor((event.clientX - rect.left) * displayCanvas.width / rect.width),
+				y: Math.floor((event.clientY - rect.top) * displayCanvas.height / rect.height),
+				buttons: event.buttons
+			}));
+		}
+		displayCanvas.addEventListener("mousemove", sendMouse);
+		displayCanvas.addEventListener("mousedown", sendMouse);
+		displayCanvas.addEventListener("mouseup", sendMouse);
+		displayCanvas.addEventListener("contextmenu", function(event) { event.preventDefault(); });
+
 	</script>
 </body>
 </html>`
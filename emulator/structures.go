@@ -1,11 +1,16 @@
 package emulator
 
-import "sync"
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
 
 type MemoryPage struct {
 	Block       [1024]uint32
 	StartAddr   uint32
 	Initialized [1024]bool
+	shared      atomic.Bool // set once the page is in more than one image, it is then copied on the first write
 }
 
 type MemoryImage struct {
@@ -24,9 +29,17 @@ type EmulatorConfig struct {
 	RuntimeErrorCallback    func(RuntimeException)
 	StdOutCallback          func(byte)
 	RandomSeed              uint32
+	FileSystem              *VirtualFileSystem     // optional, each emulator gets a copy-on-write clone
+	TrapMode                bool                   // faults trap to the program's mtvec handler, see traps.go
+	Harts                   int                    // number of harts sharing the memory, defaults to 1, see harts.go
+	DisableDecodeCache      bool                   // decode every instruction when it is fetched, see decodeCache.go
+	Caches                  *CacheHierarchyConfig  // simulated caches, nil to disable, must pass Validate, see cacheSim.go
+	Pipeline                *PipelineConfig        // pipeline timing model, nil to disable, see pipeline.go
+	BranchPredictor         *BranchPredictorConfig // nil to disable, must pass Validate, see branchPredictor.go
 }
 
 type RuntimeException struct {
+	hart      uint32
 	regs      [32]uint32
 	pc        uint32
 	callStack []uint32
@@ -39,12 +52,42 @@ type VirtualDisplay struct {
 	dataMutex       sync.Mutex
 	width           int
 	height          int
-	shapeDrawParams [4]uint32
+	shapeDrawParams [6]uint32 // P0-P5 of the draw commands, see draw.go
 	displayWrites   int64
+	extent          int // the pixels the program wrote are all before it, data and back are zero past it
+
+	// double buffering, see peripherals.go
+	back           []uint32   // nil unless double buffered, the program draws here instead of data
+	backRegions    [8200]bool // the regions of the back buffer drawn since the last present
+	backWrites     int64
+	frames         uint32 // presented
+	refreshCounter uint32 // user instructions since the last refresh
+	refreshes      uint32 // since the last present
+}
+
+type VirtualKeyboard struct {
+	mutex    sync.Mutex
+	keyState [8]uint32 // bit n of the bitmap is set while key code n is held down
+	events   []uint32
+}
+
+type VirtualMouse struct {
+	mutex   sync.Mutex
+	x       int32
+	y       int32
+	buttons uint32
 }
 
 type VirtualFileSystem struct {
-	data []uint32
+	files     map[string]*VirtualFile
+	openFiles map[uint32]*openVirtualFile
+
+	// where the files are mapped in the reserved region, see filesystem.go
+	layout        []*VirtualFile
+	offsets       []uint32
+	directorySize uint32
+	mappedSize    uint32
+	layoutStale   bool
 }
 
 type Interrupt struct {
@@ -57,13 +100,32 @@ type Interrupt struct {
 	callStack []uint32
 }
 
+// The architectural state of a single hart
+type hartContext struct {
+	hartID            uint32
+	running           bool
+	registers         [32]uint32
+	regInit           uint32
+	fregisters        [32]uint32 // raw bits of the F extension registers
+	fregInit          uint32
+	fflags            uint32 // accrued floating point exceptions, the low 5 bits of fcsr
+	frm               uint32 // dynamic rounding mode, the upper 3 bits of fcsr
+	pc                uint32
+	instructionLength uint32            // 2 for compressed instructions, otherwise 4, see compressed.go
+	csrs              map[uint32]uint32 // storage for every CSR that is not a counter, see csr.go
+	trap              *pendingTrap      // raised by the current instruction
+	callStack         []uint32
+}
+
 type EmulatorInstance struct {
-	registers               [32]uint32
+	hartContext             // the running hart, the others are saved in harts
 	memory                  *MemoryImage
-	pc                      uint32
-	regInit                 uint32
 	iCache                  *MemoryPage
 	dCache                  *MemoryPage
+	decodedPages            map[uint32]*decodedPage // predecoded instructions by page number, see decodeCache.go
+	decodedPage             *decodedPage            // the page of the last fetch
+	decodedPageNum          uint32
+	decodeCacheDisabled     bool
 	runtimeLimit            uint32 // Note: this does not apply inside the profile ignore range
 	osEntry                 uint32
 	osGlobalPointer         uint32
@@ -71,11 +133,24 @@ type EmulatorInstance struct {
 	executedInstructions    uint64
 	userGlobalPointer       uint32
 	isInOSCode              bool
+	isInOSSetup             bool // running the OS setup before the program, interrupts are held until it returns
 	exitCode                int
 	heapPointer             uint32 // incremented as additional sbrks are called
 	wasEcall                bool   // signals that modified registers must be writen to
 	oldFramePointer         uint32
 	registerPreservation    [32]uint32
+	cycleOffset             uint64 // mcycle/minstret are relative to executedInstructions
+	instretOffset           uint64
+	trapMode                bool // faults trap to mtvec instead of being reported, see traps.go
+	exited                  bool // set by the exit ECALL, which ends the program from any hart
+
+	// harts, see harts.go
+	harts          []hartContext // saved state of every hart, the entry of the running hart is stale
+	reservations   []uint32      // address reserved by lr.w for each hart, noReservation if there is none
+	hartQuantum    uint32        // instructions left before switching to the next hart
+	scheduler      *rand.Rand    // picks the quantum lengths, seeded with the random seed
+	schedulerDraws uint64        // quantum lengths drawn so far, replayed when restoring a snapshot
+	hartStart      [3]uint32     // start address, argument, and stack pointer used by the next hart start
 
 	// assignments
 	randomSeed       uint32
@@ -83,8 +158,19 @@ type EmulatorInstance struct {
 
 	// peripherals
 	display   *VirtualDisplay
+	keyboard  *VirtualKeyboard
+	mouse     *VirtualMouse
 	fs        *VirtualFileSystem
-	interrupt *Interrupt
+	interrupt *Interrupt    // the interrupt currently being serviced
+	bus       peripheralBus // routes the reserved memory to the devices, see bus.go
+
+	// interrupt controller
+	interruptMutex      sync.Mutex
+	pendingInterrupts   []*Interrupt
+	hasPendingInterrupt atomic.Bool // checked every instruction without taking the mutex
+	interruptMask       uint32      // bit n enables the interrupt with ID n
+	timerPeriod         uint32      // in user instructions, 0 disables the timer
+	timerCounter        uint32
 
 	// statistics
 	profileIgnoreRangeStart uint32
@@ -95,17 +181,26 @@ type EmulatorInstance struct {
 	errors                  []RuntimeException
 
 	// debugging
-	callStack            []uint32
 	breakpoints          map[uint32]Breakpoint
 	registerBreakpoints  map[int]Breakpoint
 	memoryBreakpoints    map[uint32]Breakpoint
-	breakAddr            uint32 // for step over and step out
-	breakNext            bool   // for step into
+	breakAddr            uint32            // for step over and step out
+	breakNext            bool              // for step into
+	breakHart            uint32            // the hart being stepped
+	journal              *executionJournal // nil unless reverse debugging is enabled, see journal.go
+	tracer               *Tracer           // nil unless tracing, see tracer.go
+	caches               *cacheHierarchy   // nil unless simulating caches, see cacheSim.go
+	pipeline             *pipelineModel    // nil unless modeling the pipeline, see pipeline.go
+	predictor            *branchPredictor  // nil unless predicting branches, see branchPredictor.go
 	stdOutCallback       func(byte)
 	runtimeErrorCallback func(RuntimeException)
 	breakCallback        func(*EmulatorInstance, int, string) // int is breakpoint ID, string is reason
 	terminated           bool
-	lastUsedRegisters    map[int]int // we only want a set, but go only exposes a map so we have the superfluous int value
+	executing            bool   // from the fetch until the instruction retires, snapshots cannot be taken then
+	checkingBreakpoints  bool   // while checkShouldBreak runs, a snapshot taken then resumes at the check
+	resumeAtBreakCheck   bool   // set by Restore, the first iteration only checks the breakpoints, see snapshot.go
+	branchTaken          bool   // whether the last branch executed was taken, which the pc cannot tell for a branch to the next instruction
+	lastUsedRegisters    uint32 // bit n is set when register n was used, a bitmap since it is updated on every register access
 }
 
 // Debugging
@@ -0,0 +1,388 @@
+package emulator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.gatech.edu/ECEInnovation/RISC-V-Emulator/assembler"
+)
+
+// Execution traces. When a tracer is set, every user instruction that retires is written to the trace with
+// its hart, pc, encoding, source line, the registers it wrote, and the memory it accessed, so two traces of
+// the same program can be diffed to find the first instruction where they diverge. The OS code is not traced,
+// but the registers an ECALL returns are written as part of the ECALL, and instructions that raise an
+// exception did not retire, so they are left out.
+//
+// The text format is one line per instruction:
+//
+//	<hart> <pc> <encoding> <disassembly> [line <n>] | <writes>
+//
+// where the writes are registers (x5=0x00000001, f1=0x3F800000) and memory accesses, loads as
+// r[0x00001040]=0x00000001 and stores as w[0x00001040]=0x00000001, with as many digits as the access size.
+//
+// The binary format is the magic "RVEMTRAC" and a little-endian uint32 format version, followed by the
+// instructions. Each one is the uint32 pc, the uint32 encoding, the uint8 length, then as uvarints the hart,
+// the 1-based source line (0 when unknown), and the number of register writes, each of which is a uint8
+// register (32 and up are the float registers) and its uint32 value, and then a uvarint number of memory
+// accesses, each of which is a uint8 size in bytes (with bit 7 set for stores), the uint32 address, and the
+// uint32 value. The disassembly is left out since it follows from the encoding, DecodeTrace turns a binary
+// trace into the text format.
+
+const traceMagic = "RVEMTRAC"
+const traceVersion = 1
+
+type TraceFormat int
+
+const (
+	TraceText TraceFormat = iota
+	TraceBinary
+)
+
+// Returns the trace format with the given name, text or binary
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	switch name {
+	case "text":
+		return TraceText, nil
+	case "binary":
+		return TraceBinary, nil
+	}
+	return 0, fmt.Errorf("unknown trace format %q, expected text or binary", name)
+}
+
+// Where and how to write an execution trace
+type TraceOptions struct {
+	Path   string // no trace is written when empty
+	Format TraceFormat
+}
+
+type traceRegister struct {
+	reg   uint8 // 32 and up are the float registers
+	value uint32
+}
+
+type traceAccess struct {
+	addr  uint32
+	value uint32
+	size  uint8 // in bytes
+	store bool
+}
+
+type traceRecord struct {
+	hart      uint32
+	pc        uint32
+	encoding  uint32 // compressed instructions are not expanded
+	length    uint32
+	line      int // 1-based source line, 0 when unknown
+	registers []traceRegister
+	accesses  []traceAccess
+}
+
+type Tracer struct {
+	w            *bufio.Writer
+	format       TraceFormat
+	source       *assembler.AssembledResult // for the source lines, may be nil
+	sourceOffset uint32                     // the address the source was loaded at
+	err          error
+	buf          []byte
+
+	// the last instruction is written once the next one starts, since an ECALL's results are only in the
+	// registers once the OS returns
+	record     traceRecord
+	pending    bool
+	active     bool   // recording the memory accesses of the running instruction
+	regWrites  uint32 // bit n is set when the instruction wrote register n, even with the value it had
+	fregWrites uint32
+	registers  [32]uint32 // the registers of the hart before the instruction
+	fregisters [32]uint32
+}
+
+// Creates a tracer that writes to w, source is the assembled program loaded at sourceOffset, which is used to
+// look up the source line of each instruction and can be nil
+func NewTracer(w io.Writer, format TraceFormat, source *assembler.AssembledResult, sourceOffset uint32) *Tracer {
+	t := &Tracer{
+		w:            bufio.NewWriter(w),
+		format:       format,
+		source:       source,
+		sourceOffset: sourceOffset,
+	}
+	if format == TraceBinary {
+		t.buf = append([]byte(traceMagic), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(t.buf[len(traceMagic):], traceVersion)
+		_, t.err = t.w.Write(t.buf)
+	}
+	return t
+}
+
+// Traces every user instruction that retires from now on, or stops tracing if t is nil
+func (inst *EmulatorInstance) SetTracer(t *Tracer) {
+	if inst.tracer != nil {
+		inst.tracer.end(inst)
+	}
+	inst.tracer = t
+}
+
+// Writes out the buffered trace and returns the first error writing it
+func (t *Tracer) Flush() error {
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+// Called after the instruction is fetched, starts recording it if it is a user instruction
+func (t *Tracer) begin(inst *EmulatorInstance, decoded decodedInstruction) {
+	t.end(inst)
+	if inst.pc >= inst.profileIgnoreRangeStart && inst.pc < inst.profileIgnoreRangeEnd {
+		return
+	}
+
+	encoding := decoded.instruction
+	if decoded.length == 2 {
+		encoding = inst.peekWord(inst.pc&^0x3) >> ((inst.pc & 0x2) * 8) & 0xFFFF
+	}
+
+	line := 0
+	if t.source != nil && inst.pc >= t.sourceOffset {
+		if l, ok := t.source.AddressToLine[inst.pc-t.sourceOffset]; ok {
+			line = l + 1
+		}
+	}
+
+	t.record = traceRecord{
+		hart:      inst.hartID,
+		pc:        inst.pc,
+		encoding:  encoding,
+		length:    decoded.length,
+		line:      line,
+		registers: t.record.registers[:0],
+		accesses:  t.record.accesses[:0],
+	}
+	t.active = true
+	t.regWrites = 0
+	t.fregWrites = 0
+	t.registers = inst.registers
+	t.fregisters = inst.fregisters
+}
+
+// Called after the instruction executed, an instruction that raised an exception did not retire
+func (t *Tracer) executed(inst *EmulatorInstance) {
+	t.pending = t.active && inst.trap == nil
+	t.active = false
+}
+
+// Writes the last instruction with the registers of its hart as they are now
+func (t *Tracer) end(inst *EmulatorInstance) {
+	if !t.pending {
+		return
+	}
+	t.pending = false
+
+	hart := inst.getHart(t.record.hart)
+	for reg := uint32(1); reg < 32; reg++ {
+		if hart.registers[reg] != t.registers[reg] || t.regWrites&(1<<reg) != 0 {
+			t.record.registers = append(t.record.registers, traceRegister{uint8(reg), hart.registers[reg]})
+		}
+	}
+	for reg := uint32(0); reg < 32; reg++ {
+		if hart.fregisters[reg] != t.fregisters[reg] || t.fregWrites&(1<<reg) != 0 {
+			t.record.registers = append(t.record.registers, traceRegister{uint8(32 + reg), hart.fregisters[reg]})
+		}
+	}
+
+	if t.format == TraceBinary {
+		t.buf = appendBinaryTraceRecord(t.buf[:0], &t.record)
+	} else {
+		t.buf = appendTextTraceRecord(t.buf[:0], &t.record)
+	}
+	if t.err == nil {
+		_, t.err = t.w.Write(t.buf)
+	}
+}
+
+func (t *Tracer) memAccess(addr, size, value uint32, store bool) {
+	if !t.active {
+		return
+	}
+	if size < 4 {
+		value &= 1<<(size*8) - 1
+	}
+	t.record.accesses = append(t.record.accesses, traceAccess{addr, value, uint8(size), store})
+}
+
+func appendTextTraceRecord(buf []byte, record *traceRecord) []byte {
+	encoding := fmt.Sprintf("%08X", record.encoding)
+	if record.length == 2 {
+		encoding = fmt.Sprintf("%04X    ", record.encoding)
+	}
+	buf = fmt.Appendf(buf, "%d 0x%08X %s %-28s", record.hart, record.pc, encoding, assembler.Disassemble(record.encoding))
+	if record.line != 0 {
+		buf = fmt.Appendf(buf, " line %-5d", record.line)
+	}
+	buf = append(buf, " |"...)
+
+	for _, r := range record.registers {
+		if r.reg < 32 {
+			buf = fmt.Appendf(buf, " x%d=0x%08X", r.reg, r.value)
+		} else {
+			buf = fmt.Appendf(buf, " f%d=0x%08X", r.reg-32, r.value)
+		}
+	}
+	for _, a := range record.accesses {
+		kind := "r"
+		if a.store {
+			kind = "w"
+		}
+		buf = fmt.Appendf(buf, " %s[0x%08X]=0x%0*X", kind, a.addr, int(a.size)*2, a.value)
+	}
+
+	return append(buf, '\n')
+}
+
+func appendBinaryTraceRecord(buf []byte, record *traceRecord) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, record.pc)
+	buf = binary.LittleEndian.AppendUint32(buf, record.encoding)
+	buf = append(buf, uint8(record.length))
+	buf = binary.AppendUvarint(buf, uint64(record.hart))
+	buf = binary.AppendUvarint(buf, uint64(record.line))
+
+	buf = binary.AppendUvarint(buf, uint64(len(record.registers)))
+	for _, r := range record.registers {
+		buf = append(buf, r.reg)
+		buf = binary.LittleEndian.AppendUint32(buf, r.value)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(record.accesses)))
+	for _, a := range record.accesses {
+		kind := a.size
+		if a.store {
+			kind |= 0x80
+		}
+		buf = append(buf, kind)
+		buf = binary.LittleEndian.AppendUint32(buf, a.addr)
+		buf = binary.LittleEndian.AppendUint32(buf, a.value)
+	}
+
+	return buf
+}
+
+// Converts a binary trace to the text format
+func DecodeTrace(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(traceMagic)+4)
+	if _, e := io.ReadFull(br, header); e != nil {
+		return fmt.Errorf("not a binary trace: %w", e)
+	}
+	if string(header[:len(traceMagic)]) != traceMagic {
+		return errors.New("not a binary trace: missing RVEMTRAC header")
+	}
+	if version := binary.LittleEndian.Uint32(header[len(traceMagic):]); version != traceVersion {
+		return fmt.Errorf("unsupported trace version %d, expected %d", version, traceVersion)
+	}
+
+	bw := bufio.NewWriter(w)
+	record := traceRecord{}
+	buf := []byte{}
+	for {
+		if _, e := br.Peek(1); e == io.EOF {
+			break
+		}
+
+		e := readBinaryTraceRecord(br, &record)
+		if e != nil {
+			return fmt.Errorf("corrupt trace: %w", e)
+		}
+		buf = appendTextTraceRecord(buf[:0], &record)
+		if _, e := bw.Write(buf); e != nil {
+			return e
+		}
+	}
+	return bw.Flush()
+}
+
+func readBinaryTraceRecord(r *bufio.Reader, record *traceRecord) error {
+	fixed := make([]byte, 9)
+	if _, e := io.ReadFull(r, fixed); e != nil {
+		return e
+	}
+	record.pc = binary.LittleEndian.Uint32(fixed)
+	record.encoding = binary.LittleEndian.Uint32(fixed[4:])
+	record.length = uint32(fixed[8])
+
+	hart, e := binary.ReadUvarint(r)
+	if e != nil {
+		return e
+	}
+	line, e := binary.ReadUvarint(r)
+	if e != nil {
+		return e
+	}
+	record.hart = uint32(hart)
+	record.line = int(line)
+
+	entry := make([]byte, 9)
+	numRegisters, e := binary.ReadUvarint(r)
+	if e != nil {
+		return e
+	} else if numRegisters > 64 {
+		return fmt.Errorf("%d register writes in one instruction", numRegisters)
+	}
+	record.registers = record.registers[:0]
+	for i := uint64(0); i < numRegisters; i++ {
+		if _, e := io.ReadFull(r, entry[:5]); e != nil {
+			return e
+		}
+		record.registers = append(record.registers, traceRegister{entry[0], binary.LittleEndian.Uint32(entry[1:])})
+	}
+
+	numAccesses, e := binary.ReadUvarint(r)
+	if e != nil {
+		return e
+	} else if numAccesses > snapshotMaxLength {
+		return fmt.Errorf("%d memory accesses in one instruction", numAccesses)
+	}
+	record.accesses = record.accesses[:0]
+	for i := uint64(0); i < numAccesses; i++ {
+		if _, e := io.ReadFull(r, entry); e != nil {
+			return e
+		}
+		record.accesses = append(record.accesses, traceAccess{
+			addr:  binary.LittleEndian.Uint32(entry[1:]),
+			value: binary.LittleEndian.Uint32(entry[5:]),
+			size:  entry[0] & 0x7F,
+			store: entry[0]&0x80 != 0,
+		})
+	}
+
+	return nil
+}
+
+// Returns the path of the trace of the run with the given seed, batch runs write one trace per seed
+func tracePathForSeed(path string, seed uint32) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), seed, ext)
+}
+
+// Creates a trace file and traces the emulator to it, the returned function stops tracing and closes the file
+func traceToFile(inst *EmulatorInstance, path string, format TraceFormat, source *assembler.AssembledResult, sourceOffset uint32) (func() error, error) {
+	f, e := os.Create(path)
+	if e != nil {
+		return nil, e
+	}
+
+	t := NewTracer(f, format, source, sourceOffset)
+	inst.SetTracer(t)
+	return func() error {
+		inst.SetTracer(nil)
+		if e := t.Flush(); e != nil {
+			f.Close()
+			return e
+		}
+		return f.Close()
+	}, nil
+}
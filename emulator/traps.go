@@ -0,0 +1,101 @@
+package emulator
+
+// Machine-mode traps. When trap mode is enabled and the program has installed a handler in mtvec, faults
+// are no longer reported as runtime exceptions. Instead mepc, mcause and mtval are set and execution
+// continues at the handler, which returns with mret. The faulting instruction does not write its
+// destination register. ECALLs the emulator does not handle itself (exit, sbrk, the filesystem, etc.)
+// trap with cause 11 instead of going to the OS ECALL handler. Diagnostics that are not faults on real
+// hardware, such as reading uninitialized memory, are still reported as runtime exceptions.
+
+const (
+	TrapCauseInstructionMisaligned = 0
+	TrapCauseInstructionFault      = 1
+	TrapCauseIllegalInstruction    = 2
+	TrapCauseBreakpoint            = 3
+	TrapCauseLoadMisaligned        = 4
+	TrapCauseLoadFault             = 5
+	TrapCauseStoreMisaligned       = 6
+	TrapCauseStoreFault            = 7
+	TrapCauseEcallMachine          = 11
+)
+
+const (
+	csrMstatus = 0x300
+	csrMtvec   = 0x305
+	csrMepc    = 0x341
+	csrMcause  = 0x342
+	csrMtval   = 0x343
+)
+
+const (
+	mstatusMIE  = 1 << 3
+	mstatusMPIE = 1 << 7
+	mstatusMPP  = 0b11 << 11
+)
+
+type pendingTrap struct {
+	cause uint32
+	tval  uint32
+	epc   uint32
+}
+
+func (inst *EmulatorInstance) trapsEnabled() bool {
+	return inst.trapMode && inst.csrRead(csrMtvec)&^0b11 != 0
+}
+
+// Raises a trap if traps are enabled, otherwise reports the runtime exception like newException. Only the
+// first trap raised by an instruction is taken.
+func (inst *EmulatorInstance) newTrapException(cause, tval uint32, format string, args ...interface{}) RuntimeException {
+	if !inst.trapsEnabled() {
+		return inst.newException(format, args...)
+	}
+
+	if inst.trap == nil {
+		inst.trap = &pendingTrap{cause: cause, tval: tval, epc: inst.pc}
+	}
+	return RuntimeException{}
+}
+
+// Jumps to the trap handler, called after the faulting instruction. Memory faults are always raised with the
+// load causes, isStore and isFetch select the matching store or instruction fetch cause.
+func (inst *EmulatorInstance) takeTrap(isStore, isFetch bool) {
+	trap := inst.trap
+	inst.trap = nil
+
+	cause := trap.cause
+	if cause == TrapCauseLoadMisaligned || cause == TrapCauseLoadFault {
+		if isFetch {
+			cause -= TrapCauseLoadMisaligned
+		} else if isStore {
+			cause += TrapCauseStoreMisaligned - TrapCauseLoadMisaligned
+		}
+	}
+
+	inst.csrWrite(csrMepc, trap.epc)
+	inst.csrWrite(csrMcause, cause)
+	inst.csrWrite(csrMtval, trap.tval)
+
+	// interrupts are disabled in the handler, the previous state is restored by mret
+	mstatus := inst.csrRead(csrMstatus)
+	mstatus &^= mstatusMPIE
+	if mstatus&mstatusMIE != 0 {
+		mstatus |= mstatusMPIE
+	}
+	mstatus = mstatus&^mstatusMIE | mstatusMPP
+	inst.csrWrite(csrMstatus, mstatus)
+
+	// synchronous exceptions go to the base address in both direct and vectored mode
+	inst.pc = inst.csrRead(csrMtvec)&^0b11 - inst.instructionLength
+}
+
+func (inst *EmulatorInstance) executeMRET() {
+	mstatus := inst.csrRead(csrMstatus)
+	mstatus &^= mstatusMIE
+	if mstatus&mstatusMPIE != 0 {
+		mstatus |= mstatusMIE
+	}
+	mstatus |= mstatusMPIE
+	inst.csrWrite(csrMstatus, mstatus)
+
+	inst.pc = inst.csrRead(csrMepc) - inst.instructionLength
+}
@@ -16,6 +16,21 @@ import (
 
 func main() {
 	specialRegisters := flag.String("specialregisters", "", "A comma-separated list of special registers to throw a warning if modified")
+	fileSystemPath := flag.String("fs", "", "A host directory or FAT image to mount as the virtual filesystem when running")
+	fileSystemOutputPath := flag.String("fsout", "", "A host directory to save the files written by the program to (runELF only)")
+	trapMode := flag.Bool("traps", false, "Faults trap to the program's mtvec handler instead of stopping the emulator (runBatch only)")
+	harts := flag.Int("harts", 1, "The number of harts sharing the memory, the seed picks the interleaving (runBatch only)")
+	tracePath := flag.String("trace", "", "A file to write the execution trace to, runBatch appends the seed to the name (runELF and runBatch)")
+	traceFormat := flag.String("traceformat", "text", "The format of the execution trace, text or binary")
+	cacheConfigPath := flag.String("caches", "", "A JSON file with the L1 and L2 caches to simulate (runBatch only)")
+	modelPipeline := flag.Bool("pipeline", false, "Count the cycles and stalls of a 5-stage pipeline (runBatch only)")
+	forwarding := flag.Bool("forwarding", true, "Whether the modeled pipeline forwards results to later instructions")
+	predictorType := flag.String("predictor", "", "The branch predictor to evaluate: taken, nottaken, 1bit, 2bit, or gshare (runBatch only)")
+	predictorEntries := flag.Int("predictorentries", 0, "The entries of the 1bit, 2bit, or gshare table, defaults to 1024")
+	historyBits := flag.Int("historybits", 0, "The bits of global history of gshare, defaults to 8")
+	btbEntries := flag.Int("btbentries", 0, "The entries of the branch target buffer, no BTB when 0")
+	screenshotPath := flag.String("screenshot", "", "A PNG file to save the final frame of the display to, runBatch appends the seed to the name (runELF and runBatch)")
+	frameCounts := flag.String("frames", "", "A comma-separated list of instruction counts to also save the frame at, appended to the name after the seed (runBatch only)")
 
 	flag.Parse()
 
@@ -25,12 +40,53 @@ func main() {
 		SpecialRegisters: strings.Split((*specialRegisters), ","),
 	})
 
+	var fs *emulator.VirtualFileSystem
+	if *fileSystemPath != "" {
+		var e error
+		fs, e = emulator.MountFileSystem(*fileSystemPath)
+		if e != nil {
+			log.Fatalf("Could not mount filesystem: %v", e)
+		}
+	}
+
+	format, e := emulator.ParseTraceFormat(*traceFormat)
+	if e != nil {
+		log.Fatalf("Invalid trace format: %v", e)
+	}
+	trace := emulator.TraceOptions{Path: *tracePath, Format: format}
+
+	var caches *emulator.CacheHierarchyConfig
+	if *cacheConfigPath != "" {
+		caches, e = emulator.LoadCacheConfig(*cacheConfigPath)
+		if e != nil {
+			log.Fatalf("Could not load cache configuration: %v", e)
+		}
+	}
+
+	var pipeline *emulator.PipelineConfig
+	if *modelPipeline {
+		pipeline = &emulator.PipelineConfig{Forwarding: *forwarding}
+	}
+
+	var predictor *emulator.BranchPredictorConfig
+	if *predictorType != "" {
+		predictor = &emulator.BranchPredictorConfig{
+			Type:        emulator.BranchPredictorType(*predictorType),
+			Entries:     *predictorEntries,
+			HistoryBits: *historyBits,
+			BTBEntries:  *btbEntries,
+		}
+		if e := predictor.Validate(); e != nil {
+			log.Fatalf("Invalid branch predictor: %v", e)
+		}
+	}
+
 	if autograder.GetConfig() != nil {
 		conf := autograder.GetConfig()
 		if conf.Mode == "c" {
 			autograder.AutogradeCCode(conf.AssignmentCodeDir, conf.StudentCodePath, conf.TestCases)
 		} else if conf.Mode == "asm" {
-			// TODO
+			autograder.AutogradeAssembly(conf.AssignmentCodeDir, conf.StudentCodePath, conf.TestCases)
 		} else {
 			log.Fatalln("Invalid autograding mode:", conf.Mode)
 		}
@@ -59,7 +115,7 @@ func main() {
 			assemblyPath = os.Args[3]
 		}
 		// run the elf file
-		emulator.RunStandaloneWebserver(filePath, assemblyPath)
+		emulator.RunStandaloneWebserver(filePath, assemblyPath, fs, *fileSystemOutputPath, trace, *screenshotPath)
 	} else if len(args) == 0 {
 		// run as language server but in tcp mode so it can be remotely debugged
 		languageServer.ListenAndServeTCP()
@@ -73,7 +129,42 @@ func main() {
 			seedInts = append(seedInts, uint32(v))
 		}
 
-		emulator.BatchRun(elfFilePath, asmFilePath, seedInts, true)
+		screenshots := emulator.ScreenshotOptions{Final: *screenshotPath != ""}
+		if *screenshotPath != "" && *frameCounts != "" {
+			for _, s := range strings.Split(*frameCounts, ",") {
+				v, e := strconv.Atoi(s)
+				if e != nil || v < 0 {
+					log.Fatalf("Invalid instruction count %q in -frames", s)
+				}
+				screenshots.AtDI = append(screenshots.AtDI, v)
+			}
+		}
+
+		results, _ := emulator.BatchRunWithOptions(elfFilePath, asmFilePath, seedInts, true, emulator.BatchRunOptions{
+			FileSystem:  fs,
+			TrapMode:    *trapMode,
+			Harts:       *harts,
+			Trace:       trace,
+			Caches:      caches,
+			Pipeline:    pipeline,
+			Predictor:   predictor,
+			Screenshots: screenshots,
+		})
+		for _, result := range results {
+			if e := result.SaveFrames(*screenshotPath); e != nil {
+				log.Fatalf("Could not save the frames of seed %d: %v", result.Seed, e)
+			}
+		}
+	} else if len(args) == 2 && args[0] == "decodeTrace" {
+		// convert a binary execution trace to the text format
+		f, e := os.Open(args[1])
+		if e != nil {
+			log.Fatalf("Could not open trace %s: %v", args[1], e)
+		}
+		defer f.Close()
+		if e := emulator.DecodeTrace(f, os.Stdout); e != nil {
+			log.Fatalf("Could not decode trace %s: %v", args[1], e)
+		}
 	} else {
 		log.Fatalln("Invalid arguments:", os.Args)
 	}